@@ -0,0 +1,52 @@
+package http
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/apikey/usecase"
+	"github.com/halilylm/microservice/pkg/rest"
+	"net/http"
+	"time"
+)
+
+type apiKeyHandler struct {
+	uc usecase.APIKeyUseCase
+}
+
+// issueAPIKeyRequest holds the fields a client may set, the rest of the key
+// is filled in by the server.
+type issueAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=products:read products:write products:import categories:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiKeyIDRequest struct {
+	ID int64 `path:"id"`
+}
+
+func NewAPIKeyHandler(uc usecase.APIKeyUseCase, r chi.Router) {
+	handler := apiKeyHandler{uc: uc}
+	r.Post("/", rest.Handle(handler.IssueAPIKey, rest.WithStatus(http.StatusCreated)))
+	r.Get("/", rest.Handle(handler.ListAPIKeys))
+	r.Options("/", rest.Options)
+	r.Delete("/{id:[0-9]+}", rest.Handle(handler.RevokeAPIKey, rest.WithStatus(http.StatusNoContent)))
+	r.Options("/{id:[0-9]+}", rest.Options)
+}
+
+func (h *apiKeyHandler) IssueAPIKey(ctx context.Context, req issueAPIKeyRequest) (*apikey.IssuedKey, error) {
+	return h.uc.Issue(ctx, &apikey.APIKey{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+}
+
+func (h *apiKeyHandler) ListAPIKeys(ctx context.Context, _ struct{}) ([]*apikey.APIKey, error) {
+	return h.uc.List(ctx)
+}
+
+func (h *apiKeyHandler) RevokeAPIKey(ctx context.Context, req apiKeyIDRequest) (rest.NoContent, error) {
+	return rest.NoContent{}, h.uc.Revoke(ctx, req.ID)
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/apikey/repository"
+	"github.com/halilylm/microservice/apikey/usecase"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setupRouter() (chi.Router, *repository.MockAPIKeyRepository) {
+	repo := repository.NewMockAPIKeyRepository()
+	uc := usecase.NewAPIKeyUC(repo, repository.NewMockUsageRepository(), zap.NewNop())
+	r := chi.NewRouter()
+	NewAPIKeyHandler(uc, r)
+	return r, repo
+}
+
+func TestAPIKeyHandler_IssueAPIKey(t *testing.T) {
+	r, repo := setupRouter()
+	t.Run("issues a key", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "partner", "scopes": ["products:read"]}`)
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusCreated, res.Code)
+		var issued map[string]any
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&issued))
+		assert.NotEmpty(t, issued["key"])
+		assert.NotContains(t, issued, "Hash")
+		assert.Equal(t, 1, len(repo.Keys()))
+	})
+	t.Run("rejects server managed fields", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "partner", "scopes": ["products:read"], "prefix": "pk_mine"}`)
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, 1, len(repo.Keys()))
+	})
+	t.Run("rejects unknown scopes", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "partner", "scopes": ["apikeys:manage"]}`)
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, 1, len(repo.Keys()))
+	})
+}
+
+func TestAPIKeyHandler_RevokeAPIKey(t *testing.T) {
+	r, _ := setupRouter()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "partner", "scopes": ["products:read"]}`))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	t.Run("revokes a key", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodDelete, "/1", nil))
+		assert.Equal(t, http.StatusNoContent, res.Code)
+	})
+	t.Run("returns 404 for unknown keys", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodDelete, "/5", nil))
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+	t.Run("lists keys", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "revoked_at")
+	})
+}
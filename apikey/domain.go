@@ -0,0 +1,51 @@
+package apikey
+
+import "time"
+
+const (
+	ScopeProductsRead   = "products:read"
+	ScopeProductsWrite  = "products:write"
+	ScopeProductsImport = "products:import"
+	// ScopeCategoriesWrite allows changing the category tree, reading it
+	// takes ScopeProductsRead.
+	ScopeCategoriesWrite = "categories:write"
+	// ScopeManage allows issuing and revoking keys. It is granted through
+	// bearer tokens only and can not be given to an API key.
+	ScopeManage = "apikeys:manage"
+)
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name" validate:"required,max=255"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes" validate:"required,min=1,dive,oneof=products:read products:write products:import categories:write"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IssuedKey is returned once when a key is issued, it is the only time the
+// plain key is visible.
+type IssuedKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key is neither revoked nor expired at now.
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/apikey/repository"
+	"strconv"
+	"time"
+)
+
+const lastUsedKey = "apikeys:last_used"
+
+// drainScript reads and deletes the buffer atomically, so timestamps written
+// between the read and the delete are not lost.
+var drainScript = redis.NewScript(`
+local entries = redis.call('HGETALL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return entries
+`)
+
+type usageRepository struct {
+	client redis.UniversalClient
+}
+
+func NewUsageRepository(client redis.UniversalClient) repository.UsageRepository {
+	return &usageRepository{client: client}
+}
+
+func (r *usageRepository) MarkUsed(ctx context.Context, id int64, at time.Time) error {
+	return r.client.HSet(ctx, lastUsedKey, strconv.FormatInt(id, 10), at.Unix()).Err()
+}
+
+func (r *usageRepository) Drain(ctx context.Context) (map[int64]time.Time, error) {
+	entries, err := drainScript.Run(ctx, r.client, []string{lastUsedKey}).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int64]time.Time, len(entries)/2)
+	for i := 0; i+1 < len(entries); i += 2 {
+		id, err := strconv.ParseInt(entries[i], 10, 64)
+		if err != nil {
+			continue
+		}
+		sec, err := strconv.ParseInt(entries[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+		used[id] = time.Unix(sec, 0).UTC()
+	}
+	return used, nil
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/apikey/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func setupRedis(t *testing.T) repository.UsageRepository {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	return NewUsageRepository(client)
+}
+
+func TestUsageRepository_Drain(t *testing.T) {
+	usageRepo := setupRedis(t)
+	at := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, usageRepo.MarkUsed(context.TODO(), 1, at.Add(-time.Minute)))
+	assert.NoError(t, usageRepo.MarkUsed(context.TODO(), 1, at))
+	assert.NoError(t, usageRepo.MarkUsed(context.TODO(), 2, at))
+	used, err := usageRepo.Drain(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]time.Time{1: at, 2: at}, used)
+	used, err = usageRepo.Drain(context.TODO())
+	assert.NoError(t, err)
+	assert.Empty(t, used)
+}
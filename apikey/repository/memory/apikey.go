@@ -0,0 +1,104 @@
+// Package memory keeps the api keys and their usage in the process, for local
+// development. Everything is copied in and out so callers can't change the
+// stored keys.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/apikey/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+type APIKeyRepository struct {
+	mu     sync.RWMutex
+	byID   map[int64]*apikey.APIKey
+	byHash map[string]int64
+	nextID int64
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{
+		byID:   make(map[int64]*apikey.APIKey),
+		byHash: make(map[string]int64),
+	}
+}
+
+var _ repository.APIKeyRepository = (*APIKeyRepository)(nil)
+
+func (r *APIKeyRepository) Insert(ctx context.Context, k *apikey.APIKey) (*apikey.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	k.ID = r.nextID
+	r.byID[k.ID] = copyAPIKey(k)
+	r.byHash[k.Hash] = k.ID
+	return k, nil
+}
+
+// List returns the keys ordered by id.
+func (r *APIKeyRepository) List(ctx context.Context) ([]*apikey.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]*apikey.APIKey, 0, len(r.byID))
+	for _, k := range r.byID {
+		keys = append(keys, copyAPIKey(k))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (r *APIKeyRepository) GetByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byHash[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyAPIKey(r.byID[id]), nil
+}
+
+// Revoke reports the missing and the already revoked keys as sql.ErrNoRows,
+// like the mysql repository.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.byID[id]
+	if !ok || k.RevokedAt != nil {
+		return sql.ErrNoRows
+	}
+	k.RevokedAt = &at
+	return nil
+}
+
+// UpdateLastUsed only moves the last use forward.
+func (r *APIKeyRepository) UpdateLastUsed(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k, ok := r.byID[id]; ok && (k.LastUsedAt == nil || k.LastUsedAt.Before(at)) {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
+
+func copyAPIKey(k *apikey.APIKey) *apikey.APIKey {
+	copied := *k
+	copied.Scopes = append([]string(nil), k.Scopes...)
+	copied.ExpiresAt = copyTime(k.ExpiresAt)
+	copied.LastUsedAt = copyTime(k.LastUsedAt)
+	copied.RevokedAt = copyTime(k.RevokedAt)
+	return &copied
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
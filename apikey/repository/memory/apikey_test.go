@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	r := NewAPIKeyRepository()
+	k := &apikey.APIKey{Name: "partner", Prefix: "pk_abc", Hash: "hash", Scopes: []string{apikey.ScopeProductsRead}, CreatedAt: time.Now()}
+	_, err := r.Insert(context.TODO(), k)
+	require.NoError(t, err)
+	k.Scopes[0] = apikey.ScopeProductsWrite
+
+	read, err := r.GetByHash(context.TODO(), "hash")
+	require.NoError(t, err)
+	assert.Equal(t, []string{apikey.ScopeProductsRead}, read.Scopes)
+	_, err = r.GetByHash(context.TODO(), "other")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	used := time.Now()
+	assert.NoError(t, r.UpdateLastUsed(context.TODO(), k.ID, used))
+	assert.NoError(t, r.UpdateLastUsed(context.TODO(), k.ID, used.Add(-time.Hour)))
+	assert.Nil(t, read.LastUsedAt)
+	assert.NoError(t, r.Revoke(context.TODO(), k.ID, used))
+	assert.ErrorIs(t, r.Revoke(context.TODO(), k.ID, used), sql.ErrNoRows)
+	keys, err := r.List(context.TODO())
+	require.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, used, *keys[0].LastUsedAt)
+		assert.NotNil(t, keys[0].RevokedAt)
+	}
+}
+
+func TestAPIKeyRepository_Concurrency(t *testing.T) {
+	r := NewAPIKeyRepository()
+	k, err := r.Insert(context.TODO(), &apikey.APIKey{Name: "partner", Hash: "hash", Scopes: []string{apikey.ScopeProductsRead}})
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = r.UpdateLastUsed(context.TODO(), k.ID, time.Now())
+		}()
+		go func() {
+			defer wg.Done()
+			// the listed keys are read without the lock
+			keys, _ := r.List(context.TODO())
+			for _, k := range keys {
+				_ = k.LastUsedAt
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUsageRepository(t *testing.T) {
+	r := NewUsageRepository()
+	at := time.Now()
+	assert.NoError(t, r.MarkUsed(context.TODO(), 1, at))
+	assert.NoError(t, r.MarkUsed(context.TODO(), 1, at.Add(-time.Minute)))
+	used, err := r.Drain(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]time.Time{1: at}, used)
+	used, err = r.Drain(context.TODO())
+	assert.NoError(t, err)
+	assert.Empty(t, used)
+}
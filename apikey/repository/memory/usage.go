@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/apikey/repository"
+	"sync"
+	"time"
+)
+
+// UsageRepository buffers the last uses in the process, they are lost if it
+// stops before they are flushed.
+type UsageRepository struct {
+	mu   sync.Mutex
+	used map[int64]time.Time
+}
+
+func NewUsageRepository() *UsageRepository {
+	return &UsageRepository{used: make(map[int64]time.Time)}
+}
+
+var _ repository.UsageRepository = (*UsageRepository)(nil)
+
+// MarkUsed keeps the latest use of every key.
+func (r *UsageRepository) MarkUsed(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.used[id]; !ok || last.Before(at) {
+		r.used[id] = at
+	}
+	return nil
+}
+
+func (r *UsageRepository) Drain(ctx context.Context) (map[int64]time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used := r.used
+	r.used = make(map[int64]time.Time)
+	return used, nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/apikey"
+	"sync"
+	"time"
+)
+
+type MockAPIKeyRepository struct {
+	sync.Mutex
+	keys   map[int64]*apikey.APIKey
+	nextID int64
+}
+
+func NewMockAPIKeyRepository() *MockAPIKeyRepository {
+	return &MockAPIKeyRepository{keys: make(map[int64]*apikey.APIKey)}
+}
+
+func (m *MockAPIKeyRepository) Keys() map[int64]*apikey.APIKey {
+	m.Lock()
+	defer m.Unlock()
+	keys := make(map[int64]*apikey.APIKey, len(m.keys))
+	for id, k := range m.keys {
+		keys[id] = k
+	}
+	return keys
+}
+
+func (m *MockAPIKeyRepository) Insert(ctx context.Context, k *apikey.APIKey) (*apikey.APIKey, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.nextID++
+	k.ID = m.nextID
+	m.keys[k.ID] = k
+	return k, nil
+}
+
+func (m *MockAPIKeyRepository) List(ctx context.Context) ([]*apikey.APIKey, error) {
+	m.Lock()
+	defer m.Unlock()
+	keys := make([]*apikey.APIKey, 0, len(m.keys))
+	for id := int64(1); id <= m.nextID; id++ {
+		if k, ok := m.keys[id]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MockAPIKeyRepository) GetByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, k := range m.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockAPIKeyRepository) Revoke(ctx context.Context, id int64, at time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	k, ok := m.keys[id]
+	if !ok || k.RevokedAt != nil {
+		return sql.ErrNoRows
+	}
+	k.RevokedAt = &at
+	return nil
+}
+
+func (m *MockAPIKeyRepository) UpdateLastUsed(ctx context.Context, id int64, at time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	if k, ok := m.keys[id]; ok {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type MockUsageRepository struct {
+	mu   sync.Mutex
+	used map[int64]time.Time
+}
+
+func NewMockUsageRepository() *MockUsageRepository {
+	return &MockUsageRepository{used: make(map[int64]time.Time)}
+}
+
+func (m *MockUsageRepository) MarkUsed(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used[id] = at
+	return nil
+}
+
+func (m *MockUsageRepository) Drain(ctx context.Context) (map[int64]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used := m.used
+	m.used = make(map[int64]time.Time)
+	return used, nil
+}
@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/apikey/repository"
+	"strings"
+	"time"
+)
+
+const (
+	insertQuery         = `INSERT api_keys SET name=?, prefix=?, key_hash=?, scopes=?, expires_at=?, created_at=?`
+	listQuery           = `SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys ORDER BY id`
+	getByHashQuery      = `SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE key_hash=?`
+	revokeQuery         = `UPDATE api_keys SET revoked_at=? WHERE id=? AND revoked_at IS NULL`
+	updateLastUsedQuery = `UPDATE api_keys SET last_used_at=? WHERE id=? AND (last_used_at IS NULL OR last_used_at < ?)`
+)
+
+type apiKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) repository.APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+func (r *apiKeyRepository) Insert(ctx context.Context, k *apikey.APIKey) (*apikey.APIKey, error) {
+	res, err := r.db.ExecContext(ctx, insertQuery, k.Name, k.Prefix, k.Hash, strings.Join(k.Scopes, ","), nullTime(k.ExpiresAt), k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	k.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (r *apiKeyRepository) List(ctx context.Context) ([]*apikey.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, listQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*apikey.APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *apiKeyRepository) GetByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	k, err := scanAPIKey(r.db.QueryRowContext(ctx, getByHashQuery, hash))
+	if err != nil {
+		return nil, err
+	}
+	k.Hash = hash
+	return k, nil
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id int64, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, revokeQuery, at, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) UpdateLastUsed(ctx context.Context, id int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, updateLastUsedQuery, at, id, at)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (*apikey.APIKey, error) {
+	var (
+		k                              apikey.APIKey
+		scopes                         string
+		expiresAt, lastUsed, revokedAt sql.NullTime
+	)
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &expiresAt, &lastUsed, &revokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	k.ExpiresAt = timePtr(expiresAt)
+	k.LastUsedAt = timePtr(lastUsed)
+	k.RevokedAt = timePtr(revokedAt)
+	return &k, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
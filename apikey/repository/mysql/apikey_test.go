@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var columns = []string{"id", "name", "prefix", "scopes", "expires_at", "last_used_at", "revoked_at", "created_at"}
+
+func TestAPIKeyRepository_Insert(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	k := apikey.APIKey{
+		Name:      "partner",
+		Prefix:    "pk_abcdefgh",
+		Hash:      "hash",
+		Scopes:    []string{apikey.ScopeProductsRead, apikey.ScopeProductsWrite},
+		CreatedAt: time.Now(),
+	}
+	mock.ExpectExec(insertQuery).
+		WithArgs(k.Name, k.Prefix, k.Hash, "products:read,products:write", sql.NullTime{}, k.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	created, err := NewAPIKeyRepository(db).Insert(context.TODO(), &k)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, created.ID)
+}
+
+func TestAPIKeyRepository_GetByHash(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	expiresAt := time.Now().Add(time.Hour)
+	rows := sqlmock.NewRows(columns).
+		AddRow(1, "partner", "pk_abcdefgh", "products:read", expiresAt, nil, nil, time.Now())
+	mock.ExpectQuery(getByHashQuery).WithArgs("hash").WillReturnRows(rows)
+	k, err := NewAPIKeyRepository(db).GetByHash(context.TODO(), "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{apikey.ScopeProductsRead}, k.Scopes)
+	assert.Nil(t, k.RevokedAt)
+	if assert.NotNil(t, k.ExpiresAt) {
+		assert.True(t, expiresAt.Equal(*k.ExpiresAt))
+	}
+}
+
+func TestAPIKeyRepository_List(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	rows := sqlmock.NewRows(columns).
+		AddRow(1, "one", "pk_11111111", "products:read", nil, nil, nil, time.Now()).
+		AddRow(2, "two", "pk_22222222", "products:write", nil, time.Now(), time.Now(), time.Now())
+	mock.ExpectQuery(listQuery).WillReturnRows(rows)
+	keys, err := NewAPIKeyRepository(db).List(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(keys))
+	assert.NotNil(t, keys[1].RevokedAt)
+}
+
+func TestAPIKeyRepository_Revoke(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	at := time.Now()
+	mock.ExpectExec(revokeQuery).WithArgs(at, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(revokeQuery).WithArgs(at, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	repo := NewAPIKeyRepository(db)
+	assert.NoError(t, repo.Revoke(context.TODO(), 1, at))
+	assert.ErrorIs(t, repo.Revoke(context.TODO(), 2, at), sql.ErrNoRows)
+}
+
+func createMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an rest '%s' was not expected when opening a stub database connection", err)
+	}
+	return db, mock
+}
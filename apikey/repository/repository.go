@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"github.com/halilylm/microservice/apikey"
+	"time"
+)
+
+type APIKeyRepository interface {
+	Insert(ctx context.Context, k *apikey.APIKey) (*apikey.APIKey, error)
+	List(ctx context.Context) ([]*apikey.APIKey, error)
+	GetByHash(ctx context.Context, hash string) (*apikey.APIKey, error)
+	Revoke(ctx context.Context, id int64, at time.Time) error
+	UpdateLastUsed(ctx context.Context, id int64, at time.Time) error
+}
+
+// UsageRepository buffers last used timestamps so that authenticating a key
+// doesn't write to the database on every request.
+type UsageRepository interface {
+	MarkUsed(ctx context.Context, id int64, at time.Time) error
+	// Drain returns the buffered timestamps and clears the buffer.
+	Drain(ctx context.Context) (map[int64]time.Time, error)
+}
@@ -0,0 +1,146 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/apikey/repository"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/halilylm/microservice/pkg/rest"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const (
+	keyPrefix    = "pk_"
+	prefixLength = len(keyPrefix) + 8
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+type apiKeyUC struct {
+	repo   repository.APIKeyRepository
+	usage  repository.UsageRepository
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+func NewAPIKeyUC(repo repository.APIKeyRepository, usage repository.UsageRepository, logger *zap.Logger) APIKeyUseCase {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &apiKeyUC{repo: repo, usage: usage, logger: logger, now: time.Now}
+}
+
+func (uc *apiKeyUC) Issue(ctx context.Context, k *apikey.APIKey) (*apikey.IssuedKey, error) {
+	now := uc.now().UTC()
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+		return nil, rest.NewBadRequest("expires_at must be in the future")
+	}
+	plain, err := generateKey()
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	k.Prefix = plain[:prefixLength]
+	k.Hash = hashKey(plain)
+	k.CreatedAt = now
+	created, err := uc.repo.Insert(ctx, k)
+	if err != nil {
+		uc.log(ctx).Error("could not insert the api key", zap.Error(err))
+		return nil, rest.NewInternalServerError()
+	}
+	uc.log(ctx).Info("issued an api key", zap.Int64("id", created.ID), zap.Strings("scopes", created.Scopes))
+	return &apikey.IssuedKey{APIKey: created, Key: plain}, nil
+}
+
+func (uc *apiKeyUC) List(ctx context.Context) ([]*apikey.APIKey, error) {
+	keys, err := uc.repo.List(ctx)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	return keys, nil
+}
+
+func (uc *apiKeyUC) Revoke(ctx context.Context, id int64) error {
+	if err := uc.repo.Revoke(ctx, id, uc.now().UTC()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rest.NewNotFoundError()
+		}
+		return rest.NewInternalServerError()
+	}
+	uc.log(ctx).Info("revoked an api key", zap.Int64("id", id))
+	return nil
+}
+
+func (uc *apiKeyUC) Authenticate(ctx context.Context, plain string) (*apikey.APIKey, error) {
+	if !strings.HasPrefix(plain, keyPrefix) {
+		return nil, rest.NewUnauthorized(ErrInvalidAPIKey.Error())
+	}
+	k, err := uc.repo.GetByHash(ctx, hashKey(plain))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rest.NewUnauthorized(ErrInvalidAPIKey.Error())
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	now := uc.now().UTC()
+	if !k.Active(now) {
+		return nil, rest.NewUnauthorized(ErrInvalidAPIKey.Error())
+	}
+	if err := uc.usage.MarkUsed(ctx, k.ID, now); err != nil {
+		uc.log(ctx).Warn("could not buffer the api key usage", zap.Int64("id", k.ID), zap.Error(err))
+	}
+	return k, nil
+}
+
+func (uc *apiKeyUC) FlushUsage(ctx context.Context) error {
+	used, err := uc.usage.Drain(ctx)
+	if err != nil {
+		return err
+	}
+	for id, at := range used {
+		if err := uc.repo.UpdateLastUsed(ctx, id, at); err != nil {
+			uc.log(ctx).Warn("could not update the api key usage", zap.Int64("id", id), zap.Error(err))
+			if err := uc.usage.MarkUsed(ctx, id, at); err != nil {
+				uc.log(ctx).Error("lost the api key usage", zap.Int64("id", id), zap.Error(err))
+			}
+		}
+	}
+	return nil
+}
+
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashKey hashes a key for storage. Keys carry 256 bits of entropy, so a
+// fast hash is enough and lets keys be looked up by their hash.
+func hashKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+type APIKeyUseCase interface {
+	Issue(ctx context.Context, k *apikey.APIKey) (*apikey.IssuedKey, error)
+	List(ctx context.Context) ([]*apikey.APIKey, error)
+	Revoke(ctx context.Context, id int64) error
+	Authenticate(ctx context.Context, plain string) (*apikey.APIKey, error)
+	// FlushUsage writes the buffered last used timestamps to the database,
+	// the ones that can't be written are buffered again for the next flush.
+	FlushUsage(ctx context.Context) error
+}
+
+// log is the logger of the request, the usecase logger outside of one.
+func (uc *apiKeyUC) log(ctx context.Context) *zap.Logger {
+	return logctx.From(ctx, uc.logger)
+}
@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/apikey/repository"
+	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUC_Issue(t *testing.T) {
+	t.Parallel()
+	repo := repository.NewMockAPIKeyRepository()
+	uc := NewAPIKeyUC(repo, repository.NewMockUsageRepository(), zap.NewNop())
+	t.Run("issues a hashed key", func(t *testing.T) {
+		issued, err := uc.Issue(context.TODO(), &apikey.APIKey{Name: "partner", Scopes: []string{apikey.ScopeProductsRead}})
+		assert.NoError(t, err)
+		assert.Contains(t, issued.Key, keyPrefix)
+		assert.Equal(t, issued.Key[:prefixLength], issued.Prefix)
+		assert.NotEqual(t, issued.Key, issued.Hash)
+		assert.Equal(t, 1, len(repo.Keys()))
+	})
+	t.Run("rejects expiry in the past", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		_, err := uc.Issue(context.TODO(), &apikey.APIKey{Name: "partner", Scopes: []string{apikey.ScopeProductsRead}, ExpiresAt: &past})
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+}
+
+// failingRepository can't write the usage.
+type failingRepository struct {
+	*repository.MockAPIKeyRepository
+}
+
+func (failingRepository) UpdateLastUsed(ctx context.Context, id int64, at time.Time) error {
+	return errors.New("database is down")
+}
+
+func TestAPIKeyUC_FlushUsage(t *testing.T) {
+	t.Parallel()
+	usage := repository.NewMockUsageRepository()
+	uc := NewAPIKeyUC(failingRepository{repository.NewMockAPIKeyRepository()}, usage, zap.NewNop())
+	at := time.Now().UTC()
+	assert.NoError(t, usage.MarkUsed(context.TODO(), 7, at))
+	assert.NoError(t, uc.FlushUsage(context.TODO()))
+	// buffered again for the next flush
+	used, err := usage.Drain(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]time.Time{7: at}, used)
+}
+
+func TestAPIKeyUC_Authenticate(t *testing.T) {
+	t.Parallel()
+	repo := repository.NewMockAPIKeyRepository()
+	usage := repository.NewMockUsageRepository()
+	uc := NewAPIKeyUC(repo, usage, zap.NewNop())
+	issued, err := uc.Issue(context.TODO(), &apikey.APIKey{Name: "partner", Scopes: []string{apikey.ScopeProductsWrite}})
+	assert.NoError(t, err)
+	t.Run("authenticates a valid key and records usage", func(t *testing.T) {
+		k, err := uc.Authenticate(context.TODO(), issued.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, issued.ID, k.ID)
+		assert.NoError(t, uc.FlushUsage(context.TODO()))
+		assert.NotNil(t, repo.Keys()[issued.ID].LastUsedAt)
+	})
+	t.Run("rejects unknown keys", func(t *testing.T) {
+		_, err := uc.Authenticate(context.TODO(), keyPrefix+"unknown")
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+	})
+	t.Run("rejects expired keys", func(t *testing.T) {
+		expiring, err := uc.Issue(context.TODO(), &apikey.APIKey{Name: "short", Scopes: []string{apikey.ScopeProductsRead}})
+		assert.NoError(t, err)
+		past := time.Now().Add(-time.Minute)
+		expiring.ExpiresAt = &past
+		_, err = uc.Authenticate(context.TODO(), expiring.Key)
+		assert.Error(t, err)
+	})
+	t.Run("rejects revoked keys", func(t *testing.T) {
+		assert.NoError(t, uc.Revoke(context.TODO(), issued.ID))
+		_, err := uc.Authenticate(context.TODO(), issued.Key)
+		assert.Error(t, err)
+	})
+	t.Run("revoking twice returns not found", func(t *testing.T) {
+		err := uc.Revoke(context.TODO(), issued.ID)
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusNotFound, httpErr.Code)
+	})
+}
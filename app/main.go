@@ -1,39 +1,308 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	m "github.com/halilylm/microservice/http/middleware"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/lifecycle"
+	"github.com/halilylm/microservice/pkg/tlsconfig"
 	"github.com/halilylm/microservice/server"
 	"go.elastic.co/ecszap"
 	_ "go.uber.org/automaxprocs" // for docker container
 	"go.uber.org/zap"
+	"net"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var release string
 
 func main() {
+	// the admin server changes the level at runtime
+	level, err := zap.ParseAtomicLevel(envOr("LOG_LEVEL", "debug"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid LOG_LEVEL:", err)
+		os.Exit(2)
+	}
 	encoderConfig := ecszap.NewDefaultEncoderConfig()
-	core := ecszap.NewCore(encoderConfig, os.Stdout, zap.DebugLevel)
+	core := ecszap.NewCore(encoderConfig, os.Stdout, level)
 	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 	defer func() {
 		_ = logger.Sync()
 	}()
 	logger = logger.With(zap.String("release", release))
+	// serve is the only command, it is also run with no argument
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	storage := flags.String("storage", envOr("PRODUCT_STORAGE", server.StorageMysql), "where the products are stored: mysql, postgres or memory")
+	snapshot := flags.String("snapshot", os.Getenv("MEMORY_SNAPSHOT"), "json file the memory storage loads the products from and saves them to")
+	_ = flags.Parse(args)
+	// the admin server has no authentication, it only listens on loopback
+	// unless ADMIN_ADDR binds it wider, e.g. 0.0.0.0:9090 behind a network
+	// policy that keeps it private
+	adminOpts, err := adminOptions(envOr("ADMIN_ADDR", "127.0.0.1:9090"))
+	if err != nil {
+		logger.Fatal("invalid ADMIN_ADDR", zap.Error(err))
+	}
+	drainPeriod, err := time.ParseDuration(envOr("DRAIN_PERIOD", "5s"))
+	if err != nil {
+		logger.Fatal("invalid DRAIN_PERIOD", zap.Error(err))
+	}
+	replicas, err := parseReplicas(os.Getenv("MYSQL_REPLICAS"))
+	if err != nil {
+		logger.Fatal("invalid MYSQL_REPLICAS", zap.Error(err))
+	}
+	redisOpts, err := redisOptions()
+	if err != nil {
+		logger.Fatal("invalid redis configuration", zap.Error(err))
+	}
+	accessLog, err := accessLogOptions()
+	if err != nil {
+		logger.Fatal("invalid access log configuration", zap.Error(err))
+	}
+	tlsOpts, err := serverTLS()
+	if err != nil {
+		logger.Fatal("invalid TLS configuration", zap.Error(err))
+	}
+	mysqlTLS, err := clientTLS("MYSQL")
+	if err != nil {
+		logger.Fatal("invalid mysql TLS configuration", zap.Error(err))
+	}
 	srv := server.New(&server.Options{
-		Host:   "0.0.0.0",
-		Port:   8080,
-		Logger: logger,
+		Host:           "0.0.0.0",
+		Port:           8080,
+		Logger:         logger,
+		LogLevel:       level,
+		Admin:          adminOpts,
+		AccessLog:      accessLog,
+		DrainPeriod:    drainPeriod,
+		TLS:            tlsOpts,
+		MysqlReplicas:  replicas,
+		MysqlTLS:       mysqlTLS,
+		ProductStorage: *storage,
+		MemorySnapshot: *snapshot,
+		Redis:          redisOpts,
+		Auth: server.AuthOptions{
+			JWKSFile:   os.Getenv("JWKS_FILE"),
+			Issuer:     os.Getenv("JWT_ISSUER"),
+			Audience:   os.Getenv("JWT_AUDIENCE"),
+			Leeway:     30 * time.Second,
+			PolicyFile: os.Getenv("RBAC_POLICY_FILE"),
+		},
 	})
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGKILL, os.Interrupt)
-	go func() {
-		if err := srv.Start(); err != nil {
-			panic(err)
+	app := lifecycle.New(lifecycle.Options{Logger: logger})
+	app.Append(srv.Components(app.Fail)...)
+	if err := app.Run(context.Background()); err != nil {
+		logger.Error("the application stopped with an error", zap.Error(err))
+		_ = logger.Sync()
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// adminOptions reads the host:port of the admin server, "off" disables it.
+func adminOptions(addr string) (server.AdminOptions, error) {
+	if addr == "off" {
+		return server.AdminOptions{}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return server.AdminOptions{}, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return server.AdminOptions{}, err
+	}
+	return server.AdminOptions{Host: host, Port: p}, nil
+}
+
+// parseReplicas reads a comma separated list of host:port addresses.
+func parseReplicas(list string) ([]database.ReplicaOptions, error) {
+	var replicas []database.ReplicaOptions
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
 		}
-	}()
-	<-quit
-	if err := srv.Stop(); err != nil {
-		panic(err)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, database.ReplicaOptions{Host: host, Port: p})
+	}
+	return replicas, nil
+}
+
+// redisOptions reads the redis deployment from the environment. REDIS_ADDR
+// is the standalone node, REDIS_ADDRS the comma separated sentinels or
+// cluster seed nodes.
+func redisOptions() (database.RedisOptions, error) {
+	var opts database.RedisOptions
+	switch mode := envOr("REDIS_MODE", "standalone"); mode {
+	case "standalone":
+		if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return opts, err
+			}
+			if opts.Port, err = strconv.Atoi(port); err != nil {
+				return opts, err
+			}
+			opts.Host = host
+		}
+	case "sentinel":
+		opts.Mode = database.RedisSentinel
+		opts.MasterName = os.Getenv("REDIS_MASTER_NAME")
+		if opts.MasterName == "" {
+			return opts, errors.New("REDIS_MASTER_NAME is required in sentinel mode")
+		}
+	case "cluster":
+		opts.Mode = database.RedisCluster
+	default:
+		return opts, fmt.Errorf("unknown REDIS_MODE %q", mode)
+	}
+	opts.Addrs = splitList(os.Getenv("REDIS_ADDRS"))
+	if opts.Mode != database.RedisStandalone && len(opts.Addrs) == 0 {
+		return opts, fmt.Errorf("REDIS_ADDRS is required in %s mode", opts.Mode)
+	}
+	opts.Username = os.Getenv("REDIS_USERNAME")
+	opts.Password = os.Getenv("REDIS_PASSWORD")
+	opts.SentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+	var err error
+	if opts.ReadFromReplica, err = strconv.ParseBool(envOr("REDIS_READ_FROM_REPLICA", "false")); err != nil {
+		return opts, err
 	}
+	if opts.PoolSize, err = strconv.Atoi(envOr("REDIS_POOL_SIZE", "0")); err != nil {
+		return opts, err
+	}
+	if opts.MinIdleConns, err = strconv.Atoi(envOr("REDIS_MIN_IDLE_CONNS", "0")); err != nil {
+		return opts, err
+	}
+	for name, d := range map[string]*time.Duration{
+		"REDIS_DIAL_TIMEOUT":  &opts.DialTimeout,
+		"REDIS_READ_TIMEOUT":  &opts.ReadTimeout,
+		"REDIS_WRITE_TIMEOUT": &opts.WriteTimeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *d, err = time.ParseDuration(v); err != nil {
+				return opts, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if opts.TLS, err = clientTLS("REDIS"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// accessLogOptions reads the access log configuration. ACCESS_LOG_SAMPLING
+// is the share of the requests logged per status class, e.g. 2=0.1,3=0.5.
+// The comma separated ACCESS_LOG_REDACT_PARAMS and ACCESS_LOG_REDACT_HEADERS
+// are redacted on top of the defaults.
+func accessLogOptions() (m.RequestLoggerOptions, error) {
+	opts := m.RequestLoggerOptions{
+		Headers:       splitList(os.Getenv("ACCESS_LOG_HEADERS")),
+		RedactParams:  append(splitList(os.Getenv("ACCESS_LOG_REDACT_PARAMS")), m.DefaultRedactedParams...),
+		RedactHeaders: append(splitList(os.Getenv("ACCESS_LOG_REDACT_HEADERS")), m.DefaultRedactedHeaders...),
+	}
+	for _, rate := range splitList(os.Getenv("ACCESS_LOG_SAMPLING")) {
+		class, share, ok := strings.Cut(rate, "=")
+		if !ok {
+			return opts, fmt.Errorf("ACCESS_LOG_SAMPLING: %q is not class=share", rate)
+		}
+		c, err := strconv.Atoi(class)
+		if err != nil || c < 1 || c > 5 {
+			return opts, fmt.Errorf("ACCESS_LOG_SAMPLING: invalid status class %q", class)
+		}
+		f, err := strconv.ParseFloat(share, 64)
+		if err != nil {
+			return opts, fmt.Errorf("ACCESS_LOG_SAMPLING: %w", err)
+		}
+		if opts.Sampling == nil {
+			opts.Sampling = make(map[int]float64)
+		}
+		opts.Sampling[c] = f
+	}
+	var err error
+	if opts.BodyLimit, err = strconv.Atoi(envOr("ACCESS_LOG_BODY_LIMIT", "0")); err != nil {
+		return opts, fmt.Errorf("ACCESS_LOG_BODY_LIMIT: %w", err)
+	}
+	return opts, nil
+}
+
+// splitList splits a comma separated list, skipping the empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// serverTLS reads the https configuration, the server listens in plain http
+// without TLS_CERT_FILE.
+func serverTLS() (server.TLSOptions, error) {
+	opts := server.TLSOptions{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+	}
+	opts.ClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	var err error
+	if opts.RequireClientCert, err = strconv.ParseBool(envOr("TLS_REQUIRE_CLIENT_CERT", "false")); err != nil {
+		return opts, err
+	}
+	if opts.MinVersion, err = tlsconfig.ParseVersion(os.Getenv("TLS_MIN_VERSION")); err != nil {
+		return opts, err
+	}
+	if v := os.Getenv("TLS_RELOAD"); v != "" {
+		if opts.Reload, err = time.ParseDuration(v); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// clientTLS reads the TLS configuration of the connections to a database
+// from the variables starting with prefix, e.g. MYSQL_TLS=true. Setting a
+// CA bundle enables TLS too, it returns nil when TLS is disabled.
+func clientTLS(prefix string) (*tls.Config, error) {
+	caFile := os.Getenv(prefix + "_TLS_CA_FILE")
+	enabled, err := strconv.ParseBool(envOr(prefix+"_TLS", strconv.FormatBool(caFile != "")))
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, nil
+	}
+	minVersion, err := tlsconfig.ParseVersion(os.Getenv(prefix + "_TLS_MIN_VERSION"))
+	if err != nil {
+		return nil, err
+	}
+	return tlsconfig.Client(tlsconfig.ClientOptions{
+		CAFile:     caFile,
+		CertFile:   os.Getenv(prefix + "_TLS_CERT_FILE"),
+		KeyFile:    os.Getenv(prefix + "_TLS_KEY_FILE"),
+		ServerName: os.Getenv(prefix + "_TLS_SERVER_NAME"),
+		MinVersion: minVersion,
+	})
 }
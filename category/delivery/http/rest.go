@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/usecase"
+	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/halilylm/microservice/product"
+	"net/http"
+)
+
+// defaultLimit is the page size of the products when the request has none.
+const defaultLimit = 20
+
+type categoryHandler struct {
+	uc usecase.CategoryUseCase
+}
+
+type categoryIDRequest struct {
+	ID int64 `path:"id"`
+}
+
+type categorySlugRequest struct {
+	Slug string `path:"slug"`
+}
+
+type updateCategoryRequest struct {
+	category.Category
+	ID int64 `path:"id" json:"-" xml:"-"`
+}
+
+type listProductsRequest struct {
+	Slug   string `path:"slug"`
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int    `query:"offset" validate:"min=0"`
+}
+
+// productLinkRequest names the product by slug, its id is not exposed.
+type productLinkRequest struct {
+	ID          int64  `path:"id"`
+	ProductSlug string `path:"productSlug"`
+}
+
+func NewCategoryHandler(uc usecase.CategoryUseCase, r chi.Router) {
+	handler := categoryHandler{uc: uc}
+	r.Post("/", rest.Handle(handler.CreateCategory, rest.WithStatus(http.StatusCreated)))
+	r.Options("/", rest.Options)
+	// like the products, the categories are read by slug and changed by id
+	get := rest.Handle(handler.GetCategoryBySlug)
+	r.Get("/{slug}", get)
+	r.Head("/{slug}", get)
+	r.Options("/{slug}", rest.Options)
+	r.Get("/{slug}/breadcrumb", rest.Handle(handler.GetBreadcrumb))
+	r.Options("/{slug}/breadcrumb", rest.Options)
+	r.Get("/{slug}/products", rest.Handle(handler.ListProducts))
+	r.Options("/{slug}/products", rest.Options)
+	r.Put("/{id:[0-9]+}", rest.Handle(handler.UpdateCategory))
+	r.Delete("/{id:[0-9]+}", rest.Handle(handler.DeleteCategory))
+	r.Options("/{id:[0-9]+}", rest.Options)
+	r.Put("/{id:[0-9]+}/products/{productSlug}", rest.Handle(handler.LinkProduct))
+	r.Delete("/{id:[0-9]+}/products/{productSlug}", rest.Handle(handler.UnlinkProduct))
+	r.Options("/{id:[0-9]+}/products/{productSlug}", rest.Options)
+}
+
+func (h *categoryHandler) CreateCategory(ctx context.Context, req category.Category) (*category.Category, error) {
+	return h.uc.CreateCategory(ctx, &req)
+}
+
+func (h *categoryHandler) UpdateCategory(ctx context.Context, req updateCategoryRequest) (*category.Category, error) {
+	req.Category.ID = req.ID
+	return h.uc.UpdateCategory(ctx, &req.Category)
+}
+
+func (h *categoryHandler) DeleteCategory(ctx context.Context, req categoryIDRequest) (rest.NoContent, error) {
+	return rest.NoContent{}, h.uc.DeleteCategory(ctx, req.ID)
+}
+
+func (h *categoryHandler) GetCategoryBySlug(ctx context.Context, req categorySlugRequest) (*category.Category, error) {
+	return h.uc.GetCategoryBySlug(ctx, req.Slug)
+}
+
+func (h *categoryHandler) GetBreadcrumb(ctx context.Context, req categorySlugRequest) ([]category.Crumb, error) {
+	return h.uc.GetBreadcrumb(ctx, req.Slug)
+}
+
+func (h *categoryHandler) ListProducts(ctx context.Context, req listProductsRequest) ([]*product.Product, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultLimit
+	}
+	return h.uc.ListProducts(ctx, req.Slug, req.Limit, req.Offset)
+}
+
+func (h *categoryHandler) LinkProduct(ctx context.Context, req productLinkRequest) (rest.NoContent, error) {
+	return rest.NoContent{}, h.uc.LinkProduct(ctx, req.ID, req.ProductSlug)
+}
+
+func (h *categoryHandler) UnlinkProduct(ctx context.Context, req productLinkRequest) (rest.NoContent, error) {
+	return rest.NoContent{}, h.uc.UnlinkProduct(ctx, req.ID, req.ProductSlug)
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/halilylm/microservice/category/repository/memory"
+	"github.com/halilylm/microservice/category/usecase"
+	"github.com/halilylm/microservice/product"
+	productrepository "github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// missCache never holds a node.
+type missCache struct{}
+
+func (missCache) SetNode(ctx context.Context, key string, expire time.Duration, node *category.Node) error {
+	return nil
+}
+
+func (missCache) GetNode(ctx context.Context, key string) (*category.Node, error) {
+	return nil, repository.ErrCacheMiss
+}
+
+func (missCache) DeleteNodes(ctx context.Context, keys ...string) error {
+	return nil
+}
+
+func newTestRouter() chi.Router {
+	products := productrepository.NewMockProductRepository(map[int64]*product.Product{
+		1: {ID: 1, Name: "lemon", Slug: "lemon", Price: 1},
+		2: {ID: 2, Name: "apple", Slug: "apple", Price: 2},
+	})
+	uc := usecase.NewCategoryUC(memory.NewCategoryRepository(), missCache{}, products, nil, zap.NewNop())
+	r := chi.NewRouter()
+	NewCategoryHandler(uc, r)
+	return r
+}
+
+func serve(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestCategoryHandler(t *testing.T) {
+	r := newTestRouter()
+	res := serve(r, http.MethodPost, "/", `{"name": "Food"}`)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var food category.Category
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&food))
+	assert.Equal(t, "food", food.Slug)
+	res = serve(r, http.MethodPost, "/", `{"name": "Fruit", "parent_id": 1}`)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	t.Run("name is required", func(t *testing.T) {
+		res := serve(r, http.MethodPost, "/", `{"parent_id": 1}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+	t.Run("gets a category", func(t *testing.T) {
+		res := serve(r, http.MethodGet, "/fruit", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `"parent_id":1`)
+		res = serve(r, http.MethodGet, "/nuts", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+	t.Run("serves the breadcrumb", func(t *testing.T) {
+		res := serve(r, http.MethodGet, "/fruit/breadcrumb", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `[{"id":1,"name":"Food","slug":"food"},{"id":2,"name":"Fruit","slug":"fruit"}]`, res.Body.String())
+
+		// a list has no single XML root
+		req := httptest.NewRequest(http.MethodGet, "/fruit/breadcrumb", nil)
+		req.Header.Set("Accept", "application/xml")
+		res = httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusNotAcceptable, res.Code)
+		req.Header.Set("Accept", "application/xml, application/json;q=0.5")
+		res = httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	})
+	t.Run("lists the products of the subtree", func(t *testing.T) {
+		res := serve(r, http.MethodPut, "/2/products/lemon", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = serve(r, http.MethodPut, "/1/products/apple", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = serve(r, http.MethodPut, "/1/products/pear", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		res = serve(r, http.MethodGet, "/food/products", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		var listed []product.Product
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+		assert.Len(t, listed, 2)
+		res = serve(r, http.MethodGet, "/food/products?limit=1&offset=1", "")
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+		if assert.Len(t, listed, 1) {
+			assert.Equal(t, "apple", listed[0].Name)
+		}
+		res = serve(r, http.MethodGet, "/food/products?limit=1000", "")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		res = serve(r, http.MethodDelete, "/1/products/apple", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = serve(r, http.MethodGet, "/fruit/products", "")
+		assert.Contains(t, res.Body.String(), "lemon")
+	})
+	t.Run("moves a category", func(t *testing.T) {
+		res := serve(r, http.MethodPut, "/1", `{"name": "Food", "parent_id": 2}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		res = serve(r, http.MethodPut, "/2", `{"name": "Fruits"}`)
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = serve(r, http.MethodGet, "/fruit/breadcrumb", "")
+		assert.JSONEq(t, `[{"id":2,"name":"Fruits","slug":"fruit"}]`, res.Body.String())
+	})
+	t.Run("deletes a category", func(t *testing.T) {
+		res := serve(r, http.MethodDelete, "/2", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = serve(r, http.MethodDelete, "/2", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
@@ -0,0 +1,30 @@
+package category
+
+import "time"
+
+// Category is a node of the category tree, the roots have no parent.
+type Category struct {
+	ID        int64     `json:"id" xml:"id"`
+	ParentID  *int64    `json:"parent_id,omitempty" xml:"parent_id,omitempty"`
+	Name      string    `json:"name" xml:"name" validate:"required,max=255"`
+	Slug      string    `json:"slug" xml:"slug"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Crumb is a category of the path from a root down to a category.
+type Crumb struct {
+	ID   int64  `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Slug string `json:"slug" xml:"slug"`
+}
+
+// Node is a category with its place in the tree, it is what the cache holds
+// per slug.
+type Node struct {
+	Category *Category
+	// Breadcrumb goes from the root to the category, included.
+	Breadcrumb []Crumb
+	// Subtree holds the ids of the category and of all its descendants.
+	Subtree []int64
+}
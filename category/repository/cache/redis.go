@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"time"
+)
+
+// keyPrefix keeps the categories apart from the products, which are cached
+// under their bare slug.
+const keyPrefix = "category:"
+
+type categoryRepository struct {
+	client redis.UniversalClient
+}
+
+// NewCategoryRepository caches the nodes as json, the missing ones are
+// reported as repository.ErrCacheMiss.
+func NewCategoryRepository(client redis.UniversalClient) repository.CategoryCacheRepository {
+	return &categoryRepository{client: client}
+}
+
+func (r *categoryRepository) SetNode(ctx context.Context, key string, expire time.Duration, node *category.Node) error {
+	nodeBytes, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, keyPrefix+key, nodeBytes, expire).Err()
+}
+
+func (r *categoryRepository) GetNode(ctx context.Context, key string) (*category.Node, error) {
+	nodeBytes, err := r.client.Get(ctx, keyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, repository.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	var node category.Node
+	if err := json.Unmarshal(nodeBytes, &node); err != nil {
+		// the entry is replaced once the node is read from the database
+		return nil, repository.ErrCacheMiss
+	}
+	return &node, nil
+}
+
+// DeleteNodes deletes every key on its own, the keys of a cluster live on
+// different slots.
+func (r *categoryRepository) DeleteNodes(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, keyPrefix+key)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func setupRedis(t *testing.T) (repository.CategoryCacheRepository, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	return NewCategoryRepository(client), mr
+}
+
+func TestCategoryRepository_Node(t *testing.T) {
+	cache, mr := setupRedis(t)
+	node := &category.Node{
+		Category:   &category.Category{ID: 2, Name: "Fruit", Slug: "fruit"},
+		Breadcrumb: []category.Crumb{{ID: 1, Name: "Food", Slug: "food"}, {ID: 2, Name: "Fruit", Slug: "fruit"}},
+		Subtree:    []int64{2, 3},
+	}
+	assert.NoError(t, cache.SetNode(context.TODO(), "fruit", time.Minute, node))
+	assert.True(t, mr.Exists("category:fruit"))
+	cached, err := cache.GetNode(context.TODO(), "fruit")
+	assert.NoError(t, err)
+	assert.Equal(t, node, cached)
+
+	_, err = cache.GetNode(context.TODO(), "vegetables")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+	assert.NoError(t, mr.Set("category:broken", "{"))
+	_, err = cache.GetNode(context.TODO(), "broken")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	assert.NoError(t, cache.DeleteNodes(context.TODO(), "fruit", "vegetables"))
+	_, err = cache.GetNode(context.TODO(), "fruit")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+	assert.NoError(t, cache.DeleteNodes(context.TODO()))
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	node category.Node
+	// expiresAt is zero for entries that don't expire
+	expiresAt time.Time
+}
+
+// CategoryCacheRepository keeps the nodes in the process. There is a node
+// per category at most, so the expired ones are only dropped when read.
+type CategoryCacheRepository struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+func NewCategoryCacheRepository() *CategoryCacheRepository {
+	return &CategoryCacheRepository{entries: make(map[string]cacheEntry), now: time.Now}
+}
+
+var _ repository.CategoryCacheRepository = (*CategoryCacheRepository)(nil)
+
+// SetNode caches a copy of node, a zero expire keeps it until it is deleted.
+func (c *CategoryCacheRepository) SetNode(ctx context.Context, key string, expire time.Duration, node *category.Node) error {
+	entry := cacheEntry{node: copyNode(node)}
+	if expire > 0 {
+		entry.expiresAt = c.now().Add(expire)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *CategoryCacheRepository) GetNode(ctx context.Context, key string) (*category.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, repository.ErrCacheMiss
+	}
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, repository.ErrCacheMiss
+	}
+	node := copyNode(&entry.node)
+	return &node, nil
+}
+
+func (c *CategoryCacheRepository) DeleteNodes(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func copyNode(node *category.Node) category.Node {
+	return category.Node{
+		Category:   copyCategory(node.Category),
+		Breadcrumb: append([]category.Crumb(nil), node.Breadcrumb...),
+		Subtree:    append([]int64(nil), node.Subtree...),
+	}
+}
@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCategoryCacheRepository(t *testing.T) {
+	now := time.Now()
+	c := NewCategoryCacheRepository()
+	c.now = func() time.Time { return now }
+	node := &category.Node{
+		Category:   &category.Category{ID: 2, Name: "Fruit", Slug: "fruit"},
+		Breadcrumb: []category.Crumb{{ID: 2, Name: "Fruit", Slug: "fruit"}},
+		Subtree:    []int64{2, 3},
+	}
+	assert.NoError(t, c.SetNode(context.TODO(), "fruit", time.Minute, node))
+	node.Subtree[0] = 100
+	cached, err := c.GetNode(context.TODO(), "fruit")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, cached.Subtree)
+
+	now = now.Add(time.Minute)
+	_, err = c.GetNode(context.TODO(), "fruit")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	assert.NoError(t, c.SetNode(context.TODO(), "fruit", 0, node))
+	assert.NoError(t, c.DeleteNodes(context.TODO(), "fruit"))
+	_, err = c.GetNode(context.TODO(), "fruit")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+}
@@ -0,0 +1,228 @@
+// Package memory keeps the categories in the process, for local development
+// and tests. Everything is copied in and out so callers can't change the
+// stored categories.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CategoryRepository struct {
+	mu     sync.RWMutex
+	byID   map[int64]*category.Category
+	bySlug map[string]int64
+	// links holds the product ids of every category
+	links  map[int64]map[int64]bool
+	nextID int64
+	now    func() time.Time
+}
+
+func NewCategoryRepository() *CategoryRepository {
+	return &CategoryRepository{
+		byID:   make(map[int64]*category.Category),
+		bySlug: make(map[string]int64),
+		links:  make(map[int64]map[int64]bool),
+		now:    time.Now,
+	}
+}
+
+var _ repository.CategoryRepository = (*CategoryRepository)(nil)
+
+func (r *CategoryRepository) Insert(ctx context.Context, c *category.Category) (*category.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bySlug[c.Slug]; ok {
+		return nil, repository.ErrSlugTaken
+	}
+	if c.ParentID != nil {
+		if _, ok := r.byID[*c.ParentID]; !ok {
+			return nil, sql.ErrNoRows
+		}
+	}
+	r.nextID++
+	c.ID = r.nextID
+	c.CreatedAt = r.now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	r.put(c)
+	return copyCategory(c), nil
+}
+
+func (r *CategoryRepository) Update(ctx context.Context, c *category.Category) (*category.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[c.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	// the new parent can't be below the category
+	for parent := c.ParentID; parent != nil; {
+		if *parent == c.ID {
+			return nil, repository.ErrCycle
+		}
+		p, ok := r.byID[*parent]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		parent = p.ParentID
+	}
+	c.Slug = stored.Slug
+	c.CreatedAt = stored.CreatedAt
+	c.UpdatedAt = r.now().UTC()
+	r.put(c)
+	return copyCategory(c), nil
+}
+
+func (r *CategoryRepository) Delete(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, c := range r.byID {
+		if c.ParentID != nil && *c.ParentID == id {
+			return repository.ErrNotEmpty
+		}
+	}
+	delete(r.byID, id)
+	delete(r.bySlug, stored.Slug)
+	delete(r.links, id)
+	return nil
+}
+
+func (r *CategoryRepository) GetByID(ctx context.Context, id int64) (*category.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stored, ok := r.byID[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyCategory(stored), nil
+}
+
+func (r *CategoryRepository) GetBySlug(ctx context.Context, slug string) (*category.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.bySlug[slug]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyCategory(r.byID[id]), nil
+}
+
+func (r *CategoryRepository) Ancestors(ctx context.Context, id int64) ([]*category.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stored, ok := r.byID[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	path := []*category.Category{copyCategory(stored)}
+	for stored.ParentID != nil {
+		stored = r.byID[*stored.ParentID]
+		path = append(path, copyCategory(stored))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+func (r *CategoryRepository) Descendants(ctx context.Context, id int64) ([]*category.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stored, ok := r.byID[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	children := make(map[int64][]int64)
+	for _, c := range r.byID {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+	// breadth first, so the parents come before their children
+	subtree := []*category.Category{copyCategory(stored)}
+	for i := 0; i < len(subtree); i++ {
+		ids := children[subtree[i].ID]
+		sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+		for _, child := range ids {
+			subtree = append(subtree, copyCategory(r.byID[child]))
+		}
+	}
+	return subtree, nil
+}
+
+func (r *CategoryRepository) LinkProduct(ctx context.Context, categoryID, productID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[categoryID]; !ok {
+		return sql.ErrNoRows
+	}
+	if r.links[categoryID] == nil {
+		r.links[categoryID] = make(map[int64]bool)
+	}
+	r.links[categoryID][productID] = true
+	return nil
+}
+
+func (r *CategoryRepository) UnlinkProduct(ctx context.Context, categoryID, productID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.links[categoryID], productID)
+	return nil
+}
+
+func (r *CategoryRepository) DeleteProductLinks(ctx context.Context, productID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, products := range r.links {
+		delete(products, productID)
+	}
+	return nil
+}
+
+func (r *CategoryRepository) ProductIDs(ctx context.Context, categoryIDs []int64, limit, offset int) ([]int64, error) {
+	r.mu.RLock()
+	seen := make(map[int64]bool)
+	for _, id := range categoryIDs {
+		for productID := range r.links[id] {
+			seen[productID] = true
+		}
+	}
+	r.mu.RUnlock()
+	ids := make([]int64, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if offset >= len(ids) {
+		return []int64{}, nil
+	}
+	ids = ids[offset:]
+	if limit < len(ids) {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// put stores a copy of c and indexes it, the caller holds the lock.
+func (r *CategoryRepository) put(c *category.Category) {
+	r.byID[c.ID] = copyCategory(c)
+	r.bySlug[c.Slug] = c.ID
+}
+
+func copyCategory(c *category.Category) *category.Category {
+	copied := *c
+	if c.ParentID != nil {
+		parentID := *c.ParentID
+		copied.ParentID = &parentID
+	}
+	return &copied
+}
@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// fruitTree inserts food > fruit > citrus and food > vegetables.
+func fruitTree(t *testing.T) (*CategoryRepository, map[string]int64) {
+	r := NewCategoryRepository()
+	ids := make(map[string]int64)
+	for _, c := range []struct{ slug, parent string }{
+		{"food", ""}, {"fruit", "food"}, {"citrus", "fruit"}, {"vegetables", "food"},
+	} {
+		cat := &category.Category{Name: c.slug, Slug: c.slug}
+		if c.parent != "" {
+			parentID := ids[c.parent]
+			cat.ParentID = &parentID
+		}
+		created, err := r.Insert(context.TODO(), cat)
+		require.NoError(t, err)
+		ids[c.slug] = created.ID
+	}
+	return r, ids
+}
+
+func categorySlugs(categories []*category.Category) []string {
+	slugs := make([]string, len(categories))
+	for i, c := range categories {
+		slugs[i] = c.Slug
+	}
+	return slugs
+}
+
+func TestCategoryRepository_Insert(t *testing.T) {
+	r, ids := fruitTree(t)
+	_, err := r.Insert(context.TODO(), &category.Category{Name: "fruit", Slug: "fruit"})
+	assert.ErrorIs(t, err, repository.ErrSlugTaken)
+	missing := ids["food"] + 100
+	_, err = r.Insert(context.TODO(), &category.Category{Name: "nuts", Slug: "nuts", ParentID: &missing})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestCategoryRepository_Tree(t *testing.T) {
+	r, ids := fruitTree(t)
+	path, err := r.Ancestors(context.TODO(), ids["citrus"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"food", "fruit", "citrus"}, categorySlugs(path))
+	subtree, err := r.Descendants(context.TODO(), ids["food"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"food", "fruit", "vegetables", "citrus"}, categorySlugs(subtree))
+	_, err = r.Ancestors(context.TODO(), 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestCategoryRepository_Update(t *testing.T) {
+	r, ids := fruitTree(t)
+	t.Run("moves a category", func(t *testing.T) {
+		parentID := ids["vegetables"]
+		updated, err := r.Update(context.TODO(), &category.Category{ID: ids["citrus"], Name: "Citrus", ParentID: &parentID})
+		assert.NoError(t, err)
+		assert.Equal(t, "citrus", updated.Slug)
+		path, err := r.Ancestors(context.TODO(), ids["citrus"])
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"food", "vegetables", "citrus"}, categorySlugs(path))
+	})
+	t.Run("refuses a cycle", func(t *testing.T) {
+		parentID := ids["citrus"]
+		_, err := r.Update(context.TODO(), &category.Category{ID: ids["food"], Name: "food", ParentID: &parentID})
+		assert.ErrorIs(t, err, repository.ErrCycle)
+		self := ids["food"]
+		_, err = r.Update(context.TODO(), &category.Category{ID: ids["food"], Name: "food", ParentID: &self})
+		assert.ErrorIs(t, err, repository.ErrCycle)
+	})
+	t.Run("makes a root", func(t *testing.T) {
+		_, err := r.Update(context.TODO(), &category.Category{ID: ids["fruit"], Name: "fruit"})
+		assert.NoError(t, err)
+		path, err := r.Ancestors(context.TODO(), ids["fruit"])
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"fruit"}, categorySlugs(path))
+	})
+}
+
+func TestCategoryRepository_Delete(t *testing.T) {
+	r, ids := fruitTree(t)
+	assert.ErrorIs(t, r.Delete(context.TODO(), ids["fruit"]), repository.ErrNotEmpty)
+	assert.NoError(t, r.LinkProduct(context.TODO(), ids["citrus"], 1))
+	assert.NoError(t, r.Delete(context.TODO(), ids["citrus"]))
+	assert.ErrorIs(t, r.Delete(context.TODO(), ids["citrus"]), sql.ErrNoRows)
+	productIDs, err := r.ProductIDs(context.TODO(), []int64{ids["citrus"]}, 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, productIDs)
+}
+
+func TestCategoryRepository_ProductIDs(t *testing.T) {
+	r, ids := fruitTree(t)
+	for _, link := range []struct {
+		slug      string
+		productID int64
+	}{{"citrus", 3}, {"fruit", 1}, {"fruit", 3}, {"vegetables", 2}, {"fruit", 1}} {
+		assert.NoError(t, r.LinkProduct(context.TODO(), ids[link.slug], link.productID))
+	}
+	all := []int64{ids["fruit"], ids["citrus"], ids["vegetables"]}
+	productIDs, err := r.ProductIDs(context.TODO(), all, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, productIDs)
+	productIDs, err = r.ProductIDs(context.TODO(), all, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, productIDs)
+	productIDs, err = r.ProductIDs(context.TODO(), all, 10, 5)
+	assert.NoError(t, err)
+	assert.Empty(t, productIDs)
+	assert.NoError(t, r.UnlinkProduct(context.TODO(), ids["fruit"], 1))
+	productIDs, err = r.ProductIDs(context.TODO(), []int64{ids["fruit"]}, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3}, productIDs)
+	assert.NoError(t, r.DeleteProductLinks(context.TODO(), 3))
+	productIDs, err = r.ProductIDs(context.TODO(), all, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, productIDs)
+	assert.ErrorIs(t, r.LinkProduct(context.TODO(), 100, 1), sql.ErrNoRows)
+}
+
+func TestCategoryRepository_CopyOnRead(t *testing.T) {
+	r, ids := fruitTree(t)
+	read, err := r.GetBySlug(context.TODO(), "citrus")
+	assert.NoError(t, err)
+	read.Name = "changed"
+	*read.ParentID = 100
+	again, err := r.GetByID(context.TODO(), ids["citrus"])
+	assert.NoError(t, err)
+	assert.Equal(t, "citrus", again.Name)
+	assert.Equal(t, ids["fruit"], *again.ParentID)
+}
@@ -0,0 +1,302 @@
+// Package mysql stores the category tree as an adjacency list and walks it
+// with recursive common table expressions, which take mysql 8:
+//
+//	CREATE TABLE categories (
+//		id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+//		parent_id  BIGINT NULL,
+//		name       VARCHAR(255) NOT NULL,
+//		slug       VARCHAR(255) NOT NULL UNIQUE,
+//		created_at DATETIME NOT NULL,
+//		updated_at DATETIME NOT NULL,
+//		KEY (parent_id),
+//		FOREIGN KEY (parent_id) REFERENCES categories (id)
+//	);
+//	CREATE TABLE product_categories (
+//		category_id BIGINT NOT NULL,
+//		product_id  BIGINT NOT NULL,
+//		PRIMARY KEY (category_id, product_id),
+//		KEY (product_id),
+//		FOREIGN KEY (category_id) REFERENCES categories (id)
+//	);
+//
+// The products may be stored in another database, so product_id has no
+// foreign key.
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const mysqlErrDuplicateEntry = 1062
+
+const (
+	insertQuery        = `INSERT categories SET parent_id=?, name=?, slug=?, created_at=?, updated_at=?`
+	updateQuery        = `UPDATE categories SET parent_id=?, name=?, updated_at=? WHERE id=?`
+	lockQuery          = `SELECT parent_id FROM categories WHERE id=? FOR UPDATE`
+	countChildrenQuery = `SELECT COUNT(*) FROM categories WHERE parent_id=?`
+	deleteLinksQuery   = `DELETE FROM product_categories WHERE category_id=?`
+	deleteQuery        = `DELETE FROM categories WHERE id=?`
+	getByIDQuery       = `SELECT id, parent_id, name, slug, created_at, updated_at FROM categories WHERE id=?`
+	getBySlugQuery     = `SELECT id, parent_id, name, slug, created_at, updated_at FROM categories WHERE slug=?`
+	ancestorsQuery     = `WITH RECURSIVE ancestors (id, parent_id, name, slug, created_at, updated_at, depth) AS (
+	SELECT id, parent_id, name, slug, created_at, updated_at, 0 FROM categories WHERE id=?
+	UNION ALL
+	SELECT c.id, c.parent_id, c.name, c.slug, c.created_at, c.updated_at, a.depth + 1
+	FROM categories c JOIN ancestors a ON c.id = a.parent_id
+)
+SELECT id, parent_id, name, slug, created_at, updated_at FROM ancestors ORDER BY depth DESC`
+	descendantsQuery = `WITH RECURSIVE descendants (id, parent_id, name, slug, created_at, updated_at, depth) AS (
+	SELECT id, parent_id, name, slug, created_at, updated_at, 0 FROM categories WHERE id=?
+	UNION ALL
+	SELECT c.id, c.parent_id, c.name, c.slug, c.created_at, c.updated_at, d.depth + 1
+	FROM categories c JOIN descendants d ON c.parent_id = d.id
+)
+SELECT id, parent_id, name, slug, created_at, updated_at FROM descendants ORDER BY depth, id`
+	linkQuery               = `INSERT IGNORE INTO product_categories (category_id, product_id) VALUES (?, ?)`
+	unlinkQuery             = `DELETE FROM product_categories WHERE category_id=? AND product_id=?`
+	deleteProductLinksQuery = `DELETE FROM product_categories WHERE product_id=?`
+	// productIDsQuery is completed with the placeholders of the categories
+	productIDsQuery = `SELECT DISTINCT product_id FROM product_categories WHERE category_id IN (%s) ORDER BY product_id LIMIT ? OFFSET ?`
+)
+
+type categoryRepository struct {
+	db    *sql.DB
+	retry database.RetryPolicy
+	now   func() time.Time
+}
+
+type Option func(*categoryRepository)
+
+// WithRetry replaces the default retry policy of the reads,
+// database.NoRetry disables it.
+func WithRetry(policy database.RetryPolicy) Option {
+	return func(r *categoryRepository) {
+		r.retry = policy
+	}
+}
+
+// NewCategoryRepository retries the transient errors of the reads, the
+// writes are not retried.
+func NewCategoryRepository(db *sql.DB, opts ...Option) repository.CategoryRepository {
+	r := &categoryRepository{db: db, now: time.Now}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *categoryRepository) Insert(ctx context.Context, c *category.Category) (*category.Category, error) {
+	now := r.now().UTC().Truncate(time.Second)
+	res, err := r.db.ExecContext(ctx, insertQuery, nullID(c.ParentID), c.Name, c.Slug, now, now)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			logctx.From(ctx, nil).Debug("category slug taken", zap.String("slug", c.Slug))
+			return nil, repository.ErrSlugTaken
+		}
+		return nil, err
+	}
+	if c.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	c.CreatedAt, c.UpdatedAt = now, now
+	return c, nil
+}
+
+// Update locks the category and the ancestors of its new parent before
+// checking for a cycle, so two categories moved under each other at the same
+// time can't both succeed.
+func (r *categoryRepository) Update(ctx context.Context, c *category.Category) (*category.Category, error) {
+	now := r.now().UTC().Truncate(time.Second)
+	err := database.NoRetry.Transact(ctx, r.db, nil, func(ctx context.Context, tx *sql.Tx) error {
+		var parentID sql.NullInt64
+		if err := tx.QueryRowContext(ctx, lockQuery, c.ID).Scan(&parentID); err != nil {
+			return err
+		}
+		if c.ParentID != nil {
+			// the new parent can't be below the category
+			for id := *c.ParentID; ; id = parentID.Int64 {
+				if id == c.ID {
+					return repository.ErrCycle
+				}
+				if err := tx.QueryRowContext(ctx, lockQuery, id).Scan(&parentID); err != nil {
+					return err
+				}
+				if !parentID.Valid {
+					break
+				}
+			}
+		}
+		_, err := tx.ExecContext(ctx, updateQuery, nullID(c.ParentID), c.Name, now, c.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(ctx, c.ID)
+}
+
+func (r *categoryRepository) Delete(ctx context.Context, id int64) error {
+	return database.NoRetry.Transact(ctx, r.db, nil, func(ctx context.Context, tx *sql.Tx) error {
+		var children int
+		if err := tx.QueryRowContext(ctx, countChildrenQuery, id).Scan(&children); err != nil {
+			return err
+		}
+		if children > 0 {
+			return repository.ErrNotEmpty
+		}
+		if _, err := tx.ExecContext(ctx, deleteLinksQuery, id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, deleteQuery, id)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected != 1 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+func (r *categoryRepository) GetByID(ctx context.Context, id int64) (*category.Category, error) {
+	return r.getOne(ctx, getByIDQuery, id)
+}
+
+func (r *categoryRepository) GetBySlug(ctx context.Context, slug string) (*category.Category, error) {
+	return r.getOne(ctx, getBySlugQuery, slug)
+}
+
+func (r *categoryRepository) getOne(ctx context.Context, query string, arg any) (*category.Category, error) {
+	var c *category.Category
+	err := r.retry.Do(ctx, func(ctx context.Context) (err error) {
+		c, err = scanCategory(r.db.QueryRowContext(ctx, query, arg))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *categoryRepository) Ancestors(ctx context.Context, id int64) ([]*category.Category, error) {
+	return r.getMany(ctx, ancestorsQuery, id)
+}
+
+func (r *categoryRepository) Descendants(ctx context.Context, id int64) ([]*category.Category, error) {
+	return r.getMany(ctx, descendantsQuery, id)
+}
+
+// getMany runs a query walking the tree from id, there is no row when id
+// doesn't exist.
+func (r *categoryRepository) getMany(ctx context.Context, query string, id int64) ([]*category.Category, error) {
+	var categories []*category.Category
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		rows, err := r.db.QueryContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		categories = categories[:0]
+		for rows.Next() {
+			c, err := scanCategory(rows)
+			if err != nil {
+				return err
+			}
+			categories = append(categories, c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return categories, nil
+}
+
+func (r *categoryRepository) LinkProduct(ctx context.Context, categoryID, productID int64) error {
+	_, err := r.db.ExecContext(ctx, linkQuery, categoryID, productID)
+	return err
+}
+
+func (r *categoryRepository) UnlinkProduct(ctx context.Context, categoryID, productID int64) error {
+	_, err := r.db.ExecContext(ctx, unlinkQuery, categoryID, productID)
+	return err
+}
+
+func (r *categoryRepository) DeleteProductLinks(ctx context.Context, productID int64) error {
+	_, err := r.db.ExecContext(ctx, deleteProductLinksQuery, productID)
+	return err
+}
+
+func (r *categoryRepository) ProductIDs(ctx context.Context, categoryIDs []int64, limit, offset int) ([]int64, error) {
+	if len(categoryIDs) == 0 {
+		return []int64{}, nil
+	}
+	args := make([]any, 0, len(categoryIDs)+2)
+	for _, id := range categoryIDs {
+		args = append(args, id)
+	}
+	args = append(args, limit, offset)
+	query := strings.Replace(productIDsQuery, "%s", strings.TrimSuffix(strings.Repeat("?,", len(categoryIDs)), ","), 1)
+	var ids []int64
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		ids = make([]int64, 0, limit)
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCategory(row scanner) (*category.Category, error) {
+	var c category.Category
+	var parentID sql.NullInt64
+	if err := row.Scan(&c.ID, &parentID, &c.Name, &c.Slug, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		c.ParentID = &parentID.Int64
+	}
+	return &c, nil
+}
+
+func nullID(id *int64) sql.NullInt64 {
+	if id == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *id, Valid: true}
+}
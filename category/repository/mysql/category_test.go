@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var categoryColumns = []string{"id", "parent_id", "name", "slug", "created_at", "updated_at"}
+
+func TestCategoryRepository_Insert(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := &categoryRepository{db: db, now: func() time.Time { return now }}
+	parentID := int64(4)
+	mock.ExpectExec(insertQuery).WithArgs(sql.NullInt64{Int64: 4, Valid: true}, "Citrus", "citrus", now, now).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	created, err := r.Insert(context.TODO(), &category.Category{ParentID: &parentID, Name: "Citrus", Slug: "citrus"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, created.ID)
+	assert.Equal(t, now, created.CreatedAt)
+	t.Run("reports a taken slug", func(t *testing.T) {
+		mock.ExpectExec(insertQuery).WithArgs(sql.NullInt64{}, "Citrus", "citrus", now, now).
+			WillReturnError(&mysql.MySQLError{Number: 1062})
+		_, err := r.Insert(context.TODO(), &category.Category{Name: "Citrus", Slug: "citrus"})
+		assert.ErrorIs(t, err, repository.ErrSlugTaken)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCategoryRepository_Ancestors(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	rows := sqlmock.NewRows(categoryColumns).
+		AddRow(1, nil, "Food", "food", time.Now(), time.Now()).
+		AddRow(2, 1, "Fruit", "fruit", time.Now(), time.Now())
+	mock.ExpectQuery(ancestorsQuery).WithArgs(2).WillReturnRows(rows)
+	r := NewCategoryRepository(db)
+	path, err := r.Ancestors(context.TODO(), 2)
+	assert.NoError(t, err)
+	if assert.Len(t, path, 2) {
+		assert.Nil(t, path[0].ParentID)
+		assert.EqualValues(t, 1, *path[1].ParentID)
+	}
+	t.Run("reports a missing category", func(t *testing.T) {
+		mock.ExpectQuery(ancestorsQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows(categoryColumns))
+		_, err := r.Ancestors(context.TODO(), 9)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestCategoryRepository_Update(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	r := NewCategoryRepository(db)
+	parentID := int64(3)
+	parentColumns := []string{"parent_id"}
+	t.Run("moves a category", func(t *testing.T) {
+		// 3 is a root
+		now := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows(parentColumns).AddRow(1))
+		mock.ExpectQuery(lockQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows(parentColumns).AddRow(nil))
+		mock.ExpectExec(updateQuery).WithArgs(sql.NullInt64{Int64: 3, Valid: true}, "Fruit", sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(getByIDQuery).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(categoryColumns).AddRow(2, 3, "Fruit", "fruit", now, now))
+		updated, err := r.Update(context.TODO(), &category.Category{ID: 2, ParentID: &parentID, Name: "Fruit"})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 3, *updated.ParentID)
+	})
+	t.Run("refuses a cycle", func(t *testing.T) {
+		// 3 is below 2
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows(parentColumns).AddRow(1))
+		mock.ExpectQuery(lockQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows(parentColumns).AddRow(2))
+		mock.ExpectRollback()
+		_, err := r.Update(context.TODO(), &category.Category{ID: 2, ParentID: &parentID, Name: "Fruit"})
+		assert.ErrorIs(t, err, repository.ErrCycle)
+	})
+	t.Run("reports a missing category", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows(parentColumns))
+		mock.ExpectRollback()
+		_, err := r.Update(context.TODO(), &category.Category{ID: 2, Name: "Fruit"})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCategoryRepository_Delete(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	r := NewCategoryRepository(db)
+	mock.ExpectBegin()
+	mock.ExpectQuery(countChildrenQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(deleteLinksQuery).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(deleteQuery).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, r.Delete(context.TODO(), 3))
+	t.Run("keeps a category with children", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(countChildrenQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+		assert.ErrorIs(t, r.Delete(context.TODO(), 2), repository.ErrNotEmpty)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCategoryRepository_ProductIDs(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	query := `SELECT DISTINCT product_id FROM product_categories WHERE category_id IN (?,?,?) ORDER BY product_id LIMIT ? OFFSET ?`
+	mock.ExpectQuery(query).WithArgs(1, 2, 3, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(4).AddRow(9))
+	r := NewCategoryRepository(db)
+	ids, err := r.ProductIDs(context.TODO(), []int64{1, 2, 3}, 10, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4, 9}, ids)
+	ids, err = r.ProductIDs(context.TODO(), nil, 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCategoryRepository_LinkProduct(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	mock.ExpectExec(linkQuery).WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(unlinkQuery).WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(deleteProductLinksQuery).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 3))
+	r := NewCategoryRepository(db)
+	assert.NoError(t, r.LinkProduct(context.TODO(), 2, 5))
+	assert.NoError(t, r.UnlinkProduct(context.TODO(), 2, 5))
+	assert.NoError(t, r.DeleteProductLinks(context.TODO(), 5))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func createMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return db, mock
+}
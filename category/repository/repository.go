@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/halilylm/microservice/category"
+	"time"
+)
+
+// ErrSlugTaken is returned by Insert when another category has the slug.
+var ErrSlugTaken = errors.New("slug is already taken")
+
+// ErrCycle is returned by Update when the new parent is the category itself
+// or one of its descendants.
+var ErrCycle = errors.New("a category can't be moved under itself")
+
+// ErrNotEmpty is returned by Delete for a category that still has children.
+var ErrNotEmpty = errors.New("category has subcategories")
+
+// ErrCacheMiss is returned by the cache for the slugs that are not cached.
+var ErrCacheMiss = errors.New("category is not cached")
+
+// CategoryRepository stores the tree as an adjacency list, every category
+// points to its parent. The missing categories are reported as
+// sql.ErrNoRows.
+type CategoryRepository interface {
+	Insert(ctx context.Context, c *category.Category) (*category.Category, error)
+	// Update renames c and moves it under its ParentID, the slug is kept.
+	Update(ctx context.Context, c *category.Category) (*category.Category, error)
+	// Delete removes a leaf category and its links to the products.
+	Delete(ctx context.Context, id int64) error
+	GetByID(ctx context.Context, id int64) (*category.Category, error)
+	GetBySlug(ctx context.Context, slug string) (*category.Category, error)
+	// Ancestors returns the path from the root down to id, id included.
+	Ancestors(ctx context.Context, id int64) ([]*category.Category, error)
+	// Descendants returns id and every category under it, parents before
+	// their children.
+	Descendants(ctx context.Context, id int64) ([]*category.Category, error)
+	// LinkProduct is idempotent, like UnlinkProduct.
+	LinkProduct(ctx context.Context, categoryID, productID int64) error
+	UnlinkProduct(ctx context.Context, categoryID, productID int64) error
+	// DeleteProductLinks unlinks a deleted product from every category.
+	DeleteProductLinks(ctx context.Context, productID int64) error
+	// ProductIDs returns the distinct ids of the products linked to any of
+	// categoryIDs, in ascending order.
+	ProductIDs(ctx context.Context, categoryIDs []int64, limit, offset int) ([]int64, error)
+}
+
+type CategoryCacheRepository interface {
+	SetNode(ctx context.Context, key string, expire time.Duration, node *category.Node) error
+	GetNode(ctx context.Context, key string) (*category.Node, error)
+	DeleteNodes(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,333 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gosimple/slug"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/halilylm/microservice/pkg/rbac"
+	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/halilylm/microservice/product"
+	productrepository "github.com/halilylm/microservice/product/repository"
+	"go.uber.org/zap"
+	"time"
+)
+
+// nodeTTL bounds how long a node is served after a change whose
+// invalidation failed.
+const nodeTTL = time.Minute
+
+type categoryUC struct {
+	repo     repository.CategoryRepository
+	cache    repository.CategoryCacheRepository
+	products productrepository.ProductRepository
+	authz    rbac.Authorizer
+	logger   *zap.Logger
+}
+
+// NewCategoryUC creates the category use case, the products of the
+// categories are read from products. A nil authorizer allows every action.
+func NewCategoryUC(repo repository.CategoryRepository, cache repository.CategoryCacheRepository, products productrepository.ProductRepository, authz rbac.Authorizer, logger *zap.Logger) CategoryUseCase {
+	if authz == nil {
+		authz = rbac.AllowAll()
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &categoryUC{repo: repo, cache: cache, products: products, authz: authz, logger: logger}
+}
+
+func (c *categoryUC) CreateCategory(ctx context.Context, cat *category.Category) (*category.Category, error) {
+	if err := c.authorize(ctx, rbac.ActionCategoryCreate); err != nil {
+		return nil, err
+	}
+	var parents []*category.Category
+	if cat.ParentID != nil {
+		var err error
+		if parents, err = c.ancestors(ctx, *cat.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	genSlug := slug.Make(cat.Name)
+	for i := 1; ; i++ {
+		found, _ := c.repo.GetBySlug(ctx, genSlug)
+		if found == nil {
+			break
+		}
+		genSlug = fmt.Sprintf("%s-%d", genSlug, i)
+	}
+	cat.Slug = genSlug
+	created, err := c.repo.Insert(ctx, cat)
+	if err != nil {
+		// another category took the slug since it was looked up
+		if errors.Is(err, repository.ErrSlugTaken) {
+			return nil, rest.NewConflict(err.Error())
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	// the subtrees of the parents grew
+	c.invalidate(ctx, slugs(parents)...)
+	return created, nil
+}
+
+// UpdateCategory renames the category and moves it under its ParentID, the
+// slug doesn't change.
+func (c *categoryUC) UpdateCategory(ctx context.Context, cat *category.Category) (*category.Category, error) {
+	if err := c.authorize(ctx, rbac.ActionCategoryUpdate); err != nil {
+		return nil, err
+	}
+	// the breadcrumbs of the whole subtree change and so do the subtrees of
+	// the old and the new ancestors
+	oldPath, err := c.repo.Ancestors(ctx, cat.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rest.NewNotFoundError()
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	var newPath []*category.Category
+	if cat.ParentID != nil {
+		if newPath, err = c.ancestors(ctx, *cat.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	subtree, err := c.repo.Descendants(ctx, cat.ID)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	updated, err := c.repo.Update(ctx, cat)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, rest.NewNotFoundError()
+		case errors.Is(err, repository.ErrCycle):
+			return nil, rest.NewUnprocessableEntity(err.Error())
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	c.invalidate(ctx, slugs(append(append(oldPath, newPath...), subtree...))...)
+	return updated, nil
+}
+
+// DeleteCategory deletes a category without subcategories, its products are
+// only unlinked.
+func (c *categoryUC) DeleteCategory(ctx context.Context, id int64) error {
+	if err := c.authorize(ctx, rbac.ActionCategoryDelete); err != nil {
+		return err
+	}
+	// the slugs are the cache keys
+	path, err := c.repo.Ancestors(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rest.NewNotFoundError()
+		}
+		return rest.NewInternalServerError()
+	}
+	if err := c.repo.Delete(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return rest.NewNotFoundError()
+		case errors.Is(err, repository.ErrNotEmpty):
+			return rest.NewConflict(err.Error())
+		}
+		return rest.NewInternalServerError()
+	}
+	c.invalidate(ctx, slugs(path)...)
+	return nil
+}
+
+func (c *categoryUC) GetCategoryBySlug(ctx context.Context, slug string) (*category.Category, error) {
+	node, err := c.node(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	return node.Category, nil
+}
+
+func (c *categoryUC) GetBreadcrumb(ctx context.Context, slug string) ([]category.Crumb, error) {
+	node, err := c.node(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	return node.Breadcrumb, nil
+}
+
+// ListProducts pages through the products of the category and of all its
+// descendants, ordered by id.
+func (c *categoryUC) ListProducts(ctx context.Context, slug string, limit, offset int) ([]*product.Product, error) {
+	node, err := c.node(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := c.repo.ProductIDs(ctx, node.Subtree, limit, offset)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	// the links of a product are dropped once it is deleted, a product
+	// deleted in between is left out
+	products, err := c.products.GetProductsByIDs(ctx, ids)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	return products, nil
+}
+
+// LinkProduct links the product of productSlug to the category, the
+// products are only known to the clients by slug.
+func (c *categoryUC) LinkProduct(ctx context.Context, categoryID int64, productSlug string) error {
+	if err := c.authorize(ctx, rbac.ActionCategoryUpdate); err != nil {
+		return err
+	}
+	if _, err := c.repo.GetByID(ctx, categoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rest.NewNotFoundError()
+		}
+		return rest.NewInternalServerError()
+	}
+	p, err := c.productBySlug(ctx, productSlug)
+	if err != nil {
+		return err
+	}
+	if err := c.repo.LinkProduct(ctx, categoryID, p.ID); err != nil {
+		return rest.NewInternalServerError()
+	}
+	return nil
+}
+
+func (c *categoryUC) UnlinkProduct(ctx context.Context, categoryID int64, productSlug string) error {
+	if err := c.authorize(ctx, rbac.ActionCategoryUpdate); err != nil {
+		return err
+	}
+	p, err := c.productBySlug(ctx, productSlug)
+	if err != nil {
+		return err
+	}
+	if err := c.repo.UnlinkProduct(ctx, categoryID, p.ID); err != nil {
+		return rest.NewInternalServerError()
+	}
+	return nil
+}
+
+// productBySlug reads the product to link, the links of the deleted products
+// are already dropped.
+func (c *categoryUC) productBySlug(ctx context.Context, slug string) (*product.Product, error) {
+	p, err := c.products.GetProductBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rest.NewNotFoundError()
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	return p, nil
+}
+
+// node returns the category of slug with its breadcrumb and its subtree,
+// from the cache when it is there.
+func (c *categoryUC) node(ctx context.Context, slug string) (*category.Node, error) {
+	if node, err := c.cache.GetNode(ctx, slug); err == nil {
+		c.log(ctx).Debug("getting category from the cache", zap.Int64("id", node.Category.ID))
+		return node, nil
+	}
+	found, err := c.repo.GetBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rest.NewNotFoundError()
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	path, err := c.repo.Ancestors(ctx, found.ID)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	subtree, err := c.repo.Descendants(ctx, found.ID)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	node := &category.Node{
+		Category:   found,
+		Breadcrumb: make([]category.Crumb, len(path)),
+		Subtree:    make([]int64, len(subtree)),
+	}
+	for i, ancestor := range path {
+		node.Breadcrumb[i] = category.Crumb{ID: ancestor.ID, Name: ancestor.Name, Slug: ancestor.Slug}
+	}
+	for i, descendant := range subtree {
+		node.Subtree[i] = descendant.ID
+	}
+	if err := c.cache.SetNode(ctx, slug, nodeTTL, node); err != nil {
+		c.log(ctx).Error("could not cache the category", zap.Int64("id", found.ID), zap.Error(err))
+	}
+	return node, nil
+}
+
+// ancestors returns the path down to the parent of a new or moved category,
+// a missing parent makes the request invalid.
+func (c *categoryUC) ancestors(ctx context.Context, parentID int64) ([]*category.Category, error) {
+	path, err := c.repo.Ancestors(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rest.NewUnprocessableEntity("parent category does not exist")
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	return path, nil
+}
+
+// invalidate drops the cached nodes of keys after the tree changed, they are
+// served stale until the cache entries expire when that fails.
+func (c *categoryUC) invalidate(ctx context.Context, keys ...string) {
+	if err := c.cache.DeleteNodes(ctx, keys...); err != nil {
+		c.log(ctx).Warn("could not invalidate the cached categories", zap.Strings("slugs", keys), zap.Error(err))
+	}
+}
+
+// authorize consults the policy and turns its decision into an http error.
+func (c *categoryUC) authorize(ctx context.Context, action rbac.Action) error {
+	err := c.authz.Authorize(ctx, action)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, rbac.ErrNoActor) {
+		return rest.NewUnauthorized(err.Error())
+	}
+	var denied *rbac.DeniedError
+	if errors.As(err, &denied) {
+		return rest.NewForbidden(denied.Error())
+	}
+	c.log(ctx).Error("could not authorize the action", zap.String("action", string(action)), zap.Error(err))
+	return rest.NewInternalServerError()
+}
+
+// slugs returns the slugs of categories without duplicates.
+func slugs(categories []*category.Category) []string {
+	seen := make(map[string]bool, len(categories))
+	result := make([]string, 0, len(categories))
+	for _, cat := range categories {
+		if !seen[cat.Slug] {
+			seen[cat.Slug] = true
+			result = append(result, cat.Slug)
+		}
+	}
+	return result
+}
+
+type CategoryUseCase interface {
+	CreateCategory(ctx context.Context, cat *category.Category) (*category.Category, error)
+	UpdateCategory(ctx context.Context, cat *category.Category) (*category.Category, error)
+	DeleteCategory(ctx context.Context, id int64) error
+	GetCategoryBySlug(ctx context.Context, slug string) (*category.Category, error)
+	GetBreadcrumb(ctx context.Context, slug string) ([]category.Crumb, error)
+	ListProducts(ctx context.Context, slug string, limit, offset int) ([]*product.Product, error)
+	LinkProduct(ctx context.Context, categoryID int64, productSlug string) error
+	UnlinkProduct(ctx context.Context, categoryID int64, productSlug string) error
+}
+
+// log is the logger of the request, it carries the request id and the
+// route.
+func (c *categoryUC) log(ctx context.Context) *zap.Logger {
+	return logctx.From(ctx, c.logger)
+}
@@ -0,0 +1,233 @@
+package usecase
+
+import (
+	"context"
+	"github.com/halilylm/microservice/category"
+	"github.com/halilylm/microservice/category/repository"
+	"github.com/halilylm/microservice/category/repository/memory"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/halilylm/microservice/pkg/rbac"
+	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/halilylm/microservice/product"
+	productrepository "github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapCache keeps the nodes without expiring them.
+type mapCache struct {
+	mu    sync.Mutex
+	nodes map[string]*category.Node
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{nodes: make(map[string]*category.Node)}
+}
+
+func (c *mapCache) SetNode(ctx context.Context, key string, expire time.Duration, node *category.Node) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodes[key] = node
+	return nil
+}
+
+func (c *mapCache) GetNode(ctx context.Context, key string) (*category.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if node, ok := c.nodes[key]; ok {
+		return node, nil
+	}
+	return nil, repository.ErrCacheMiss
+}
+
+func (c *mapCache) DeleteNodes(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.nodes, key)
+	}
+	return nil
+}
+
+func (c *mapCache) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.nodes[key]
+	return ok
+}
+
+func assertStatus(t *testing.T, code int, err error) {
+	t.Helper()
+	var httpErr *rest.HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, code, httpErr.Code)
+	}
+}
+
+func create(t *testing.T, uc CategoryUseCase, name string, parent *category.Category) *category.Category {
+	t.Helper()
+	c := &category.Category{Name: name}
+	if parent != nil {
+		c.ParentID = &parent.ID
+	}
+	created, err := uc.CreateCategory(context.TODO(), c)
+	require.NoError(t, err)
+	return created
+}
+
+func crumbSlugs(crumbs []category.Crumb) []string {
+	slugs := make([]string, len(crumbs))
+	for i, crumb := range crumbs {
+		slugs[i] = crumb.Slug
+	}
+	return slugs
+}
+
+func TestCategoryUC_CreateCategory(t *testing.T) {
+	t.Parallel()
+	uc := NewCategoryUC(memory.NewCategoryRepository(), newMapCache(), productrepository.NewMockProductRepository(nil), nil, zap.NewNop())
+	food := create(t, uc, "Food & Drinks", nil)
+	assert.Equal(t, "food-and-drinks", food.Slug)
+	t.Run("proper slug on collide", func(t *testing.T) {
+		again := create(t, uc, "Food & Drinks", food)
+		assert.Equal(t, "food-and-drinks-1", again.Slug)
+		assert.Equal(t, food.ID, *again.ParentID)
+	})
+	t.Run("needs an existing parent", func(t *testing.T) {
+		missing := int64(100)
+		_, err := uc.CreateCategory(context.TODO(), &category.Category{Name: "nuts", ParentID: &missing})
+		assertStatus(t, http.StatusUnprocessableEntity, err)
+	})
+}
+
+func TestCategoryUC_Tree(t *testing.T) {
+	t.Parallel()
+	cache := newMapCache()
+	uc := NewCategoryUC(memory.NewCategoryRepository(), cache, productrepository.NewMockProductRepository(nil), nil, zap.NewNop())
+	food := create(t, uc, "food", nil)
+	fruit := create(t, uc, "fruit", food)
+	citrus := create(t, uc, "citrus", fruit)
+	vegetables := create(t, uc, "vegetables", food)
+
+	crumbs, err := uc.GetBreadcrumb(context.TODO(), "citrus")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"food", "fruit", "citrus"}, crumbSlugs(crumbs))
+	_, err = uc.GetBreadcrumb(context.TODO(), "nuts")
+	assertStatus(t, http.StatusNotFound, err)
+	_, err = uc.GetCategoryBySlug(context.TODO(), "food")
+	assert.NoError(t, err)
+
+	t.Run("a new category invalidates its ancestors", func(t *testing.T) {
+		assert.True(t, cache.has("food"))
+		create(t, uc, "lemons", citrus)
+		assert.False(t, cache.has("food"))
+		assert.False(t, cache.has("citrus"))
+	})
+	t.Run("moving invalidates the subtree and both paths", func(t *testing.T) {
+		_, err := uc.GetBreadcrumb(context.TODO(), "lemons")
+		assert.NoError(t, err)
+		_, err = uc.GetCategoryBySlug(context.TODO(), "vegetables")
+		assert.NoError(t, err)
+		moved, err := uc.UpdateCategory(context.TODO(), &category.Category{ID: citrus.ID, Name: "Citrus", ParentID: &vegetables.ID})
+		assert.NoError(t, err)
+		assert.Equal(t, "citrus", moved.Slug)
+		assert.False(t, cache.has("vegetables"))
+		crumbs, err := uc.GetBreadcrumb(context.TODO(), "lemons")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"food", "vegetables", "citrus", "lemons"}, crumbSlugs(crumbs))
+		assert.Equal(t, "Citrus", crumbs[2].Name)
+	})
+	t.Run("refuses to move a category under itself", func(t *testing.T) {
+		_, err := uc.UpdateCategory(context.TODO(), &category.Category{ID: food.ID, Name: "food", ParentID: &citrus.ID})
+		assertStatus(t, http.StatusUnprocessableEntity, err)
+		_, err = uc.UpdateCategory(context.TODO(), &category.Category{ID: 100, Name: "food"})
+		assertStatus(t, http.StatusNotFound, err)
+	})
+	t.Run("deletes the leaves only", func(t *testing.T) {
+		assertStatus(t, http.StatusConflict, uc.DeleteCategory(context.TODO(), food.ID))
+		_, err := uc.GetCategoryBySlug(context.TODO(), "fruit")
+		assert.NoError(t, err)
+		assert.NoError(t, uc.DeleteCategory(context.TODO(), fruit.ID))
+		assert.False(t, cache.has("fruit"))
+		assert.False(t, cache.has("food"))
+		_, err = uc.GetCategoryBySlug(context.TODO(), "fruit")
+		assertStatus(t, http.StatusNotFound, err)
+		assertStatus(t, http.StatusNotFound, uc.DeleteCategory(context.TODO(), fruit.ID))
+	})
+}
+
+func TestCategoryUC_ListProducts(t *testing.T) {
+	t.Parallel()
+	products := productrepository.NewMockProductRepository(map[int64]*product.Product{
+		1: {ID: 1, Name: "lemon", Slug: "lemon", Price: 1},
+		2: {ID: 2, Name: "apple", Slug: "apple", Price: 2},
+		3: {ID: 3, Name: "carrot", Slug: "carrot", Price: 3},
+		4: {ID: 4, Name: "lime", Slug: "lime", Price: 4},
+	})
+	uc := NewCategoryUC(memory.NewCategoryRepository(), newMapCache(), products, nil, zap.NewNop())
+	food := create(t, uc, "food", nil)
+	fruit := create(t, uc, "fruit", food)
+	citrus := create(t, uc, "citrus", fruit)
+	vegetables := create(t, uc, "vegetables", food)
+	for _, link := range []struct {
+		category *category.Category
+		product  string
+	}{{citrus, "lemon"}, {citrus, "lime"}, {fruit, "apple"}, {fruit, "lemon"}, {vegetables, "carrot"}} {
+		require.NoError(t, uc.LinkProduct(context.TODO(), link.category.ID, link.product))
+	}
+	names := func(products []*product.Product) []string {
+		result := make([]string, len(products))
+		for i, p := range products {
+			result[i] = p.Name
+		}
+		return result
+	}
+
+	listed, err := uc.ListProducts(context.TODO(), "fruit", 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lemon", "apple", "lime"}, names(listed))
+	listed, err = uc.ListProducts(context.TODO(), "food", 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"carrot", "lime"}, names(listed))
+	_, err = uc.ListProducts(context.TODO(), "nuts", 10, 0)
+	assertStatus(t, http.StatusNotFound, err)
+
+	t.Run("skips the deleted products", func(t *testing.T) {
+		require.NoError(t, products.Delete(context.TODO(), 4))
+		listed, err := uc.ListProducts(context.TODO(), "citrus", 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"lemon"}, names(listed))
+	})
+	t.Run("links existing products only", func(t *testing.T) {
+		assertStatus(t, http.StatusNotFound, uc.LinkProduct(context.TODO(), citrus.ID, "pear"))
+		assertStatus(t, http.StatusNotFound, uc.LinkProduct(context.TODO(), 100, "lemon"))
+	})
+	t.Run("unlinks", func(t *testing.T) {
+		assert.NoError(t, uc.UnlinkProduct(context.TODO(), fruit.ID, "apple"))
+		assert.NoError(t, uc.UnlinkProduct(context.TODO(), fruit.ID, "apple"))
+		listed, err := uc.ListProducts(context.TODO(), "fruit", 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"lemon"}, names(listed))
+	})
+}
+
+func TestCategoryUC_Authorization(t *testing.T) {
+	t.Parallel()
+	uc := NewCategoryUC(memory.NewCategoryRepository(), newMapCache(), productrepository.NewMockProductRepository(nil), rbac.NewEnforcer(rbac.DefaultPolicy(), nil), zap.NewNop())
+	_, err := uc.CreateCategory(context.TODO(), &category.Category{Name: "food"})
+	assertStatus(t, http.StatusUnauthorized, err)
+	viewer := auth.NewContext(context.TODO(), &auth.Principal{Subject: "vi", Roles: []string{"viewer"}})
+	_, err = uc.CreateCategory(viewer, &category.Category{Name: "food"})
+	assertStatus(t, http.StatusForbidden, err)
+	editor := auth.NewContext(context.TODO(), &auth.Principal{Subject: "ed", Roles: []string{"editor"}})
+	food, err := uc.CreateCategory(editor, &category.Category{Name: "food"})
+	require.NoError(t, err)
+	assertStatus(t, http.StatusForbidden, uc.DeleteCategory(editor, food.ID))
+	publisher := auth.NewContext(context.TODO(), &auth.Principal{Subject: "pu", Roles: []string{"publisher"}})
+	assert.NoError(t, uc.DeleteCategory(publisher, food.ID))
+}
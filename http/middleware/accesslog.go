@@ -0,0 +1,335 @@
+package middleware
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"go.uber.org/zap"
+	"io"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the values of the sensitive parameters and headers.
+const redacted = "[REDACTED]"
+
+// DefaultRedactedParams are the query and form parameters, the top-level JSON
+// keys and the XML elements whose values are never logged, key holds the new
+// api keys.
+var DefaultRedactedParams = []string{"access_token", "api_key", "apikey", "key", "password", "secret", "signature", "token"}
+
+// DefaultRedactedHeaders are the headers whose values are never logged.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie", "X-Api-Key"}
+
+type RequestLoggerOptions struct {
+	Logger *zap.Logger
+	// Headers are the request headers logged under
+	// http.request.headers.<name>.
+	Headers []string
+	// RedactParams and RedactHeaders replace the defaults, the names are
+	// case insensitive.
+	RedactParams  []string
+	RedactHeaders []string
+	// Sampling is the share of the requests logged per status class, keyed
+	// by the first digit of the status, e.g. {2: 0.1} logs a tenth of the
+	// successful requests. The classes missing are all logged.
+	Sampling map[int]float64
+	// BodyLimit captures the first BodyLimit bytes of the textual request
+	// and response bodies, zero disables the capture. The bodies are logged
+	// as they are except for the redacted form parameters, top-level JSON
+	// keys and XML elements, it is meant for debugging.
+	BodyLimit int
+}
+
+// RequestLogger logs every request once it is served with the ECS http.*,
+// url.* and user_agent.* fields. It goes after ContextLogger, whose fields it
+// logs too.
+func RequestLogger(opts RequestLoggerOptions) func(next http.Handler) http.Handler {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.RedactParams == nil {
+		opts.RedactParams = DefaultRedactedParams
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactedHeaders
+	}
+	params := lowerSet(opts.RedactParams)
+	headers := make(map[string]bool, len(opts.RedactHeaders))
+	for _, h := range opts.RedactHeaders {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			body := &countingBody{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			var reqBody, resBody *limitedBuffer
+			if opts.BodyLimit > 0 {
+				if isTextual(r.Header.Get("Content-Type")) {
+					reqBody = &limitedBuffer{limit: opts.BodyLimit}
+					body.capture = reqBody
+				}
+				resBody = &limitedBuffer{limit: opts.BodyLimit}
+				ww.Tee(resBody)
+			}
+			start := time.Now()
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					// nothing was written, net/http answers 200
+					status = http.StatusOK
+				}
+				if rate, ok := opts.Sampling[status/100]; ok && (rate <= 0 || rate < 1 && rand.Float64() >= rate) {
+					return
+				}
+				requestBytes := body.n
+				if r.ContentLength > requestBytes {
+					requestBytes = r.ContentLength
+				}
+				fields := []zap.Field{
+					zap.String("http.version", strings.TrimPrefix(r.Proto, "HTTP/")),
+					zap.String("http.request.method", r.Method),
+					zap.Int64("http.request.body.bytes", requestBytes),
+					zap.Int("http.response.status_code", status),
+					zap.Int("http.response.body.bytes", ww.BytesWritten()),
+					zap.Int64("event.duration", time.Since(start).Nanoseconds()),
+					zap.String("url.path", r.URL.Path),
+					zap.String("url.domain", hostOf(r.Host)),
+				}
+				if r.URL.RawQuery != "" {
+					fields = append(fields, zap.String("url.query", redactQuery(r.URL.RawQuery, params)))
+				}
+				if referrer := r.Referer(); referrer != "" {
+					fields = append(fields, zap.String("http.request.referrer", referrer))
+				}
+				if ua := r.UserAgent(); ua != "" {
+					fields = append(fields, zap.String("user_agent.original", ua))
+				}
+				for _, name := range opts.Headers {
+					name = http.CanonicalHeaderKey(name)
+					v := r.Header.Get(name)
+					if v == "" {
+						continue
+					}
+					if headers[name] {
+						v = redacted
+					}
+					fields = append(fields, zap.String("http.request.headers."+strings.ToLower(name), v))
+				}
+				if reqBody != nil {
+					fields = append(fields, zap.String("http.request.body.content", redactBody(reqBody.String(), r.Header.Get("Content-Type"), params)))
+				}
+				if contentType := ww.Header().Get("Content-Type"); resBody != nil && isTextual(contentType) {
+					fields = append(fields, zap.String("http.response.body.content", redactBody(resBody.String(), contentType, params)))
+				}
+				logger := logctx.From(r.Context(), opts.Logger)
+				if status >= http.StatusInternalServerError {
+					logger.Error("request", fields...)
+					return
+				}
+				logger.Info("request", fields...)
+			}()
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// redactQuery replaces the values of the params of a query string, keeping
+// the order and the encoding of the others.
+func redactQuery(query string, params map[string]bool) string {
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if hasValue && params[strings.ToLower(name)] {
+			pairs[i] = key + "=" + redacted
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactBody replaces the values of the params in the form, JSON and XML
+// bodies.
+func redactBody(body, contentType string, params map[string]bool) string {
+	t := mediaType(contentType)
+	switch {
+	case t == "application/x-www-form-urlencoded":
+		return redactQuery(body, params)
+	case t == "application/json", strings.HasSuffix(t, "+json"):
+		return redactJSON(body, params)
+	case t == "application/xml", t == "text/xml", strings.HasSuffix(t, "+xml"):
+		return redactXML(body, params)
+	}
+	return body
+}
+
+// redactJSON replaces the values of the params among the top-level keys of a
+// JSON object. A value cut by the body limit is redacted up to the end, the
+// rest of a body that doesn't parse is kept as it is.
+func redactJSON(body string, params map[string]bool) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return body
+	}
+	var b strings.Builder
+	last := 0
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			break
+		}
+		key, _ := t.(string)
+		start := int(dec.InputOffset())
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if !params[strings.ToLower(key)] {
+			if err != nil {
+				break
+			}
+			continue
+		}
+		b.WriteString(body[last:start])
+		b.WriteString(`:"` + redacted + `"`)
+		if err != nil {
+			return b.String()
+		}
+		last = int(dec.InputOffset())
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// redactXML replaces the content of the elements named after the params,
+// at any depth and whatever their namespace. An element cut by the body limit
+// is redacted up to the end, the rest of a body that doesn't parse is kept as
+// it is.
+func redactXML(body string, params map[string]bool) string {
+	dec := xml.NewDecoder(strings.NewReader(body))
+	var b strings.Builder
+	last, start, depth := 0, 0, 0
+	for {
+		end := int(dec.InputOffset())
+		t, err := dec.RawToken()
+		if err != nil {
+			if depth > 0 {
+				b.WriteString(body[last:start])
+				b.WriteString(redacted)
+				return b.String()
+			}
+			break
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if depth > 0 {
+				depth++
+			} else if params[strings.ToLower(t.Name.Local)] {
+				depth = 1
+				start = int(dec.InputOffset())
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth == 0 && end > start {
+				b.WriteString(body[last:start])
+				b.WriteString(redacted)
+				last = end
+			}
+		}
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// hostOf strips the port of a Host header.
+func hostOf(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
+
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+// isTextual tells if a body of the content type can be logged as a string.
+func isTextual(contentType string) bool {
+	t := mediaType(contentType)
+	switch {
+	case strings.HasPrefix(t, "text/"),
+		t == "application/json", strings.HasSuffix(t, "+json"),
+		t == "application/xml", strings.HasSuffix(t, "+xml"),
+		t == "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// countingBody counts the bytes of the request body read by the handler and
+// copies them to capture.
+type countingBody struct {
+	io.ReadCloser
+	n       int64
+	capture *limitedBuffer
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.capture != nil {
+		_, _ = b.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest.
+type limitedBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); room < len(p) {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return string(b.buf) + "...(truncated at " + strconv.Itoa(b.limit) + " bytes)"
+	}
+	return string(b.buf)
+}
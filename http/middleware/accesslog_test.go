@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveLogged(opts RequestLoggerOptions, h http.HandlerFunc, req *http.Request) *observer.ObservedLogs {
+	core, logs := observer.New(zap.DebugLevel)
+	opts.Logger = zap.New(core)
+	RequestLogger(opts)(h).ServeHTTP(httptest.NewRecorder(), req)
+	return logs
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(body)
+}
+
+func TestRequestLogger(t *testing.T) {
+	t.Run("logs the ECS fields", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "http://shop.example:8080/api/v1/products?page=2&token=abc&Api_Key=k", strings.NewReader(`{"name":"lemon"}`))
+		req.Header.Set("User-Agent", "curl/7.88")
+		req.Header.Set("Referer", "https://shop.example/")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Accept", "application/json")
+		logs := serveLogged(RequestLoggerOptions{Headers: []string{"accept", "authorization", "x-missing"}}, echo, req)
+		entries := logs.All()
+		if !assert.Len(t, entries, 1) {
+			return
+		}
+		fields := entries[0].ContextMap()
+		assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+		assert.Equal(t, "1.1", fields["http.version"])
+		assert.Equal(t, "POST", fields["http.request.method"])
+		assert.EqualValues(t, 16, fields["http.request.body.bytes"])
+		assert.EqualValues(t, 201, fields["http.response.status_code"])
+		assert.EqualValues(t, 16, fields["http.response.body.bytes"])
+		assert.Equal(t, "/api/v1/products", fields["url.path"])
+		assert.Equal(t, "shop.example", fields["url.domain"])
+		assert.Equal(t, "page=2&token=[REDACTED]&Api_Key=[REDACTED]", fields["url.query"])
+		assert.Equal(t, "https://shop.example/", fields["http.request.referrer"])
+		assert.Equal(t, "curl/7.88", fields["user_agent.original"])
+		assert.Equal(t, "application/json", fields["http.request.headers.accept"])
+		assert.Equal(t, "[REDACTED]", fields["http.request.headers.authorization"])
+		assert.NotContains(t, fields, "http.request.headers.x-missing")
+		assert.Contains(t, fields, "event.duration")
+		assert.NotContains(t, fields, "http.request.body.content")
+	})
+	t.Run("logs the server errors as errors", func(t *testing.T) {
+		logs := serveLogged(RequestLoggerOptions{}, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, zapcore.ErrorLevel, logs.All()[0].Level)
+	})
+	t.Run("samples per status class", func(t *testing.T) {
+		opts := RequestLoggerOptions{Sampling: map[int]float64{2: 0, 4: 1}}
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		assert.Zero(t, serveLogged(opts, ok, httptest.NewRequest(http.MethodGet, "/", nil)).Len())
+		notFound := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }
+		assert.Equal(t, 1, serveLogged(opts, notFound, httptest.NewRequest(http.MethodGet, "/", nil)).Len())
+		failed := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }
+		assert.Equal(t, 1, serveLogged(opts, failed, httptest.NewRequest(http.MethodGet, "/", nil)).Len())
+	})
+	t.Run("captures the bodies up to the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"lemon"}`))
+		req.Header.Set("Content-Type", "application/json")
+		fields := serveLogged(RequestLoggerOptions{BodyLimit: 8}, echo, req).All()[0].ContextMap()
+		assert.Equal(t, `{"name":...(truncated at 8 bytes)`, fields["http.request.body.content"])
+		assert.Equal(t, `{"name":...(truncated at 8 bytes)`, fields["http.response.body.content"])
+		assert.EqualValues(t, 16, fields["http.request.body.bytes"])
+	})
+	t.Run("redacts the form bodies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("user=ana&password=hunter2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		fields := serveLogged(RequestLoggerOptions{BodyLimit: 1024}, echo, req).All()[0].ContextMap()
+		assert.Equal(t, "user=ana&password=[REDACTED]", fields["http.request.body.content"])
+	})
+	t.Run("redacts the top-level JSON keys", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "ci", "Password": {"old": "a"}, "nested": {"token": "t"}, "key":"mk_123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		fields := serveLogged(RequestLoggerOptions{BodyLimit: 1024}, echo, req).All()[0].ContextMap()
+		redacted := `{"name": "ci", "Password":"[REDACTED]", "nested": {"token": "t"}, "key":"[REDACTED]"}`
+		assert.Equal(t, redacted, fields["http.request.body.content"])
+		assert.Equal(t, redacted, fields["http.response.body.content"])
+
+		req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"ci","key":"mk_123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		fields = serveLogged(RequestLoggerOptions{BodyLimit: 24}, echo, req).All()[0].ContextMap()
+		assert.Equal(t, `{"name":"ci","key":"[REDACTED]"`, fields["http.request.body.content"])
+	})
+	t.Run("redacts the XML elements", func(t *testing.T) {
+		xmlEcho := func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			_, _ = w.Write(body)
+		}
+		body := `<?xml version="1.0"?><IssuedKey><Name>ci</Name><Key>pk_123</Key><Secret><Old>a</Old></Secret><Token/></IssuedKey>`
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/xml")
+		fields := serveLogged(RequestLoggerOptions{BodyLimit: 1024}, xmlEcho, req).All()[0].ContextMap()
+		redacted := `<?xml version="1.0"?><IssuedKey><Name>ci</Name><Key>[REDACTED]</Key><Secret>[REDACTED]</Secret><Token/></IssuedKey>`
+		assert.Equal(t, redacted, fields["http.request.body.content"])
+		assert.Equal(t, redacted, fields["http.response.body.content"])
+
+		req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`<IssuedKey><Key>pk_123</Key></IssuedKey>`))
+		req.Header.Set("Content-Type", "application/xml")
+		fields = serveLogged(RequestLoggerOptions{BodyLimit: 20}, xmlEcho, req).All()[0].ContextMap()
+		assert.Equal(t, `<IssuedKey><Key>[REDACTED]`, fields["http.request.body.content"])
+	})
+	t.Run("doesn't capture binary bodies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("\x00\x01"))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		fields := serveLogged(RequestLoggerOptions{BodyLimit: 1024}, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			_, _ = w.Write([]byte{0x08, 0x01})
+		}, req).All()[0].ContextMap()
+		assert.NotContains(t, fields, "http.request.body.content")
+		assert.NotContains(t, fields, "http.response.body.content")
+		assert.EqualValues(t, 2, fields["http.request.body.bytes"])
+	})
+}
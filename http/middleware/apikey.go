@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/halilylm/microservice/pkg/rest"
+	"net/http"
+	"strconv"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, plain string) (*apikey.APIKey, error)
+}
+
+// ScopesByMethod maps request methods to the scope a principal needs to call
+// them.
+type ScopesByMethod map[string]string
+
+// APIKeyAuth authenticates the X-API-Key header when it is present and puts
+// the key as the principal of the request.
+func APIKeyAuth(authenticator APIKeyAuthenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			plain := r.Header.Get(APIKeyHeader)
+			if plain == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			k, err := authenticator.Authenticate(r.Context(), plain)
+			if err != nil {
+				var httpErr *rest.HTTPError
+				if errors.As(err, &httpErr) {
+					writeError(w, httpErr)
+					return
+				}
+				writeError(w, rest.NewInternalServerError())
+				return
+			}
+			ctx := auth.NewContext(r.Context(), &auth.Principal{
+				Subject: "apikey:" + strconv.FormatInt(k.ID, 10),
+				Method:  auth.MethodAPIKey,
+				Scopes:  k.Scopes,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope rejects requests without a principal or whose principal lacks
+// scope.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !checkScope(w, r, scope) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScopes checks the principal of the request against the scope of its
+// method. Anonymous requests with a safe method stay public, methods without
+// a scope are rejected.
+func RequireScopes(scopes ScopesByMethod) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.FromContext(r.Context()); !ok && isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scope, ok := scopes[r.Method]
+			if !ok {
+				writeError(w, rest.NewForbidden("no scope grants "+r.Method))
+				return
+			}
+			if !checkScope(w, r, scope) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func checkScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeError(w, rest.NewUnauthorized("authentication required"))
+		return false
+	}
+	if !p.HasScope(scope) {
+		writeError(w, rest.NewForbidden("missing scope "+scope))
+		return false
+	}
+	return true
+}
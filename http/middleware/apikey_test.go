@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"github.com/halilylm/microservice/apikey"
+	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type authenticatorMock map[string]*apikey.APIKey
+
+func (a authenticatorMock) Authenticate(ctx context.Context, plain string) (*apikey.APIKey, error) {
+	k, ok := a[plain]
+	if !ok {
+		return nil, rest.NewUnauthorized("invalid api key")
+	}
+	return k, nil
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	keys := authenticatorMock{
+		"reader": {ID: 1, Scopes: []string{apikey.ScopeProductsRead}},
+		"writer": {ID: 2, Scopes: []string{apikey.ScopeProductsRead, apikey.ScopeProductsWrite}},
+	}
+	handler := APIKeyAuth(keys)(RequireScopes(ScopesByMethod{
+		http.MethodGet:  apikey.ScopeProductsRead,
+		http.MethodPost: apikey.ScopeProductsWrite,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	serve := func(method, key string) int {
+		req := httptest.NewRequest(method, "/api/v1/products", nil)
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+	t.Run("anonymous get is public", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodGet, ""))
+	})
+	t.Run("anonymous post is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, ""))
+	})
+	t.Run("unknown key is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "unknown"))
+	})
+	t.Run("scopes are enforced", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodGet, "reader"))
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "reader"))
+		assert.Equal(t, http.StatusOK, serve(http.MethodPost, "writer"))
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "writer"))
+	})
+}
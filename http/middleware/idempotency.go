@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/pkg/rest"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	idempotencyReplayed  = "Idempotent-Replayed"
+)
+
+const (
+	recordPending   = "pending"
+	recordCompleted = "completed"
+)
+
+type idempotencyRecord struct {
+	State       string      `json:"state"`
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+type IdempotencyOptions struct {
+	// Store keeps the records, Client is used through a redis store when it
+	// is nil.
+	Store  IdempotencyStore
+	Client redis.UniversalClient
+	// TTL is how long a completed response is kept for replays.
+	TTL time.Duration
+	// LockTTL bounds how long an in-flight request holds its key, in case the
+	// replica processing it dies.
+	LockTTL time.Duration
+	// Wait is how long a concurrent duplicate waits for the first request to
+	// finish, a zero Wait answers 409 right away.
+	Wait time.Duration
+	// Scope separates the keys of different clients.
+	Scope        KeyFunc
+	MaxBodyBytes int64
+	Logger       *zap.Logger
+}
+
+// Idempotency replays the stored response of a mutation sent again with the
+// same Idempotency-Key header. Reusing a key with another payload is answered
+// with 422, a duplicate of a request still in flight waits for it or gets 409.
+// Server errors are not stored so the client can retry them.
+func Idempotency(opts IdempotencyOptions) func(next http.Handler) http.Handler {
+	if opts.Store == nil {
+		opts.Store = NewRedisIdempotencyStore(opts.Client)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.LockTTL == 0 {
+		opts.LockTTL = 30 * time.Second
+	}
+	if opts.Scope == nil {
+		opts.Scope = KeyByPrincipal
+	}
+	if opts.MaxBodyBytes == 0 {
+		opts.MaxBodyBytes = 1 << 20
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get(IdempotencyKeyHeader)
+			if idemKey == "" || isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, opts.MaxBodyBytes+1))
+			if err != nil {
+				writeError(w, rest.NewBadRequest("could not read the body"))
+				return
+			}
+			if int64(len(body)) > opts.MaxBodyBytes {
+				writeError(w, rest.NewRequestEntityTooLarge())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			key := "idempotency:" + opts.Scope(r) + ":" + idemKey
+			fingerprint := fingerprintRequest(r, body)
+
+			pending, _ := json.Marshal(idempotencyRecord{State: recordPending, Fingerprint: fingerprint})
+			acquired, err := opts.Store.SetNX(r.Context(), key, pending, opts.LockTTL)
+			if err != nil {
+				opts.Logger.Error("could not acquire the idempotency key", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !acquired {
+				replayIdempotent(w, r, opts, key, fingerprint)
+				return
+			}
+
+			cw := &captureWriter{ResponseWriter: w}
+			finished := false
+			defer func() {
+				// the key is released with a fresh context, the request one
+				// may be canceled already
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if !finished || cw.status >= http.StatusInternalServerError {
+					if err := opts.Store.Del(ctx, key); err != nil {
+						opts.Logger.Error("could not release the idempotency key", zap.Error(err))
+					}
+					return
+				}
+				completed, _ := json.Marshal(idempotencyRecord{
+					State:       recordCompleted,
+					Fingerprint: fingerprint,
+					Status:      cw.status,
+					Header:      cw.header,
+					Body:        cw.body.Bytes(),
+				})
+				if err := opts.Store.Set(ctx, key, completed, opts.TTL); err != nil {
+					opts.Logger.Error("could not store the idempotent response", zap.Error(err))
+				}
+			}()
+			next.ServeHTTP(cw, r)
+			if cw.status == 0 {
+				cw.WriteHeader(http.StatusOK)
+			}
+			finished = true
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, opts IdempotencyOptions, key, fingerprint string) {
+	deadline := time.Now().Add(opts.Wait)
+	for {
+		record, err := loadIdempotencyRecord(r.Context(), opts.Store, key)
+		if err != nil && !errors.Is(err, ErrIdempotencyRecordNotFound) {
+			opts.Logger.Error("could not load the idempotency key", zap.Error(err))
+			writeError(w, rest.NewInternalServerError())
+			return
+		}
+		// a missing record means the first request failed and released it
+		if errors.Is(err, ErrIdempotencyRecordNotFound) {
+			writeError(w, rest.NewConflict("the request with this idempotency key failed, retry it"))
+			return
+		}
+		if record.Fingerprint != fingerprint {
+			writeError(w, rest.NewUnprocessableEntity("idempotency key was used with another request"))
+			return
+		}
+		if record.State == recordCompleted {
+			// headers set by earlier middlewares, such as the rate limit
+			// ones, describe this request and are kept
+			for name, values := range record.Header {
+				if _, ok := w.Header()[name]; !ok {
+					w.Header()[name] = values
+				}
+			}
+			w.Header().Set(idempotencyReplayed, "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+		if !time.Now().Before(deadline) {
+			writeError(w, rest.NewConflict("a request with this idempotency key is in progress"))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func loadIdempotencyRecord(ctx context.Context, store IdempotencyStore, key string) (*idempotencyRecord, error) {
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func fingerprintRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, " ")
+	io.WriteString(h, r.URL.Path)
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureWriter keeps a copy of the response while writing it through.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	cw.header = cw.ResponseWriter.Header().Clone()
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v9"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyRecordNotFound is returned by IdempotencyStore.Get for the
+// keys that are not stored or expired.
+var ErrIdempotencyRecordNotFound = errors.New("idempotency record not found")
+
+// IdempotencyStore keeps the idempotency records with an expiry.
+type IdempotencyStore interface {
+	// SetNX stores value only if key is not stored yet and tells whether it
+	// did.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Del(ctx context.Context, key string) error
+}
+
+type redisIdempotencyStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisIdempotencyStore shares the records between the replicas.
+func NewRedisIdempotencyStore(client redis.UniversalClient) IdempotencyStore {
+	return &redisIdempotencyStore{client: client}
+}
+
+func (s *redisIdempotencyStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *redisIdempotencyStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *redisIdempotencyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrIdempotencyRecordNotFound
+	}
+	return value, err
+}
+
+func (s *redisIdempotencyStore) Del(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+type localRecord struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type localIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]localRecord
+	now     func() time.Time
+	sweeps  int
+}
+
+// NewLocalIdempotencyStore keeps the records in the process, a duplicate sent
+// to another replica is not recognized.
+func NewLocalIdempotencyStore() IdempotencyStore {
+	return &localIdempotencyStore{records: make(map[string]localRecord), now: time.Now}
+}
+
+func (s *localIdempotencyStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if record, ok := s.records[key]; ok && now.Before(record.expiresAt) {
+		return false, nil
+	}
+	s.put(now, key, value, ttl)
+	return true, nil
+}
+
+func (s *localIdempotencyStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(s.now(), key, value, ttl)
+	return nil
+}
+
+func (s *localIdempotencyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	if !ok || !s.now().Before(record.expiresAt) {
+		return nil, ErrIdempotencyRecordNotFound
+	}
+	return record.value, nil
+}
+
+func (s *localIdempotencyStore) Del(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// put stores a copy of value and drops the expired records every 1000
+// writes, the caller holds the lock.
+func (s *localIdempotencyStore) put(now time.Time, key string, value []byte, ttl time.Duration) {
+	s.records[key] = localRecord{value: append([]byte(nil), value...), expiresAt: now.Add(ttl)}
+	s.sweeps++
+	if s.sweeps < 1000 {
+		return
+	}
+	s.sweeps = 0
+	for k, record := range s.records {
+		if !now.Before(record.expiresAt) {
+			delete(s.records, k)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLocalIdempotencyStore(t *testing.T) {
+	now := time.Now()
+	store := NewLocalIdempotencyStore().(*localIdempotencyStore)
+	store.now = func() time.Time { return now }
+	ctx := context.TODO()
+
+	acquired, err := store.SetNX(ctx, "key", []byte("pending"), time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.SetNX(ctx, "key", []byte("other"), time.Second)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.NoError(t, store.Set(ctx, "key", []byte("completed"), time.Minute))
+	value, err := store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", string(value))
+
+	now = now.Add(time.Minute)
+	_, err = store.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrIdempotencyRecordNotFound)
+	acquired, err = store.SetNX(ctx, "key", []byte("pending"), time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.NoError(t, store.Del(ctx, "key"))
+	_, err = store.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrIdempotencyRecordNotFound)
+}
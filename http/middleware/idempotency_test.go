@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	var calls atomic.Int32
+	handler := Idempotency(IdempotencyOptions{Client: client, Wait: time.Second})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(body), "fail") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		}))
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/products", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+	t.Run("replays the stored response", func(t *testing.T) {
+		first := send("key-1", `{"name": "watch"}`)
+		assert.Equal(t, http.StatusCreated, first.Code)
+		second := send("key-1", `{"name": "watch"}`)
+		assert.Equal(t, http.StatusCreated, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "true", second.Header().Get(idempotencyReplayed))
+		assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+		assert.EqualValues(t, 1, calls.Load())
+	})
+	t.Run("rejects another payload with 422", func(t *testing.T) {
+		res := send("key-1", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+	t.Run("server errors are not stored", func(t *testing.T) {
+		assert.Equal(t, http.StatusInternalServerError, send("key-2", `"fail"`).Code)
+		assert.Equal(t, http.StatusInternalServerError, send("key-2", `"fail"`).Code)
+		assert.EqualValues(t, 3, calls.Load())
+	})
+	t.Run("requests without a key pass through", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("", `{}`).Code)
+		assert.Equal(t, http.StatusCreated, send("", `{}`).Code)
+		assert.EqualValues(t, 5, calls.Load())
+	})
+	t.Run("duplicates of an in flight request wait for it", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/products", strings.NewReader(`{}`))
+		fingerprint := fingerprintRequest(req, []byte(`{}`))
+		key := "idempotency:" + KeyByPrincipal(req) + ":key-3"
+		pending, _ := json.Marshal(idempotencyRecord{State: recordPending, Fingerprint: fingerprint})
+		mr.Set(key, string(pending))
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			completed, _ := json.Marshal(idempotencyRecord{State: recordCompleted, Fingerprint: fingerprint, Status: http.StatusCreated, Body: []byte(`{}`)})
+			mr.Set(key, string(completed))
+		}()
+		res := send("key-3", `{}`)
+		assert.Equal(t, http.StatusCreated, res.Code)
+		assert.Equal(t, "true", res.Header().Get(idempotencyReplayed))
+	})
+	t.Run("duplicates of a stuck request get 409", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/products", strings.NewReader(`{}`))
+		pending, _ := json.Marshal(idempotencyRecord{State: recordPending, Fingerprint: fingerprintRequest(req, []byte(`{}`))})
+		mr.Set("idempotency:"+KeyByPrincipal(req)+":key-4", string(pending))
+		assert.Equal(t, http.StatusConflict, send("key-4", `{}`).Code)
+	})
+}
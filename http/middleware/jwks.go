@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound       = errors.New("signing key not found")
+	ErrUnsupportedKey    = errors.New("unsupported key type")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match the key")
+)
+
+// key is a verification key resolved from a JWKS entry together with the
+// only algorithm it is allowed to verify.
+type key struct {
+	alg    string
+	public any
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet holds the keys of a local JWKS file. Keys can be reloaded at
+// runtime, a failed reload keeps the previously loaded keys.
+type KeySet struct {
+	mu      sync.RWMutex
+	path    string
+	keys    map[string]key
+	modTime time.Time
+	log     *zap.Logger
+}
+
+// NewKeySet loads the JWKS file at path. An empty path results in an empty
+// set which rejects every token.
+func NewKeySet(path string, log *zap.Logger) (*KeySet, error) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	ks := &KeySet{path: path, keys: make(map[string]key), log: log}
+	if path == "" {
+		return ks, nil
+	}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload reads and parses the JWKS file again.
+func (ks *KeySet) Reload() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+// Watch polls the JWKS file every interval and reloads it when it changes,
+// until ctx is done.
+func (ks *KeySet) Watch(ctx context.Context, interval time.Duration) {
+	if ks.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(ks.path)
+			if err != nil {
+				ks.log.Error("could not stat the jwks file", zap.String("path", ks.path), zap.Error(err))
+				continue
+			}
+			ks.mu.RLock()
+			changed := !info.ModTime().Equal(ks.modTime)
+			ks.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := ks.Reload(); err != nil {
+				ks.log.Error("could not reload the jwks file", zap.String("path", ks.path), zap.Error(err))
+				continue
+			}
+			ks.log.Info("reloaded the jwks file", zap.String("path", ks.path))
+		}
+	}
+}
+
+// lookup returns the key for kid. When the token has no kid, the set must
+// contain exactly one key.
+func (ks *KeySet) lookup(kid, alg string) (any, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		for _, only := range ks.keys {
+			k, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if k.alg != alg {
+		return nil, ErrAlgorithmMismatch
+	}
+	return k.public, nil
+}
+
+func parseJWKS(data []byte) (map[string]key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]key, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		parsed, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = parsed
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (key, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return key{}, err
+		}
+		return key{alg: "HS256", public: secret}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return key{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return key{}, err
+		}
+		return key{alg: "RS256", public: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return key{}, ErrUnsupportedKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return key{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return key{}, err
+		}
+		return key{alg: "ES256", public: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	}
+	return key{}, ErrUnsupportedKey
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/halilylm/microservice/pkg/rest"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingToken  = errors.New("missing bearer token")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTokenExpired  = errors.New("token is expired")
+	ErrTokenNotValid = errors.New("token is not valid yet")
+	ErrInvalidIssuer = errors.New("invalid token issuer")
+	ErrInvalidAud    = errors.New("invalid token audience")
+)
+
+type claimsCtxKey struct{}
+
+// Claims are the verified claims of a bearer token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+type JWTOptions struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	// Leeway is the allowed clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTAuth verifies the bearer token of the request and puts its claims on
+// the request context. Safe methods are allowed without a token, every other
+// method requires one unless an earlier middleware already authenticated the
+// request.
+func JWTAuth(opts JWTOptions) func(next http.Handler) http.Handler {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithoutClaimsValidation(),
+	)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := bearerToken(r)
+			if !ok {
+				if _, authenticated := auth.FromContext(r.Context()); authenticated || isSafeMethod(r.Method) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				writeError(w, rest.NewUnauthorized(ErrMissingToken.Error()))
+				return
+			}
+			claims, err := verifyToken(parser, opts, raw)
+			if err != nil {
+				writeError(w, rest.NewUnauthorized(err.Error()))
+				return
+			}
+			ctx := context.WithValue(r.Context(), claimsCtxKey{}, claims)
+			ctx = auth.NewContext(ctx, &auth.Principal{
+				Subject: claims.Subject,
+				Method:  auth.MethodJWT,
+				Scopes:  strings.Fields(claims.Scope),
+				Roles:   claims.Roles,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClaimsFromContext returns the claims stored by JWTAuth.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsCtxKey{}).(*Claims)
+	return claims, ok
+}
+
+func verifyToken(parser *jwt.Parser, opts JWTOptions, raw string) (*Claims, error) {
+	var claims Claims
+	_, err := parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return opts.Keys.lookup(kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	if claims.ExpiresAt == nil || !claims.VerifyExpiresAt(now.Add(-opts.Leeway), true) {
+		return nil, ErrTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(opts.Leeway), false) {
+		return nil, ErrTokenNotValid
+	}
+	if opts.Issuer != "" && !claims.VerifyIssuer(opts.Issuer, true) {
+		return nil, ErrInvalidIssuer
+	}
+	if opts.Audience != "" && !claims.VerifyAudience(opts.Audience, true) {
+		return nil, ErrInvalidAud
+	}
+	return &claims, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", true
+	}
+	return strings.TrimSpace(token), true
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, err *rest.HTTPError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Code)
+	json.NewEncoder(w).Encode(err)
+}
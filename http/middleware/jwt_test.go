@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var hmacSecret = []byte("super-secret-signing-key")
+
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func octJWK(kid string, secret []byte) map[string]string {
+	return map[string]string{"kty": "oct", "kid": kid, "k": base64.RawURLEncoding.EncodeToString(secret)}
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.Bytes()),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() Claims {
+	return Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    "catalog",
+		Audience:  jwt.ClaimStrings{"products"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+	}}
+}
+
+func serveWithAuth(t *testing.T, keys *KeySet, method, token string) (*httptest.ResponseRecorder, *Claims) {
+	t.Helper()
+	var claims *Claims
+	handler := JWTAuth(JWTOptions{Keys: keys, Issuer: "catalog", Audience: "products"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = ClaimsFromContext(r.Context())
+		}))
+	req := httptest.NewRequest(method, "/api/v1/products", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res, claims
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(writeJWKS(t,
+		octJWK("hs", hmacSecret),
+		rsaJWK("rs", &rsaKey.PublicKey),
+		ecJWK("es", &ecKey.PublicKey),
+	), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("get is public", func(t *testing.T) {
+		res, claims := serveWithAuth(t, keys, http.MethodGet, "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Nil(t, claims)
+	})
+	t.Run("post requires a token", func(t *testing.T) {
+		res, _ := serveWithAuth(t, keys, http.MethodPost, "")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	})
+	t.Run("accepts hs256, rs256 and es256 tokens", func(t *testing.T) {
+		tokens := []string{
+			signToken(t, jwt.SigningMethodHS256, "hs", hmacSecret, validClaims()),
+			signToken(t, jwt.SigningMethodRS256, "rs", rsaKey, validClaims()),
+			signToken(t, jwt.SigningMethodES256, "es", ecKey, validClaims()),
+		}
+		for _, token := range tokens {
+			res, claims := serveWithAuth(t, keys, http.MethodDelete, token)
+			assert.Equal(t, http.StatusOK, res.Code)
+			if assert.NotNil(t, claims) {
+				assert.Equal(t, "user-1", claims.Subject)
+			}
+		}
+	})
+	t.Run("rejects a key used with another algorithm", func(t *testing.T) {
+		token := signToken(t, jwt.SigningMethodHS256, "rs", hmacSecret, validClaims())
+		res, _ := serveWithAuth(t, keys, http.MethodPost, token)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		claims := validClaims()
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		res, _ := serveWithAuth(t, keys, http.MethodPost, signToken(t, jwt.SigningMethodHS256, "hs", hmacSecret, claims))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+	t.Run("rejects tokens not valid yet", func(t *testing.T) {
+		claims := validClaims()
+		claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		res, _ := serveWithAuth(t, keys, http.MethodPost, signToken(t, jwt.SigningMethodHS256, "hs", hmacSecret, claims))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+	t.Run("rejects wrong issuer and audience", func(t *testing.T) {
+		claims := validClaims()
+		claims.Issuer = "someone-else"
+		res, _ := serveWithAuth(t, keys, http.MethodPost, signToken(t, jwt.SigningMethodHS256, "hs", hmacSecret, claims))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		claims = validClaims()
+		claims.Audience = jwt.ClaimStrings{"orders"}
+		res, _ = serveWithAuth(t, keys, http.MethodPost, signToken(t, jwt.SigningMethodHS256, "hs", hmacSecret, claims))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+	t.Run("rejects invalid tokens on get", func(t *testing.T) {
+		res, _ := serveWithAuth(t, keys, http.MethodGet, "not-a-token")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestKeySet_Reload(t *testing.T) {
+	path := writeJWKS(t, octJWK("old", hmacSecret))
+	keys, err := NewKeySet(path, nil)
+	assert.NoError(t, err)
+	token := signToken(t, jwt.SigningMethodHS256, "new", []byte("rotated"), validClaims())
+	res, _ := serveWithAuth(t, keys, http.MethodPost, token)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	data, _ := json.Marshal(map[string]any{"keys": []map[string]string{octJWK("new", []byte("rotated"))}})
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.NoError(t, keys.Reload())
+	res, _ = serveWithAuth(t, keys, http.MethodPost, token)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
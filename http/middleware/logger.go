@@ -1,30 +1,76 @@
 package middleware
 
 import (
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/halilylm/microservice/pkg/logctx"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
-	"time"
+	"strings"
 )
 
-func RequestLogger(l *zap.Logger) func(next http.Handler) http.Handler {
+// ContextLogger puts a logger in the request context carrying the request
+// id, the client ip, the W3C trace context and the route pattern, for
+// logctx.From. It goes after middleware.RequestID and middleware.RealIP.
+func ContextLogger(l *zap.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
-			start := time.Now()
-			defer func() {
-				l.Info("request",
-					zap.String("proto", r.Proto),
-					zap.String("path", r.URL.Path),
-					zap.Duration("latency", time.Since(start)),
-					zap.String("method", r.Method),
-					zap.Int("status", ww.Status()),
-					zap.Int("size", ww.BytesWritten()),
-					zap.String("ip", r.RemoteAddr),
-					zap.String("reqId", middleware.GetReqID(r.Context())))
-			}()
-			next.ServeHTTP(ww, r)
+			ctx := r.Context()
+			fields := []zap.Field{
+				zap.String("http.request.id", middleware.GetReqID(ctx)),
+				zap.String("client.ip", clientIP(r)),
+			}
+			if traceID, spanID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+				fields = append(fields, zap.String("trace.id", traceID), zap.String("span.id", spanID))
+			}
+			ctx = logctx.With(ctx, l.With(fields...))
+			if rctx := chi.RouteContext(ctx); rctx != nil {
+				// the pattern is complete once the router reached the handler
+				ctx = logctx.WithFieldFunc(ctx, func() zap.Field {
+					if pattern := rctx.RoutePattern(); pattern != "" {
+						return zap.String("http.route", pattern)
+					}
+					return zap.Skip()
+				})
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(fn)
 	}
 }
+
+// clientIP is the address set by middleware.RealIP, without the port of the
+// connection.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// parseTraceparent returns the trace and parent ids of a W3C traceparent
+// header, version-trace id-parent id-flags.
+func parseTraceparent(h string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if !isLowerHex(parts[0]) || !isLowerHex(parts[1]) || !isLowerHex(parts[2]) {
+		return "", "", false
+	}
+	// all zeros is invalid
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContextLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(ContextLogger(zap.New(core)))
+	r.Route("/api", func(r chi.Router) {
+		r.Get("/products/{slug}", func(w http.ResponseWriter, r *http.Request) {
+			logctx.From(r.Context(), nil).Info("handling")
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/products/lemon", nil)
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.TakeAll()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, map[string]any{
+			"http.request.id": "req-1",
+			"client.ip":       "10.0.0.7",
+			"trace.id":        "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span.id":         "00f067aa0ba902b7",
+			"http.route":      "/api/products/{slug}",
+		}, entries[0].ContextMap())
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", spanID)
+	for _, h := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+	} {
+		_, _, ok := parseTraceparent(h)
+		assert.False(t, ok, h)
+	}
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/halilylm/microservice/pkg/rest"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limit allows Requests per Period for a single key.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult is the outcome of taking one request from a quota.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	// ResetAfter is the time until the quota is full again.
+	ResetAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (RateLimitResult, error)
+}
+
+// KeyFunc returns the identity a request is limited by.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP limits by the client address, it should run after middleware.RealIP.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// KeyByAPIKey limits by the api key of the request, other requests are limited
+// by their address.
+func KeyByAPIKey(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok && p.Method == auth.MethodAPIKey {
+		return p.Subject
+	}
+	return KeyByIP(r)
+}
+
+// KeyByPrincipal limits by the authenticated user or api key, anonymous
+// requests are limited by their address.
+func KeyByPrincipal(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.Method + ":" + p.Subject
+	}
+	return KeyByIP(r)
+}
+
+type RateLimitOptions struct {
+	Limiter Limiter
+	Key     KeyFunc
+	// Default applies to routes without a quota in Routes, a zero Default
+	// leaves them unlimited.
+	Default Limit
+	// Routes holds the quota of each route, keyed by the method and the full
+	// chi pattern such as "GET /api/v1/products/{slug}".
+	Routes map[string]Limit
+	Logger *zap.Logger
+}
+
+// RateLimit limits requests per route and key and reports the quota through
+// the X-RateLimit-* headers. Requests are let through when the limiter fails.
+func RateLimit(opts RateLimitOptions) func(next http.Handler) http.Handler {
+	if opts.Key == nil {
+		opts.Key = KeyByIP
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			route := routeOf(r)
+			limit, ok := opts.Routes[route]
+			if !ok {
+				limit = opts.Default
+			}
+			if limit.Requests <= 0 || limit.Period <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res, err := opts.Limiter.Allow(r.Context(), "ratelimit:"+route+":"+opts.Key(r), limit)
+			if err != nil {
+				opts.Logger.Error("could not check the rate limit", zap.String("route", route), zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				writeError(w, rest.NewTooManyRequests())
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// routeOf resolves the full route pattern of the request up front, so that
+// quotas can be picked per route before the router reaches the handler.
+// Unmatched requests share a single route to keep the number of keys bounded.
+func routeOf(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return r.Method + " *"
+	}
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, r.URL.Path) {
+		return r.Method + " *"
+	}
+	return r.Method + " " + tctx.RoutePattern()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
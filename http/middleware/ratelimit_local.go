@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type localLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	sweeps  int
+}
+
+// NewLocalLimiter returns an in-process token bucket limiter. Quotas are per
+// process, so the effective limit grows with the number of replicas.
+func NewLocalLimiter() Limiter {
+	return &localLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (l *localLimiter) Allow(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	capacity := float64(limit.Requests)
+	rate := capacity / float64(limit.Period)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+		l.sweep(now, limit.Period)
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+	res.Remaining = int(b.tokens)
+	res.ResetAfter = time.Duration(math.Ceil((capacity - b.tokens) / rate))
+	return res, nil
+}
+
+// sweep drops buckets idle for longer than period every 1000 new keys, an
+// idle bucket is full again and equal to a missing one.
+func (l *localLimiter) sweep(now time.Time, period time.Duration) {
+	l.sweeps++
+	if l.sweeps < 1000 {
+		return
+	}
+	l.sweeps = 0
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type fallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+	logger   *zap.Logger
+	degraded atomic.Bool
+}
+
+// NewFallbackLimiter uses fallback while primary fails, typically a local
+// limiter while redis is down.
+func NewFallbackLimiter(primary, fallback Limiter, logger *zap.Logger) Limiter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &fallbackLimiter{primary: primary, fallback: fallback, logger: logger}
+}
+
+func (l *fallbackLimiter) Allow(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	res, err := l.primary.Allow(ctx, key, limit)
+	if err == nil {
+		if l.degraded.CompareAndSwap(true, false) {
+			l.logger.Info("rate limiter recovered")
+		}
+		return res, nil
+	}
+	if l.degraded.CompareAndSwap(false, true) {
+		l.logger.Warn("rate limiter failed, using the fallback limiter", zap.Error(err))
+	}
+	return l.fallback.Allow(ctx, key, limit)
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v9"
+	"github.com/google/uuid"
+	"time"
+)
+
+type Algorithm int
+
+const (
+	TokenBucket Algorithm = iota
+	SlidingWindow
+)
+
+// tokenBucketScript refills the bucket for the elapsed time and takes one
+// token from it. Tokens are stored as a float so slow refill rates work.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local rate = capacity / period
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+// slidingWindowScript keeps a log of the requests of the last period and
+// admits a request while the log is shorter than the limit.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return {1, limit - count - 1, 0, tonumber(oldest[2]) + window - now}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local retry = tonumber(oldest[2]) + window - now
+return {0, 0, retry, retry}
+`)
+
+var errUnexpectedReply = errors.New("unexpected rate limit script reply")
+
+type redisLimiter struct {
+	client    redis.UniversalClient
+	algorithm Algorithm
+}
+
+// NewRedisLimiter returns a Limiter shared by every replica. Each check is a
+// single atomic script.
+func NewRedisLimiter(client redis.UniversalClient, algorithm Algorithm) Limiter {
+	return &redisLimiter{client: client, algorithm: algorithm}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	period := limit.Period.Milliseconds()
+	var cmd *redis.Cmd
+	switch l.algorithm {
+	case SlidingWindow:
+		cmd = slidingWindowScript.Run(ctx, l.client, []string{key}, limit.Requests, period, now, uuid.NewString())
+	default:
+		cmd = tokenBucketScript.Run(ctx, l.client, []string{key}, limit.Requests, period, now)
+	}
+	reply, err := cmd.Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(reply) != 4 {
+		return RateLimitResult{}, errUnexpectedReply
+	}
+	return RateLimitResult{
+		Allowed:    reply[0] == 1,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		ResetAfter: time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRateLimitedRouter(limiter Limiter) chi.Router {
+	r := chi.NewRouter()
+	r.Use(RateLimit(RateLimitOptions{
+		Limiter: limiter,
+		Default: Limit{Requests: 100, Period: time.Minute},
+		Routes: map[string]Limit{
+			"GET /products/{slug}": {Requests: 2, Period: time.Minute},
+		},
+	}))
+	r.Get("/products/{slug}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Post("/products", func(w http.ResponseWriter, r *http.Request) {})
+	return r
+}
+
+func doRequest(r http.Handler, method, target, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = ip + ":1234"
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestRateLimit(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	limiters := map[string]Limiter{
+		"token bucket":   NewRedisLimiter(client, TokenBucket),
+		"sliding window": NewRedisLimiter(client, SlidingWindow),
+		"local":          NewLocalLimiter(),
+	}
+	for name, limiter := range limiters {
+		mr.FlushAll()
+		t.Run(name, func(t *testing.T) {
+			r := newRateLimitedRouter(limiter)
+			res := doRequest(r, http.MethodGet, "/products/watch", "10.0.0.1")
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, "2", res.Header().Get("X-RateLimit-Limit"))
+			assert.Equal(t, "1", res.Header().Get("X-RateLimit-Remaining"))
+			// the quota is per route, not per path
+			assert.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/products/book", "10.0.0.1").Code)
+			res = doRequest(r, http.MethodGet, "/products/lamp", "10.0.0.1")
+			assert.Equal(t, http.StatusTooManyRequests, res.Code)
+			assert.NotEmpty(t, res.Header().Get("Retry-After"))
+			assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+			// other clients and routes have their own quota
+			assert.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/products/watch", "10.0.0.2").Code)
+			res = doRequest(r, http.MethodPost, "/products", "10.0.0.1")
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, "100", res.Header().Get("X-RateLimit-Limit"))
+		})
+	}
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	r := newRateLimitedRouter(NewFallbackLimiter(NewRedisLimiter(client, TokenBucket), NewLocalLimiter(), nil))
+	mr.Close()
+	assert.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/products/watch", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/products/watch", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(r, http.MethodGet, "/products/watch", "10.0.0.1").Code)
+}
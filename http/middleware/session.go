@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"github.com/halilylm/microservice/pkg/database"
+	"net/http"
+)
+
+// DBSession gives every request its own database session, so the reads that
+// follow a write of the request go to the primary.
+func DBSession(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(database.NewSession(r.Context())))
+	}
+	return http.HandlerFunc(fn)
+}
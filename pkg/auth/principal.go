@@ -0,0 +1,37 @@
+package auth
+
+import "context"
+
+const (
+	MethodJWT    = "jwt"
+	MethodAPIKey = "api_key"
+)
+
+type principalCtxKey struct{}
+
+// Principal is the authenticated caller of a request, regardless of how it
+// authenticated.
+type Principal struct {
+	Subject string
+	Method  string
+	Scopes  []string
+	Roles   []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,276 @@
+// Package breaker implements a circuit breaker that stops calling a failing
+// dependency for a while so requests fail fast instead of piling up.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// StateClosed lets every call through and tracks the failure rate.
+	StateClosed State = iota
+	// StateOpen rejects every call until the open timeout elapses.
+	StateOpen
+	// StateHalfOpen lets a few probe calls through, they decide whether the
+	// breaker closes or opens again.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ErrOpen is returned without calling the dependency while the breaker is
+// open, or half-open with all its probes in flight.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// metrics publishes the state of every breaker under /debug/vars.
+var metrics = expvar.NewMap("circuit_breakers")
+
+type Options struct {
+	Name string
+	// Window is the period the failure rate is computed over, 10 seconds
+	// by default. It is split in Buckets, 10 by default.
+	Window  time.Duration
+	Buckets int
+	// MinRequests is how many calls the window needs before the breaker
+	// can open, 10 by default.
+	MinRequests int
+	// FailureRate opens the breaker when reached, 0.5 by default.
+	FailureRate float64
+	// OpenTimeout is how long the breaker stays open, 5 seconds by default.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is how many probe calls have to succeed to close the
+	// breaker, 1 by default.
+	HalfOpenRequests int
+	// IsFailure decides which errors count against the dependency, by
+	// default every error but a canceled context.
+	IsFailure func(err error) bool
+	// OnStateChange is called without holding the breaker lock.
+	OnStateChange func(name string, from, to State)
+}
+
+type bucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+type Breaker struct {
+	opts Options
+	now  func() time.Time
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	buckets    []bucket
+	openedAt   time.Time
+	probes     int
+	probeOK    int
+}
+
+func New(opts Options) *Breaker {
+	if opts.Window == 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.Buckets == 0 {
+		opts.Buckets = 10
+	}
+	if opts.MinRequests == 0 {
+		opts.MinRequests = 10
+	}
+	if opts.FailureRate == 0 {
+		opts.FailureRate = 0.5
+	}
+	if opts.OpenTimeout == 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+	if opts.HalfOpenRequests == 0 {
+		opts.HalfOpenRequests = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+	b := &Breaker{opts: opts, now: time.Now, buckets: make([]bucket, opts.Buckets)}
+	if opts.Name != "" {
+		metrics.Set(opts.Name, expvar.Func(func() any {
+			return b.Snapshot()
+		}))
+	}
+	return b
+}
+
+func (b *Breaker) Name() string {
+	return b.opts.Name
+}
+
+// State returns the current state, an open breaker whose timeout elapsed is
+// reported as half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && !b.now().Before(b.openedAt.Add(b.opts.OpenTimeout)) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+type Snapshot struct {
+	State     string `json:"state"`
+	Requests  int    `json:"requests"`
+	Failures  int    `json:"failures"`
+	OpenedAt  string `json:"opened_at,omitempty"`
+	OpenUntil string `json:"open_until,omitempty"`
+}
+
+// Snapshot describes the breaker for metrics.
+func (b *Breaker) Snapshot() Snapshot {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Snapshot{State: state.String()}
+	s.Requests, s.Failures = b.counts()
+	if b.state == StateOpen {
+		s.OpenedAt = b.openedAt.UTC().Format(time.RFC3339)
+		s.OpenUntil = b.openedAt.Add(b.opts.OpenTimeout).UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// Do calls fn unless the breaker is open and records its outcome. The error
+// of fn is returned as is.
+func (b *Breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	generation, err := b.before()
+	if err != nil {
+		return err
+	}
+	err = fn(ctx)
+	b.after(generation, !b.opts.IsFailure(err))
+	return err
+}
+
+func (b *Breaker) before() (uint64, error) {
+	b.mu.Lock()
+	var changed func()
+	defer func() {
+		b.mu.Unlock()
+		if changed != nil {
+			changed()
+		}
+	}()
+	switch b.state {
+	case StateOpen:
+		if b.now().Before(b.openedAt.Add(b.opts.OpenTimeout)) {
+			return 0, ErrOpen
+		}
+		changed = b.setState(StateHalfOpen)
+	case StateHalfOpen:
+		if b.probes >= b.opts.HalfOpenRequests {
+			return 0, ErrOpen
+		}
+	}
+	if b.state == StateHalfOpen {
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+func (b *Breaker) after(generation uint64, success bool) {
+	b.mu.Lock()
+	var changed func()
+	defer func() {
+		b.mu.Unlock()
+		if changed != nil {
+			changed()
+		}
+	}()
+	// the state changed while the call was in flight, its outcome belongs
+	// to a previous period
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		cur := b.bucket()
+		if success {
+			cur.successes++
+			return
+		}
+		cur.failures++
+		requests, failures := b.counts()
+		if requests >= b.opts.MinRequests && float64(failures)/float64(requests) >= b.opts.FailureRate {
+			changed = b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		if !success {
+			changed = b.setState(StateOpen)
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.opts.HalfOpenRequests {
+			changed = b.setState(StateClosed)
+		}
+	}
+}
+
+// bucket returns the bucket of now, resetting it if it belongs to an
+// earlier window.
+func (b *Breaker) bucket() *bucket {
+	size := b.opts.Window / time.Duration(b.opts.Buckets)
+	now := b.now()
+	start := now.Truncate(size)
+	cur := &b.buckets[int(start.UnixNano()/int64(size))%len(b.buckets)]
+	if !cur.start.Equal(start) {
+		*cur = bucket{start: start}
+	}
+	return cur
+}
+
+func (b *Breaker) counts() (requests, failures int) {
+	since := b.now().Add(-b.opts.Window)
+	for _, bk := range b.buckets {
+		if bk.start.After(since) {
+			requests += bk.successes + bk.failures
+			failures += bk.failures
+		}
+	}
+	return requests, failures
+}
+
+// setState must be called with the lock held, it returns the callback to run
+// once the lock is released.
+func (b *Breaker) setState(state State) func() {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes, b.probeOK = 0, 0
+	switch state {
+	case StateOpen:
+		b.openedAt = b.now()
+	case StateClosed:
+		b.buckets = make([]bucket, b.opts.Buckets)
+	}
+	if b.opts.OnStateChange == nil {
+		return nil
+	}
+	name, onChange := b.opts.Name, b.opts.OnStateChange
+	return func() {
+		onChange(name, from, state)
+	}
+}
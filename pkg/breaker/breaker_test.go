@@ -0,0 +1,97 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+var errDown = errors.New("connection refused")
+
+func newTestBreaker(opts Options) (*Breaker, *clock, *[]string) {
+	c := &clock{now: time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)}
+	var changes []string
+	opts.OnStateChange = func(name string, from, to State) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+	b := New(opts)
+	b.now = c.Now
+	return b, c, &changes
+}
+
+func call(b *Breaker, err error) error {
+	return b.Do(context.Background(), func(ctx context.Context) error {
+		return err
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	t.Run("opens once the failure rate is reached", func(t *testing.T) {
+		b, _, changes := newTestBreaker(Options{MinRequests: 4, FailureRate: 0.5})
+		assert.NoError(t, call(b, nil))
+		assert.NoError(t, call(b, nil))
+		assert.ErrorIs(t, call(b, errDown), errDown)
+		assert.Equal(t, StateClosed, b.State())
+		assert.ErrorIs(t, call(b, errDown), errDown)
+		assert.Equal(t, StateOpen, b.State())
+		assert.ErrorIs(t, call(b, nil), ErrOpen)
+		assert.Equal(t, []string{"closed->open"}, *changes)
+	})
+	t.Run("forgets failures outside the window", func(t *testing.T) {
+		b, c, _ := newTestBreaker(Options{MinRequests: 2, Window: time.Second, Buckets: 2})
+		assert.Error(t, call(b, errDown))
+		c.now = c.now.Add(2 * time.Second)
+		assert.NoError(t, call(b, nil))
+		assert.Equal(t, StateClosed, b.State())
+		s := b.Snapshot()
+		assert.Equal(t, 1, s.Requests)
+		assert.Equal(t, 0, s.Failures)
+	})
+	t.Run("closes after a successful probe", func(t *testing.T) {
+		b, c, changes := newTestBreaker(Options{MinRequests: 1, OpenTimeout: time.Second})
+		assert.Error(t, call(b, errDown))
+		c.now = c.now.Add(time.Second)
+		assert.Equal(t, StateHalfOpen, b.State())
+		assert.NoError(t, call(b, nil))
+		assert.Equal(t, StateClosed, b.State())
+		assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, *changes)
+	})
+	t.Run("opens again after a failed probe", func(t *testing.T) {
+		b, c, _ := newTestBreaker(Options{MinRequests: 1, OpenTimeout: time.Second})
+		assert.Error(t, call(b, errDown))
+		c.now = c.now.Add(time.Second)
+		assert.ErrorIs(t, call(b, errDown), errDown)
+		assert.Equal(t, StateOpen, b.State())
+		assert.ErrorIs(t, call(b, nil), ErrOpen)
+	})
+	t.Run("limits the probes in flight", func(t *testing.T) {
+		b, c, _ := newTestBreaker(Options{MinRequests: 1, OpenTimeout: time.Second})
+		assert.Error(t, call(b, errDown))
+		c.now = c.now.Add(time.Second)
+		err := b.Do(context.Background(), func(ctx context.Context) error {
+			return call(b, nil)
+		})
+		assert.ErrorIs(t, err, ErrOpen)
+	})
+	t.Run("does not count canceled calls", func(t *testing.T) {
+		b, _, _ := newTestBreaker(Options{MinRequests: 1})
+		assert.ErrorIs(t, call(b, context.Canceled), context.Canceled)
+		assert.Equal(t, StateClosed, b.State())
+	})
+}
+
+func TestBreaker_Metrics(t *testing.T) {
+	b, _, _ := newTestBreaker(Options{Name: "metrics-test", MinRequests: 1})
+	assert.Error(t, call(b, errDown))
+	assert.JSONEq(t, `{"state":"open","requests":1,"failures":1,"opened_at":"2022-12-01T10:00:00Z","open_until":"2022-12-01T10:00:05Z"}`, metrics.Get("metrics-test").String())
+}
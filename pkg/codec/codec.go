@@ -0,0 +1,172 @@
+package codec
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotAcceptable        = errors.New("none of the accepted media types is supported")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrUnsupportedType      = errors.New("value can not be encoded with this codec")
+)
+
+// Codec encodes and decodes bodies of a single media type.
+type Codec interface {
+	ContentType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// StrictDecoder is implemented by codecs that can reject unknown fields.
+type StrictDecoder interface {
+	DecodeStrict(r io.Reader, v any) error
+}
+
+// TypeChecker is implemented by codecs that only encode some types.
+type TypeChecker interface {
+	CanEncode(t reflect.Type) bool
+}
+
+// Registry picks codecs by the Accept and Content-Type headers.
+type Registry struct {
+	def Codec
+	// types keeps the registration order for wildcard ranges
+	types  []string
+	byType map[string]Codec
+}
+
+// NewRegistry creates a registry that falls back to def when a request
+// doesn't state a media type.
+func NewRegistry(def Codec) *Registry {
+	r := &Registry{def: def, byType: make(map[string]Codec)}
+	r.Register(def)
+	return r
+}
+
+// Register adds c under its content type and aliases.
+func (r *Registry) Register(c Codec, aliases ...string) {
+	for _, typ := range append([]string{c.ContentType()}, aliases...) {
+		r.types = append(r.types, typ)
+		r.byType[typ] = c
+	}
+}
+
+// Default is the codec used when nothing else is negotiated.
+func (r *Registry) Default() Codec {
+	return r.def
+}
+
+// ForContentType returns the codec to decode a body of contentType. An empty
+// content type is decoded with the default codec.
+func (r *Registry) ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return r.def, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+	c, ok := r.byType[mediaType]
+	if !ok {
+		return nil, ErrUnsupportedMediaType
+	}
+	return c, nil
+}
+
+type mediaRange struct {
+	typ     string
+	quality float64
+}
+
+// Negotiate returns the codec for the most preferred media range of an Accept
+// header.
+func (r *Registry) Negotiate(accept string) (Codec, error) {
+	return r.negotiate(accept, func(Codec) bool { return true })
+}
+
+// NegotiateFor is Negotiate skipping the codecs that can't encode t, so that
+// a less preferred media range is picked rather than none.
+func (r *Registry) NegotiateFor(accept string, t reflect.Type) (Codec, error) {
+	return r.negotiate(accept, func(c Codec) bool {
+		checker, ok := c.(TypeChecker)
+		return !ok || checker.CanEncode(t)
+	})
+}
+
+func (r *Registry) negotiate(accept string, canEncode func(Codec) bool) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	ranges := parseAccept(accept)
+	for _, mr := range ranges {
+		if mr.quality <= 0 {
+			continue
+		}
+		switch {
+		case mr.typ == "*/*":
+			if canEncode(r.def) {
+				return r.def, nil
+			}
+			for _, typ := range r.types {
+				if c := r.byType[typ]; canEncode(c) {
+					return c, nil
+				}
+			}
+		case strings.HasSuffix(mr.typ, "/*"):
+			prefix := strings.TrimSuffix(mr.typ, "*")
+			if strings.HasPrefix(r.def.ContentType(), prefix) && canEncode(r.def) {
+				return r.def, nil
+			}
+			for _, typ := range r.types {
+				if c := r.byType[typ]; strings.HasPrefix(typ, prefix) && canEncode(c) {
+					return c, nil
+				}
+			}
+		default:
+			if c, ok := r.byType[mr.typ]; ok && canEncode(c) {
+				return c, nil
+			}
+		}
+	}
+	return nil, ErrNotAcceptable
+}
+
+// parseAccept parses the media ranges of an Accept header ordered by their
+// quality, ranges with the same quality keep their order.
+func parseAccept(accept string) []mediaRange {
+	parts := strings.Split(accept, ",")
+	ranges := make([]mediaRange, 0, len(parts))
+	for _, part := range parts {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: mediaType, quality: quality})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	return ranges
+}
+
+// DefaultRegistry supports JSON, XML, MessagePack and protobuf with JSON as
+// the default.
+func DefaultRegistry() *Registry {
+	r := NewRegistry(JSON{})
+	r.Register(XML{}, "text/xml")
+	r.Register(MessagePack{}, "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(Protobuf{}, "application/protobuf", "application/vnd.google.protobuf")
+	return r
+}
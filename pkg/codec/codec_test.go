@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Negotiate(t *testing.T) {
+	r := DefaultRegistry()
+	cases := map[string]string{
+		"":                      "application/json",
+		"*/*":                   "application/json",
+		"application/*":         "application/json",
+		"text/*":                "application/xml",
+		"text/xml":              "application/xml",
+		"application/x-msgpack": "application/msgpack",
+		"application/xml;q=0.2, application/protobuf": "application/x-protobuf",
+		"text/csv, application/json;q=0.1":            "application/json",
+	}
+	for accept, want := range cases {
+		c, err := r.Negotiate(accept)
+		if assert.NoError(t, err, accept) {
+			assert.Equal(t, want, c.ContentType(), accept)
+		}
+	}
+	_, err := r.Negotiate("text/csv, application/json;q=0")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestRegistry_NegotiateFor(t *testing.T) {
+	r := DefaultRegistry()
+	list := reflect.TypeOf([]string{})
+	c, err := r.NegotiateFor("application/xml, application/json;q=0.5", list)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "application/json", c.ContentType())
+	}
+	c, err = r.NegotiateFor("text/*", reflect.TypeOf(struct{}{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "application/xml", c.ContentType())
+	}
+	_, err = r.NegotiateFor("text/xml", list)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestRegistry_ForContentType(t *testing.T) {
+	r := DefaultRegistry()
+	c, err := r.ForContentType("")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", c.ContentType())
+	c, err = r.ForContentType("application/json; charset=utf-8")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", c.ContentType())
+	_, err = r.ForContentType("text/plain")
+	assert.ErrorIs(t, err, ErrUnsupportedMediaType)
+}
+
+func TestProtobuf_UnsupportedType(t *testing.T) {
+	var b []byte
+	assert.ErrorIs(t, Protobuf{}.Decode(nil, &b), ErrUnsupportedType)
+}
+
+func TestXML_UnsupportedType(t *testing.T) {
+	var b strings.Builder
+	assert.ErrorIs(t, XML{}.Encode(&b, []string{"a", "b"}), ErrUnsupportedType)
+	assert.Empty(t, b.String())
+	assert.False(t, XML{}.CanEncode(reflect.TypeOf(&[]int{})))
+	assert.True(t, XML{}.CanEncode(reflect.TypeOf(&struct{}{})))
+}
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var errTrailingData = errors.New("unexpected data after the json value")
+
+type JSON struct{}
+
+func (JSON) ContentType() string {
+	return "application/json"
+}
+
+func (JSON) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSON) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// DecodeStrict rejects unknown fields and anything after the first value.
+func (JSON) DecodeStrict(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errTrailingData
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package codec
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+)
+
+// MessagePack reuses the json struct tags, so types don't need a second set
+// of tags.
+type MessagePack struct{}
+
+func (MessagePack) ContentType() string {
+	return "application/msgpack"
+}
+
+func (MessagePack) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+func (MessagePack) Decode(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (MessagePack) DecodeStrict(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
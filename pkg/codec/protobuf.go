@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"io"
+	"reflect"
+)
+
+var protoMarshalerType = reflect.TypeOf((*ProtoMarshaler)(nil)).Elem()
+
+// ProtoMarshaler is implemented by types with a protobuf wire representation.
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+// ProtoUnmarshaler is implemented by types that can be read from their
+// protobuf wire representation.
+type ProtoUnmarshaler interface {
+	UnmarshalProto(b []byte) error
+}
+
+// Protobuf encodes types that implement ProtoMarshaler and decodes types
+// that implement ProtoUnmarshaler, other types are not supported.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (Protobuf) CanEncode(t reflect.Type) bool {
+	return t.Implements(protoMarshalerType)
+}
+
+func (Protobuf) Encode(w io.Writer, v any) error {
+	m, ok := v.(ProtoMarshaler)
+	if !ok {
+		return ErrUnsupportedType
+	}
+	b, err := m.MarshalProto()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (Protobuf) Decode(r io.Reader, v any) error {
+	u, ok := v.(ProtoUnmarshaler)
+	if !ok {
+		return ErrUnsupportedType
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalProto(b)
+}
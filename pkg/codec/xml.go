@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+)
+
+// XML encodes single values. Slices and maps are not supported, a slice would
+// be written as one root element per item and a map can't be marshaled.
+type XML struct{}
+
+func (XML) ContentType() string {
+	return "application/xml"
+}
+
+func (XML) CanEncode(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return false
+	}
+	return true
+}
+
+func (x XML) Encode(w io.Writer, v any) error {
+	if v != nil && !x.CanEncode(reflect.TypeOf(v)) {
+		return ErrUnsupportedType
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func (XML) Decode(r io.Reader, v any) error {
+	return xml.NewDecoder(r).Decode(v)
+}
@@ -2,17 +2,25 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
-	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
+	"net"
+	"strconv"
 	"time"
 )
 
 type MysqlConn struct {
-	DB                    *sql.DB
+	// DB is the primary.
+	DB *sql.DB
+	// Router spreads the reads over the replicas, with no replica every
+	// query goes to DB.
+	Router                *Router
+	replicas              []Replica
 	host                  string
 	port                  int
+	replicaAddresses      []ReplicaOptions
 	user                  string
 	password              string
 	name                  string
@@ -20,9 +28,18 @@ type MysqlConn struct {
 	maxIdleConnections    int
 	connectionMaxLifetime time.Duration
 	connectionMaxIdleTime time.Duration
+	router                RouterOptions
+	tls                   *tls.Config
 	log                   *zap.Logger
 }
 
+// ReplicaOptions is the address of a read replica, it shares the credentials
+// and the pool settings of the primary.
+type ReplicaOptions struct {
+	Host string
+	Port int
+}
+
 type MysqlConnOptions struct {
 	Host                  string
 	Port                  int
@@ -33,17 +50,26 @@ type MysqlConnOptions struct {
 	MaxIdleConnections    int
 	ConnectionMaxLifetime time.Duration
 	ConnectionMaxIdleTime time.Duration
-	Log                   *zap.Logger
+	Replicas              []ReplicaOptions
+	Router                RouterOptions
+	// TLS encrypts the connections to the primary and the replicas when it
+	// is not nil, its ServerName defaults to the host of each of them.
+	TLS *tls.Config
+	Log *zap.Logger
 }
 
 func NewMysqlConn(opts MysqlConnOptions) (*MysqlConn, error) {
 	if opts.Log == nil {
 		opts.Log = zap.NewNop()
 	}
+	if opts.Router.Log == nil {
+		opts.Router.Log = opts.Log
+	}
 
 	db := &MysqlConn{
 		host:                  opts.Host,
 		port:                  opts.Port,
+		replicaAddresses:      opts.Replicas,
 		user:                  opts.User,
 		password:              opts.Password,
 		name:                  opts.Name,
@@ -51,6 +77,8 @@ func NewMysqlConn(opts MysqlConnOptions) (*MysqlConn, error) {
 		maxIdleConnections:    opts.MaxIdleConnections,
 		connectionMaxLifetime: opts.ConnectionMaxLifetime,
 		connectionMaxIdleTime: opts.ConnectionMaxIdleTime,
+		router:                opts.Router,
+		tls:                   opts.TLS,
 		log:                   opts.Log,
 	}
 	if err := db.Connect(); err != nil {
@@ -60,18 +88,63 @@ func NewMysqlConn(opts MysqlConnOptions) (*MysqlConn, error) {
 }
 
 func (sd *MysqlConn) Connect() error {
-	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", sd.user, sd.password, sd.host, sd.port, sd.name))
+	db, err := sd.open(sd.host, sd.port)
 	if err != nil {
 		return err
 	}
+	sd.DB = db
+	for _, addr := range sd.replicaAddresses {
+		replica, err := sd.open(addr.Host, addr.Port)
+		if err != nil {
+			return err
+		}
+		sd.replicas = append(sd.replicas, Replica{
+			Name: net.JoinHostPort(addr.Host, strconv.Itoa(addr.Port)),
+			DB:   replica,
+		})
+	}
+	sd.Router = NewRouter(sd.DB, sd.replicas, sd.router)
+	return nil
+}
+
+func (sd *MysqlConn) open(host string, port int) (*sql.DB, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = sd.user
+	cfg.Passwd = sd.password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	cfg.DBName = sd.name
+	cfg.ParseTime = true
+	// the updates of every repository report the rows they matched, an
+	// update that changes nothing is not mistaken for a missing row. The
+	// revocations and deletes only match the rows they change, so they
+	// still report the ones already done.
+	cfg.ClientFoundRows = true
+	cfg.TLS = sd.tls
+	// the connector clones the TLS config before setting its server name
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
 	db.SetConnMaxLifetime(sd.connectionMaxLifetime)
 	db.SetConnMaxIdleTime(sd.connectionMaxIdleTime)
 	db.SetMaxIdleConns(sd.maxIdleConnections)
 	db.SetConnMaxLifetime(sd.connectionMaxLifetime)
-	sd.DB = db
-	return nil
+	return db, nil
 }
 
+// Ping checks the primary, the replicas are checked by the router.
 func (sd *MysqlConn) Ping(ctx context.Context) error {
-	return sd.DB.Ping()
+	return sd.DB.PingContext(ctx)
+}
+
+func (sd *MysqlConn) Close() error {
+	err := sd.DB.Close()
+	for _, replica := range sd.replicas {
+		if rerr := replica.DB.Close(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
 }
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/lib/pq"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type PostgresConn struct {
+	DB                    *sql.DB
+	host                  string
+	port                  int
+	user                  string
+	password              string
+	name                  string
+	sslMode               string
+	maxOpenConnections    int
+	maxIdleConnections    int
+	connectionMaxLifetime time.Duration
+	connectionMaxIdleTime time.Duration
+}
+
+type PostgresConnOptions struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	// SSLMode is the libpq sslmode, disable by default.
+	SSLMode               string
+	MaxOpenConnections    int
+	MaxIdleConnections    int
+	ConnectionMaxLifetime time.Duration
+	ConnectionMaxIdleTime time.Duration
+}
+
+func NewPostgresConn(opts PostgresConnOptions) (*PostgresConn, error) {
+	if opts.SSLMode == "" {
+		opts.SSLMode = "disable"
+	}
+	db := &PostgresConn{
+		host:                  opts.Host,
+		port:                  opts.Port,
+		user:                  opts.User,
+		password:              opts.Password,
+		name:                  opts.Name,
+		sslMode:               opts.SSLMode,
+		maxOpenConnections:    opts.MaxOpenConnections,
+		maxIdleConnections:    opts.MaxIdleConnections,
+		connectionMaxLifetime: opts.ConnectionMaxLifetime,
+		connectionMaxIdleTime: opts.ConnectionMaxIdleTime,
+	}
+	if err := db.Connect(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (pc *PostgresConn) Connect() error {
+	db, err := sql.Open("postgres", pc.dsn())
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(pc.maxOpenConnections)
+	db.SetMaxIdleConns(pc.maxIdleConnections)
+	db.SetConnMaxLifetime(pc.connectionMaxLifetime)
+	db.SetConnMaxIdleTime(pc.connectionMaxIdleTime)
+	pc.DB = db
+	return nil
+}
+
+// dsn is a connection url, timestamptz values are sent and read in UTC
+// whatever the time zone of the server.
+func (pc *PostgresConn) dsn() string {
+	q := url.Values{}
+	q.Set("sslmode", pc.sslMode)
+	q.Set("timezone", "UTC")
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(pc.user, pc.password),
+		Host:     net.JoinHostPort(pc.host, strconv.Itoa(pc.port)),
+		Path:     "/" + pc.name,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+func (pc *PostgresConn) Ping(ctx context.Context) error {
+	return pc.DB.PingContext(ctx)
+}
+
+func (pc *PostgresConn) Close() error {
+	return pc.DB.Close()
+}
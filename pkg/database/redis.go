@@ -2,40 +2,144 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/go-redis/redis/v9"
 	"net"
 	"strconv"
+	"time"
 )
 
+// RedisMode is the topology of the redis deployment.
+type RedisMode int
+
+const (
+	// RedisStandalone talks to the single node at Host:Port.
+	RedisStandalone RedisMode = iota
+	// RedisSentinel asks the sentinels of Addrs for the master of
+	// MasterName and follows its failovers.
+	RedisSentinel
+	// RedisCluster discovers the cluster from the seed nodes of Addrs.
+	RedisCluster
+)
+
+func (m RedisMode) String() string {
+	switch m {
+	case RedisSentinel:
+		return "sentinel"
+	case RedisCluster:
+		return "cluster"
+	}
+	return "standalone"
+}
+
 type RedisConn struct {
-	Client   *redis.Client
+	Client   redis.UniversalClient
+	mode     RedisMode
 	address  string
 	password string
 	db       int
 }
 
 type RedisOptions struct {
-	Host     string
-	Port     int
-	Password string
-	DB       int
+	Mode RedisMode
+	// Host and Port address the standalone node.
+	Host string
+	Port int
+	// Addrs are the sentinels or the cluster seed nodes, host:port.
+	Addrs      []string
+	MasterName string
+	// SentinelPassword authenticates to the sentinels, which may not share
+	// the password of the nodes.
+	SentinelPassword string
+	Username         string
+	Password         string
+	// DB is ignored in cluster mode, and in sentinel mode with
+	// ReadFromReplica, which only have the database 0.
+	DB int
+	// ReadFromReplica sends the read only commands to the replicas, so they
+	// may read data that is not replicated yet.
+	ReadFromReplica bool
+	// PoolSize is the number of connections per node, 10 per CPU by
+	// default.
+	PoolSize     int
+	MinIdleConns int
+	// The timeouts are 5 seconds for dialing and 3 seconds for reading and
+	// writing by default.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// TLS enables TLS when it is not nil.
+	TLS *tls.Config
 }
 
 func NewRedisConn(opts RedisOptions) *RedisConn {
 	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
-	client := redis.NewClient(&redis.Options{
-		Addr:     address,
-		Password: opts.Password, // no password set
-		DB:       opts.DB,       // use default DB
-	})
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case RedisSentinel:
+		failover := &redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			PoolSize:         opts.PoolSize,
+			MinIdleConns:     opts.MinIdleConns,
+			DialTimeout:      opts.DialTimeout,
+			ReadTimeout:      opts.ReadTimeout,
+			WriteTimeout:     opts.WriteTimeout,
+			TLSConfig:        opts.TLS,
+		}
+		if opts.ReadFromReplica {
+			// spreads the reads over the master and the replicas
+			failover.RouteRandomly = true
+			client = redis.NewFailoverClusterClient(failover)
+		} else {
+			client = redis.NewFailoverClient(failover)
+		}
+	case RedisCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			ReadOnly:     opts.ReadFromReplica,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			TLSConfig:    opts.TLS,
+		})
+	default:
+		client = redis.NewClient(&redis.Options{
+			Addr:         address,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			TLSConfig:    opts.TLS,
+		})
+	}
 	return &RedisConn{
 		Client:   client,
+		mode:     opts.Mode,
 		address:  address,
 		password: opts.Password,
 		db:       opts.DB,
 	}
 }
 
+// Ping checks a node of the deployment, the clients of the other modes
+// find the healthy nodes by themselves.
 func (r *RedisConn) Ping(ctx context.Context) error {
 	return r.Client.Ping(ctx).Err()
 }
+
+func (r *RedisConn) Close() error {
+	return r.Client.Close()
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewRedisConn(t *testing.T) {
+	t.Run("standalone", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		port, _ := strconv.Atoi(mr.Port())
+		conn := NewRedisConn(RedisOptions{Host: mr.Host(), Port: port, PoolSize: 2, ReadTimeout: time.Second})
+		defer conn.Close()
+		assert.NoError(t, conn.Ping(context.Background()))
+		client := conn.Client.(*redis.Client)
+		assert.Equal(t, 2, client.Options().PoolSize)
+		assert.Equal(t, time.Second, client.Options().ReadTimeout)
+	})
+	t.Run("cluster", func(t *testing.T) {
+		conn := NewRedisConn(RedisOptions{Mode: RedisCluster, Addrs: []string{"a:7000", "b:7000"}, ReadFromReplica: true})
+		defer conn.Close()
+		client := conn.Client.(*redis.ClusterClient)
+		assert.Equal(t, []string{"a:7000", "b:7000"}, client.Options().Addrs)
+		assert.True(t, client.Options().ReadOnly)
+	})
+	t.Run("sentinel", func(t *testing.T) {
+		conn := NewRedisConn(RedisOptions{Mode: RedisSentinel, Addrs: []string{"s:26379"}, MasterName: "products"})
+		defer conn.Close()
+		assert.IsType(t, &redis.Client{}, conn.Client)
+		replicas := NewRedisConn(RedisOptions{Mode: RedisSentinel, Addrs: []string{"s:26379"}, MasterName: "products", ReadFromReplica: true})
+		defer replicas.Close()
+		assert.IsType(t, &redis.ClusterClient{}, replicas.Client)
+	})
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"io"
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+
+	postgresErrSerializationFailure = "40001"
+	postgresErrDeadlockDetected     = "40P01"
+)
+
+// IsRetryable tells whether err is a transient mysql or postgres error: a
+// deadlock, a lock wait timeout, a serialization failure or a broken
+// connection. The statement may have run
+// before the connection broke, so only idempotent operations and whole
+// transactions should be retried on it.
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == postgresErrSerializationFailure || pqErr.Code == postgresErrDeadlockDetected
+	}
+	return errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// RetryPolicy retries transient errors with an exponential backoff and
+// jitter.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call, 3 by default.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles on every
+	// attempt up to MaxDelay. 20ms and 500ms by default.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable is IsRetryable by default.
+	Retryable func(err error) bool
+}
+
+// NoRetry calls the operation once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = 20 * time.Millisecond
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = 500 * time.Millisecond
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	return p
+}
+
+// Do calls fn until it succeeds, fails with an error that can't be retried
+// or the attempts are used up. It doesn't wait past the deadline of ctx, the
+// last error is returned instead. fn must be idempotent.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	p = p.withDefaults()
+	var err error
+	for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := p.backoff(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				return err
+			}
+			logctx.From(ctx, nil).Debug("retrying the database operation",
+				zap.Int("attempt", attempt+1), zap.Duration("delay", delay), zap.Error(err))
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		if err = fn(ctx); err == nil || !p.Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff is the delay before the given retry, half of it is random so
+// clients that failed together don't retry together.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Transact runs fn in a transaction and commits it, the whole transaction is
+// run again when it fails with a retryable error.
+func (p RetryPolicy) Transact(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return p.Do(ctx, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if err := fn(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var (
+	errDeadlock = &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	errLockWait = &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	errDup      = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+)
+
+var fastRetry = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		errDeadlock:                           true,
+		errLockWait:                           true,
+		fmt.Errorf("update: %w", errDeadlock): true,
+		mysql.ErrInvalidConn:                  true,
+		syscall.ECONNRESET:                    true,
+		errDup:                                false,
+		&pq.Error{Code: "40001"}:              true,
+		&pq.Error{Code: "40P01"}:              true,
+		&pq.Error{Code: "23505"}:              false,
+		sql.ErrNoRows:                         false,
+		context.DeadlineExceeded:              false,
+	}
+	for err, want := range cases {
+		assert.Equal(t, want, IsRetryable(err), err.Error())
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	const query = `SELECT name FROM products WHERE id=?`
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	read := func(ctx context.Context) (string, error) {
+		var name string
+		err := db.QueryRowContext(ctx, query, 1).Scan(&name)
+		return name, err
+	}
+	t.Run("retries deadlocks and lock wait timeouts", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(1).WillReturnError(errDeadlock)
+		mock.ExpectQuery(query).WithArgs(1).WillReturnError(errLockWait)
+		mock.ExpectQuery(query).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("lemon"))
+		var name string
+		err := fastRetry.Do(context.Background(), func(ctx context.Context) (err error) {
+			name, err = read(ctx)
+			return err
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "lemon", name)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			mock.ExpectQuery(query).WithArgs(1).WillReturnError(errDeadlock)
+		}
+		err := fastRetry.Do(context.Background(), func(ctx context.Context) error {
+			_, err := read(ctx)
+			return err
+		})
+		assert.ErrorIs(t, err, errDeadlock)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("does not retry other errors", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(1).WillReturnError(errDup)
+		err := fastRetry.Do(context.Background(), func(ctx context.Context) error {
+			_, err := read(ctx)
+			return err
+		})
+		assert.ErrorIs(t, err, errDup)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("does not wait past the deadline", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(1).WillReturnError(errDeadlock)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		slow := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second}
+		start := time.Now()
+		err := slow.Do(ctx, func(ctx context.Context) error {
+			_, err := read(ctx)
+			return err
+		})
+		assert.ErrorIs(t, err, errDeadlock)
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRetryPolicy_Transact(t *testing.T) {
+	const update = `UPDATE products SET price=? WHERE id=?`
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	t.Run("runs the whole transaction again after a deadlock", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(update).WithArgs(20, 1).WillReturnError(errDeadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(update).WithArgs(20, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := fastRetry.Transact(context.Background(), db, nil, func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, update, 20, 1)
+			return err
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("rolls back on other errors", func(t *testing.T) {
+		failure := errors.New("price is negative")
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		err := fastRetry.Transact(context.Background(), db, nil, func(ctx context.Context, tx *sql.Tx) error {
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
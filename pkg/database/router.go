@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.uber.org/zap"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer picks the replica a read goes to.
+type Balancer int
+
+const (
+	RoundRobin Balancer = iota
+	// LeastConnections picks the replica with the fewest connections in use.
+	LeastConnections
+)
+
+const defaultLagQuery = `SHOW REPLICA STATUS`
+
+type RouterOptions struct {
+	Balancer Balancer
+	// MaxReplicationLag takes a replica out of the reads while it lags
+	// further behind the primary, 5 seconds by default.
+	MaxReplicationLag time.Duration
+	// LagCheckInterval is how often Watch checks the replicas, 5 seconds by
+	// default.
+	LagCheckInterval time.Duration
+	// StickyPeriod keeps the reads of a session on the primary after it
+	// wrote, so it reads its own writes. 2 seconds by default.
+	StickyPeriod time.Duration
+	// LagQuery reports the replication lag, SHOW REPLICA STATUS by default.
+	LagQuery string
+	Log      *zap.Logger
+}
+
+// Replica is a named read replica.
+type Replica struct {
+	Name string
+	DB   *sql.DB
+}
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// Router sends the writes to the primary and the reads to healthy replicas.
+// Replicas are unhealthy until Watch checked their lag.
+type Router struct {
+	primary  *sql.DB
+	replicas []*replica
+	opts     RouterOptions
+	next     atomic.Uint64
+}
+
+func NewRouter(primary *sql.DB, replicas []Replica, opts RouterOptions) *Router {
+	if opts.MaxReplicationLag == 0 {
+		opts.MaxReplicationLag = 5 * time.Second
+	}
+	if opts.LagCheckInterval == 0 {
+		opts.LagCheckInterval = 5 * time.Second
+	}
+	if opts.StickyPeriod == 0 {
+		opts.StickyPeriod = 2 * time.Second
+	}
+	if opts.LagQuery == "" {
+		opts.LagQuery = defaultLagQuery
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	r := &Router{primary: primary, opts: opts}
+	for _, rep := range replicas {
+		r.replicas = append(r.replicas, &replica{name: rep.Name, db: rep.DB})
+	}
+	return r
+}
+
+// Primary returns the primary without marking the session, for reads that
+// can't tolerate any lag such as the read of a read-modify-write.
+func (r *Router) Primary() *sql.DB {
+	return r.primary
+}
+
+// Writer returns the primary and keeps the following reads of the session
+// of ctx on it for the sticky period.
+func (r *Router) Writer(ctx context.Context) *sql.DB {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.lastWrite.Store(time.Now().UnixNano())
+	}
+	return r.primary
+}
+
+// Reader returns a healthy replica, or the primary when there is none or
+// the session of ctx wrote recently.
+func (r *Router) Reader(ctx context.Context) *sql.DB {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		if last := s.lastWrite.Load(); last != 0 && time.Since(time.Unix(0, last)) < r.opts.StickyPeriod {
+			return r.primary
+		}
+	}
+	healthy := make([]*replica, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return r.primary
+	}
+	if r.opts.Balancer == LeastConnections {
+		best := healthy[0]
+		for _, rep := range healthy[1:] {
+			if rep.db.Stats().InUse < best.db.Stats().InUse {
+				best = rep
+			}
+		}
+		return best.db
+	}
+	return healthy[(r.next.Add(1)-1)%uint64(len(healthy))].db
+}
+
+// Watch checks the replication lag of the replicas until ctx is done.
+func (r *Router) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.LagCheckInterval)
+	defer ticker.Stop()
+	for {
+		r.CheckReplicas(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckReplicas marks the replicas lagging over the threshold, or whose lag
+// is unknown, as unhealthy.
+func (r *Router) CheckReplicas(ctx context.Context) {
+	for _, rep := range r.replicas {
+		lag, err := r.replicationLag(ctx, rep.db)
+		healthy := err == nil && lag <= r.opts.MaxReplicationLag
+		if was := rep.healthy.Swap(healthy); was != healthy {
+			fields := []zap.Field{zap.String("replica", rep.name), zap.Duration("lag", lag)}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			}
+			if healthy {
+				r.opts.Log.Info("replica is back in the reads", fields...)
+			} else {
+				r.opts.Log.Warn("replica is out of the reads", fields...)
+			}
+		}
+	}
+}
+
+var errReplicationStopped = errors.New("replication is not running")
+
+func (r *Router) replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, r.opts.LagQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errReplicationStopped
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		// mysql 8.0.22 renamed the column
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, errReplicationStopped
+		}
+		seconds, err := strconv.Atoi(values[i].String)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("replication lag is not reported")
+}
+
+type sessionKey struct{}
+
+type session struct {
+	lastWrite atomic.Int64
+}
+
+// NewSession returns a context whose reads stick to the primary after it
+// wrote, usually one per request.
+func NewSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db, mock
+}
+
+func expectLag(mock sqlmock.Sqlmock, lag any) {
+	mock.ExpectQuery(defaultLagQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"}).AddRow("Waiting for source to send event", lag))
+}
+
+func TestRouter(t *testing.T) {
+	primary, _ := newMockDB(t)
+	first, firstMock := newMockDB(t)
+	second, secondMock := newMockDB(t)
+	r := NewRouter(primary, []Replica{{Name: "first", DB: first}, {Name: "second", DB: second}}, RouterOptions{
+		MaxReplicationLag: 5 * time.Second,
+		StickyPeriod:      time.Minute,
+	})
+	t.Run("reads the primary until the replicas are checked", func(t *testing.T) {
+		assert.Same(t, primary, r.Reader(context.Background()))
+	})
+	t.Run("round robins over the healthy replicas", func(t *testing.T) {
+		expectLag(firstMock, 0)
+		expectLag(secondMock, 1)
+		r.CheckReplicas(context.Background())
+		got := []*sql.DB{r.Reader(context.Background()), r.Reader(context.Background()), r.Reader(context.Background())}
+		assert.Contains(t, []*sql.DB{first, second}, got[0])
+		assert.NotSame(t, got[0], got[1])
+		assert.Same(t, got[0], got[2])
+	})
+	t.Run("skips lagging and stopped replicas", func(t *testing.T) {
+		expectLag(firstMock, 30)
+		expectLag(secondMock, 0)
+		r.CheckReplicas(context.Background())
+		for i := 0; i < 3; i++ {
+			assert.Same(t, second, r.Reader(context.Background()))
+		}
+		expectLag(firstMock, 0)
+		expectLag(secondMock, nil)
+		r.CheckReplicas(context.Background())
+		assert.Same(t, first, r.Reader(context.Background()))
+		firstMock.ExpectQuery(defaultLagQuery).WillReturnError(errors.New("connection refused"))
+		secondMock.ExpectQuery(defaultLagQuery).WillReturnError(errors.New("connection refused"))
+		r.CheckReplicas(context.Background())
+		assert.Same(t, primary, r.Reader(context.Background()))
+	})
+	t.Run("reads stick to the primary after a write of the session", func(t *testing.T) {
+		expectLag(firstMock, 0)
+		expectLag(secondMock, 0)
+		r.CheckReplicas(context.Background())
+		ctx := NewSession(context.Background())
+		assert.NotSame(t, primary, r.Reader(ctx))
+		assert.Same(t, primary, r.Writer(ctx))
+		assert.Same(t, primary, r.Reader(ctx))
+		// other sessions keep reading the replicas
+		assert.NotSame(t, primary, r.Reader(NewSession(context.Background())))
+	})
+	assert.NoError(t, firstMock.ExpectationsWereMet())
+	assert.NoError(t, secondMock.ExpectationsWereMet())
+}
+
+func TestRouter_LeastConnections(t *testing.T) {
+	primary, _ := newMockDB(t)
+	busy, busyMock := newMockDB(t)
+	idle, idleMock := newMockDB(t)
+	r := NewRouter(primary, []Replica{{Name: "busy", DB: busy}, {Name: "idle", DB: idle}}, RouterOptions{Balancer: LeastConnections})
+	expectLag(busyMock, 0)
+	expectLag(idleMock, 0)
+	r.CheckReplicas(context.Background())
+	// a connection held by an open transaction counts as in use
+	busyMock.ExpectBegin()
+	tx, err := busy.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i := 0; i < 3; i++ {
+		assert.Same(t, idle, r.Reader(context.Background()))
+	}
+}
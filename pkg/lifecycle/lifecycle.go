@@ -0,0 +1,154 @@
+// Package lifecycle starts the components of the application in dependency
+// order and stops them in reverse order when the process is asked to quit.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Component is a part of the application with a start and a stop step,
+// either may be nil. Start must return once the component is running.
+type Component struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+	// StartTimeout and StopTimeout override the defaults of the Manager.
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+type Options struct {
+	Logger *zap.Logger
+	// StartTimeout bounds the start of a component, 30 seconds by default.
+	StartTimeout time.Duration
+	// StopTimeout bounds the stop of a component, 10 seconds by default.
+	StopTimeout time.Duration
+	// Signals trigger the shutdown, SIGTERM and SIGINT by default.
+	Signals []os.Signal
+}
+
+type Manager struct {
+	opts       Options
+	components []Component
+	errc       chan error
+}
+
+func New(opts Options) *Manager {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = 30 * time.Second
+	}
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = 10 * time.Second
+	}
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+	return &Manager{opts: opts, errc: make(chan error, 1)}
+}
+
+// Append adds components, a component may depend on the ones added before
+// it.
+func (m *Manager) Append(components ...Component) {
+	m.components = append(m.components, components...)
+}
+
+// Fail reports that a running component broke, the application is shut
+// down as if it got a signal.
+func (m *Manager) Fail(err error) {
+	select {
+	case m.errc <- err:
+	default:
+	}
+}
+
+// Run starts the components, waits for a signal, ctx to be done or a
+// failure, then stops the started components in reverse order. It returns
+// the first error met.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx, stopSignals := signal.NotifyContext(ctx, m.opts.Signals...)
+	defer stopSignals()
+	started, err := m.start(ctx)
+	if err == nil {
+		m.opts.Logger.Info("application started", zap.Int("components", started))
+		select {
+		case <-ctx.Done():
+			m.opts.Logger.Info("shutting down the application")
+		case err = <-m.errc:
+			m.opts.Logger.Error("shutting down the application after a failure", zap.Error(err))
+		}
+	}
+	// a second signal kills the process instead of waiting for the shutdown
+	stopSignals()
+	if stopErr := m.stop(started); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+func (m *Manager) start(ctx context.Context) (int, error) {
+	for i, c := range m.components {
+		if c.Start == nil {
+			continue
+		}
+		timeout := c.StartTimeout
+		if timeout == 0 {
+			timeout = m.opts.StartTimeout
+		}
+		if err := m.runPhase(ctx, "start", c.Name, timeout, c.Start); err != nil {
+			return i, err
+		}
+	}
+	return len(m.components), nil
+}
+
+func (m *Manager) stop(started int) error {
+	var firstErr error
+	for i := started - 1; i >= 0; i-- {
+		c := m.components[i]
+		if c.Stop == nil {
+			continue
+		}
+		timeout := c.StopTimeout
+		if timeout == 0 {
+			timeout = m.opts.StopTimeout
+		}
+		// the run context is done already, stopping gets a fresh one
+		if err := m.runPhase(context.Background(), "stop", c.Name, timeout, c.Stop); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *Manager) runPhase(ctx context.Context, phase, name string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	log := m.opts.Logger.With(zap.String("component", name), zap.String("phase", phase))
+	log.Info("running the phase", zap.Duration("timeout", timeout))
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		log.Error("the phase failed", zap.Duration("took", time.Since(start)), zap.Error(err))
+		return fmt.Errorf("%s %s: %w", phase, name, err)
+	}
+	log.Info("the phase is done", zap.Duration("took", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,104 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) component(name string, startErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.add("start " + name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.add("stop " + name)
+			return nil
+		},
+	}
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestManager_Run(t *testing.T) {
+	t.Run("stops in reverse order", func(t *testing.T) {
+		var r recorder
+		m := New(Options{})
+		m.Append(r.component("pools", nil), r.component("workers", nil), r.component("http", nil))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- m.Run(ctx)
+		}()
+		assert.Eventually(t, func() bool { return len(r.list()) == 3 }, time.Second, time.Millisecond)
+		cancel()
+		assert.NoError(t, <-done)
+		assert.Equal(t, []string{"start pools", "start workers", "start http", "stop http", "stop workers", "stop pools"}, r.list())
+	})
+	t.Run("stops the started components when one fails to start", func(t *testing.T) {
+		var r recorder
+		m := New(Options{})
+		m.Append(r.component("pools", nil), r.component("workers", errors.New("boom")), r.component("http", nil))
+		err := m.Run(context.Background())
+		assert.ErrorContains(t, err, "start workers: boom")
+		assert.Equal(t, []string{"start pools", "start workers", "stop pools"}, r.list())
+	})
+	t.Run("shuts down on failure", func(t *testing.T) {
+		var r recorder
+		m := New(Options{})
+		m.Append(r.component("http", nil))
+		failure := errors.New("listener closed")
+		go m.Fail(failure)
+		assert.ErrorIs(t, m.Run(context.Background()), failure)
+		assert.Equal(t, []string{"start http", "stop http"}, r.list())
+	})
+	t.Run("shuts down on SIGTERM", func(t *testing.T) {
+		var r recorder
+		m := New(Options{})
+		m.Append(r.component("http", nil))
+		done := make(chan error)
+		go func() {
+			done <- m.Run(context.Background())
+		}()
+		assert.Eventually(t, func() bool { return len(r.list()) == 1 }, time.Second, time.Millisecond)
+		assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+		assert.NoError(t, <-done)
+		assert.Equal(t, []string{"start http", "stop http"}, r.list())
+	})
+	t.Run("bounds every phase", func(t *testing.T) {
+		m := New(Options{StopTimeout: 20 * time.Millisecond})
+		m.Append(Component{
+			Name: "stuck",
+			Stop: func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		assert.ErrorIs(t, m.Run(ctx), context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
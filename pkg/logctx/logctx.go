@@ -0,0 +1,57 @@
+// Package logctx carries a request scoped logger through the context, so the
+// lines logged while serving a request can be correlated.
+package logctx
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+type entry struct {
+	logger *zap.Logger
+	funcs  []func() zap.Field
+}
+
+// With returns a copy of ctx carrying l, it replaces the logger of ctx and
+// keeps its field funcs.
+func With(ctx context.Context, l *zap.Logger) context.Context {
+	e := entry{logger: l}
+	if parent, ok := ctx.Value(contextKey{}).(entry); ok {
+		e.funcs = parent.funcs
+	}
+	return context.WithValue(ctx, contextKey{}, e)
+}
+
+// WithFieldFunc adds a field computed every time the logger is taken from
+// ctx, for the values that are known later than the logger, like the route
+// matched by the router. fn may return zap.Skip().
+func WithFieldFunc(ctx context.Context, fn func() zap.Field) context.Context {
+	e, _ := ctx.Value(contextKey{}).(entry)
+	funcs := make([]func() zap.Field, len(e.funcs), len(e.funcs)+1)
+	copy(funcs, e.funcs)
+	e.funcs = append(funcs, fn)
+	return context.WithValue(ctx, contextKey{}, e)
+}
+
+// From returns the logger of ctx, fallback when ctx carries none. A nil
+// fallback is a no-op logger.
+func From(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	e, _ := ctx.Value(contextKey{}).(entry)
+	l := e.logger
+	if l == nil {
+		l = fallback
+	}
+	if l == nil {
+		return zap.NewNop()
+	}
+	if len(e.funcs) == 0 {
+		return l
+	}
+	fields := make([]zap.Field, len(e.funcs))
+	for i, fn := range e.funcs {
+		fields[i] = fn()
+	}
+	return l.With(fields...)
+}
@@ -0,0 +1,53 @@
+package logctx
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	fallback := zap.New(core).With(zap.String("logger", "fallback"))
+	t.Run("falls back without a logger", func(t *testing.T) {
+		From(context.Background(), fallback).Info("line")
+		assert.Equal(t, "fallback", logs.TakeAll()[0].ContextMap()["logger"])
+		From(context.Background(), nil).Info("discarded")
+		assert.Zero(t, logs.Len())
+	})
+	t.Run("returns the logger of the context", func(t *testing.T) {
+		ctx := With(context.Background(), zap.New(core).With(zap.String("request", "1")))
+		From(ctx, fallback).Info("line")
+		fields := logs.TakeAll()[0].ContextMap()
+		assert.Equal(t, "1", fields["request"])
+		assert.NotContains(t, fields, "logger")
+	})
+	t.Run("computes the field funcs when taken", func(t *testing.T) {
+		route := ""
+		ctx := WithFieldFunc(context.Background(), func() zap.Field {
+			if route == "" {
+				return zap.Skip()
+			}
+			return zap.String("route", route)
+		})
+		ctx = With(ctx, zap.New(core))
+		From(ctx, nil).Info("before routing")
+		route = "/products/{slug}"
+		From(ctx, nil).Info("after routing")
+		entries := logs.TakeAll()
+		assert.NotContains(t, entries[0].ContextMap(), "route")
+		assert.Equal(t, "/products/{slug}", entries[1].ContextMap()["route"])
+	})
+	t.Run("doesn't share the field funcs", func(t *testing.T) {
+		base := WithFieldFunc(With(context.Background(), zap.New(core)), func() zap.Field { return zap.Int("a", 1) })
+		first := WithFieldFunc(base, func() zap.Field { return zap.Int("b", 2) })
+		second := WithFieldFunc(base, func() zap.Field { return zap.Int("c", 3) })
+		From(first, nil).Info("first")
+		From(second, nil).Info("second")
+		entries := logs.TakeAll()
+		assert.Equal(t, map[string]any{"a": int64(1), "b": int64(2)}, entries[0].ContextMap())
+		assert.Equal(t, map[string]any{"a": int64(1), "c": int64(3)}, entries[1].ContextMap())
+	})
+}
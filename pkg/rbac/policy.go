@@ -0,0 +1,157 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/halilylm/microservice/pkg/auth"
+	"go.uber.org/zap"
+	"os"
+)
+
+type Action string
+
+const (
+	ActionProductRead        Action = "product:read"
+	ActionProductCreate      Action = "product:create"
+	ActionProductUpdate      Action = "product:update"
+	ActionProductUpdatePrice Action = "product:update_price"
+	ActionProductDelete      Action = "product:delete"
+	ActionProductRestore     Action = "product:restore"
+	ActionProductImport      Action = "product:import"
+	ActionCategoryCreate     Action = "category:create"
+	// ActionCategoryUpdate covers renaming and moving a category and
+	// linking products to it.
+	ActionCategoryUpdate Action = "category:update"
+	ActionCategoryDelete Action = "category:delete"
+	// ActionAll grants every action.
+	ActionAll Action = "*"
+)
+
+var ErrNoActor = errors.New("no authenticated actor")
+
+// DeniedError is returned when the actor is not allowed to perform an action.
+type DeniedError struct {
+	Actor  string
+	Action Action
+	Reason string
+}
+
+func (err *DeniedError) Error() string {
+	return fmt.Sprintf("%s is not allowed to %s: %s", err.Actor, err.Action, err.Reason)
+}
+
+// Policy maps roles to the actions they grant. API keys have no roles, their
+// scopes are mapped to actions instead.
+type Policy struct {
+	Roles  map[string][]Action `json:"roles"`
+	Scopes map[string][]Action `json:"scopes"`
+}
+
+// DefaultPolicy is used when no policy file is configured.
+func DefaultPolicy() *Policy {
+	editor := []Action{ActionProductRead, ActionProductCreate, ActionProductUpdate,
+		ActionCategoryCreate, ActionCategoryUpdate}
+	products := []Action{ActionProductRead, ActionProductCreate, ActionProductUpdate,
+		ActionProductUpdatePrice, ActionProductDelete, ActionProductRestore}
+	categories := []Action{ActionCategoryCreate, ActionCategoryUpdate, ActionCategoryDelete}
+	publisher := append(append([]Action{}, products...), categories...)
+	return &Policy{
+		Roles: map[string][]Action{
+			"viewer":    {ActionProductRead},
+			"editor":    editor,
+			"publisher": publisher,
+			"admin":     {ActionAll},
+		},
+		Scopes: map[string][]Action{
+			"products:read":    {ActionProductRead},
+			"products:write":   products,
+			"products:import":  {ActionProductImport},
+			"categories:write": categories,
+		},
+	}
+}
+
+// LoadPolicy reads a JSON policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func grants(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action || a == ActionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer decides whether the actor of ctx may perform an action.
+type Authorizer interface {
+	Authorize(ctx context.Context, action Action) error
+}
+
+type enforcer struct {
+	policy *Policy
+	logger *zap.Logger
+}
+
+// NewEnforcer returns an Authorizer that checks the principal of the context
+// against policy and logs every denial.
+func NewEnforcer(policy *Policy, logger *zap.Logger) Authorizer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &enforcer{policy: policy, logger: logger}
+}
+
+func (e *enforcer) Authorize(ctx context.Context, action Action) error {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		e.logger.Warn("access denied",
+			zap.String("action", string(action)),
+			zap.String("reason", ErrNoActor.Error()))
+		return ErrNoActor
+	}
+	if p.Method == auth.MethodAPIKey {
+		for _, scope := range p.Scopes {
+			if grants(e.policy.Scopes[scope], action) {
+				return nil
+			}
+		}
+	} else {
+		for _, role := range p.Roles {
+			if grants(e.policy.Roles[role], action) {
+				return nil
+			}
+		}
+	}
+	err := &DeniedError{Actor: p.Subject, Action: action, Reason: "no role or scope grants the action"}
+	e.logger.Warn("access denied",
+		zap.String("actor", p.Subject),
+		zap.String("method", p.Method),
+		zap.Strings("roles", p.Roles),
+		zap.String("action", string(action)),
+		zap.String("reason", err.Reason))
+	return err
+}
+
+type allowAll struct{}
+
+// AllowAll returns an Authorizer that allows everything.
+func AllowAll() Authorizer {
+	return allowAll{}
+}
+
+func (allowAll) Authorize(ctx context.Context, action Action) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package rbac
+
+import (
+	"context"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnforcer_Authorize(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	enforcer := NewEnforcer(DefaultPolicy(), zap.New(core))
+	withPrincipal := func(p *auth.Principal) context.Context {
+		return auth.NewContext(context.TODO(), p)
+	}
+	t.Run("requires an actor", func(t *testing.T) {
+		assert.ErrorIs(t, enforcer.Authorize(context.TODO(), ActionProductCreate), ErrNoActor)
+	})
+	t.Run("grants by role", func(t *testing.T) {
+		editor := withPrincipal(&auth.Principal{Subject: "ed", Method: auth.MethodJWT, Roles: []string{"editor"}})
+		assert.NoError(t, enforcer.Authorize(editor, ActionProductCreate))
+		assert.NoError(t, enforcer.Authorize(editor, ActionProductUpdate))
+		var denied *DeniedError
+		assert.ErrorAs(t, enforcer.Authorize(editor, ActionProductUpdatePrice), &denied)
+		assert.Equal(t, "ed", denied.Actor)
+	})
+	t.Run("admin is granted everything", func(t *testing.T) {
+		admin := withPrincipal(&auth.Principal{Subject: "root", Method: auth.MethodJWT, Roles: []string{"admin"}})
+		assert.NoError(t, enforcer.Authorize(admin, ActionProductImport))
+	})
+	t.Run("grants api keys by scope", func(t *testing.T) {
+		key := withPrincipal(&auth.Principal{Subject: "apikey:1", Method: auth.MethodAPIKey, Scopes: []string{"products:import"}})
+		assert.NoError(t, enforcer.Authorize(key, ActionProductImport))
+		assert.Error(t, enforcer.Authorize(key, ActionProductDelete))
+	})
+	t.Run("jwt scopes do not grant actions", func(t *testing.T) {
+		user := withPrincipal(&auth.Principal{Subject: "u", Method: auth.MethodJWT, Scopes: []string{"products:write"}})
+		assert.Error(t, enforcer.Authorize(user, ActionProductCreate))
+	})
+	t.Run("logs denials with actor and reason", func(t *testing.T) {
+		denials := logs.FilterMessage("access denied").FilterField(zap.String("actor", "u")).All()
+		if assert.NotEmpty(t, denials) {
+			assert.Contains(t, denials[0].ContextMap(), "reason")
+		}
+	})
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{"roles": {"viewer": ["product:read"], "pricer": ["product:update_price"]}}`), 0o600)
+	assert.NoError(t, err)
+	policy, err := LoadPolicy(path)
+	assert.NoError(t, err)
+	enforcer := NewEnforcer(policy, nil)
+	pricer := auth.NewContext(context.TODO(), &auth.Principal{Subject: "p", Roles: []string{"pricer"}})
+	assert.NoError(t, enforcer.Authorize(pricer, ActionProductUpdatePrice))
+	assert.Error(t, enforcer.Authorize(pricer, ActionProductCreate))
+}
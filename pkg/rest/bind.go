@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// bindParams sets the fields tagged `path:"name"` from the chi url params and
+// the fields tagged `query:"name"` from the query string. Embedded structs are
+// bound too. A path param that can't be parsed means the resource doesn't
+// exist and is answered with 404.
+func bindParams(r *http.Request, v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	return bindStruct(r, rv)
+}
+
+func bindStruct(r *http.Request, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		if field.Anonymous && fv.Kind() == reflect.Struct {
+			if err := bindStruct(r, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if name, ok := field.Tag.Lookup("path"); ok {
+			if err := setField(fv, chi.URLParam(r, name)); err != nil {
+				return NewNotFoundError()
+			}
+			continue
+		}
+		if name, ok := field.Tag.Lookup("query"); ok {
+			values, present := r.URL.Query()[name]
+			if !present {
+				continue
+			}
+			if err := setField(fv, values[0]); err != nil {
+				return NewBadRequest(fmt.Sprintf("invalid query parameter %s", name))
+			}
+		}
+	}
+	return nil
+}
+
+func setField(fv reflect.Value, raw string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field kind %s", fv.Kind())
+	}
+	return nil
+}
@@ -3,13 +3,19 @@ package rest
 import (
 	"errors"
 	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
 	"net/http"
 )
 
 var (
-	ErrInternalServer   = errors.New("internal server error")
-	ErrNotFound         = errors.New("requested content not found")
-	ErrStatusBadGateway = errors.New("status bad gateway")
+	ErrInternalServer        = errors.New("internal server error")
+	ErrNotFound              = errors.New("requested content not found")
+	ErrStatusBadGateway      = errors.New("status bad gateway")
+	ErrTooManyRequests       = errors.New("too many requests")
+	ErrRequestEntityTooLarge = errors.New("request entity too large")
+	ErrNotAcceptable         = errors.New("not acceptable")
+	ErrMethodNotAllowed      = errors.New("method not allowed")
+	ErrUnsupportedMediaType  = errors.New("unsupported media type")
 )
 
 type HTTPError struct {
@@ -48,3 +54,76 @@ func NewStatusBadGateway() *HTTPError {
 		Message: ErrStatusBadGateway.Error(),
 	}
 }
+
+func NewUnauthorized(msg string) *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusUnauthorized,
+		Message: msg,
+	}
+}
+
+func NewForbidden(msg string) *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusForbidden,
+		Message: msg,
+	}
+}
+
+func NewTooManyRequests() *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusTooManyRequests,
+		Message: ErrTooManyRequests.Error(),
+	}
+}
+
+func NewConflict(msg string) *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusConflict,
+		Message: msg,
+	}
+}
+
+func NewUnprocessableEntity(msg string) *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusUnprocessableEntity,
+		Message: msg,
+	}
+}
+
+func NewRequestEntityTooLarge() *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusRequestEntityTooLarge,
+		Message: ErrRequestEntityTooLarge.Error(),
+	}
+}
+
+func NewMethodNotAllowed() *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusMethodNotAllowed,
+		Message: ErrMethodNotAllowed.Error(),
+	}
+}
+
+func NewNotAcceptable() *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusNotAcceptable,
+		Message: ErrNotAcceptable.Error(),
+	}
+}
+
+func NewUnsupportedMediaType() *HTTPError {
+	return &HTTPError{
+		Code:    http.StatusUnsupportedMediaType,
+		Message: ErrUnsupportedMediaType.Error(),
+	}
+}
+
+// MarshalProto encodes the error as message Error { int32 code = 1; string message = 2; }.
+func (err *HTTPError) MarshalProto() ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(err.Code))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, err.Message)
+	return b, nil
+}
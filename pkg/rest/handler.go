@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/halilylm/microservice/pkg/codec"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// validate is shared by every handler so its struct cache survives requests.
+var validate = validator.New()
+
+var defaultCodecs = codec.DefaultRegistry()
+
+// NoContent is returned by handlers that answer without a body.
+type NoContent struct{}
+
+// BodyReader is implemented by requests that read their body themselves, such
+// as patch documents that are not decoded into the request struct. It is
+// handed the raw Content-Type and a body limited to the configured size.
+type BodyReader interface {
+	ReadBody(contentType string, body io.Reader) error
+}
+
+type handlerConfig struct {
+	status       int
+	codecs       *codec.Registry
+	maxBodyBytes int64
+}
+
+type HandlerOption func(*handlerConfig)
+
+// WithStatus sets the status of a successful response, 200 by default.
+func WithStatus(status int) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.status = status
+	}
+}
+
+// WithCodecs replaces the default codec registry.
+func WithCodecs(codecs *codec.Registry) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.codecs = codecs
+	}
+}
+
+// WithMaxBodyBytes limits the request body, 1MB by default.
+func WithMaxBodyBytes(n int64) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.maxBodyBytes = n
+	}
+}
+
+// Handle adapts fn to an http.HandlerFunc. The request is decoded from the
+// body with the codec of its Content-Type, unknown fields are rejected, then
+// fields tagged with path or query are bound and the result is validated.
+// The response is encoded with the codec negotiated from the Accept header.
+// Errors that are not an *HTTPError are answered with 500.
+func Handle[Req, Res any](fn func(ctx context.Context, req Req) (Res, error), opts ...HandlerOption) http.HandlerFunc {
+	cfg := handlerConfig{
+		status:       http.StatusOK,
+		codecs:       defaultCodecs,
+		maxBodyBytes: 1 << 20,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// refused before fn runs, it may have side effects
+		enc, err := negotiate[Res](cfg.codecs, r.Header.Get("Accept"))
+		if err != nil {
+			Respond(w, cfg.codecs.Default(), http.StatusNotAcceptable, NewNotAcceptable())
+			return
+		}
+		var req Req
+		if err := decodeBody(w, r, &req, cfg); err != nil {
+			RespondError(w, enc, err)
+			return
+		}
+		if err := bindParams(r, &req); err != nil {
+			RespondError(w, enc, err)
+			return
+		}
+		if err := validateRequest(&req); err != nil {
+			RespondError(w, enc, err)
+			return
+		}
+		res, err := fn(r.Context(), req)
+		if err != nil {
+			RespondError(w, enc, err)
+			return
+		}
+		if _, ok := any(res).(NoContent); ok {
+			w.Header().Set("Content-Type", enc.ContentType())
+			w.WriteHeader(cfg.status)
+			return
+		}
+		Respond(w, enc, cfg.status, res)
+	}
+}
+
+// Respond writes v with enc. v is encoded before anything is written, a
+// value enc can't encode is answered with 500.
+func Respond(w http.ResponseWriter, enc codec.Codec, status int, v any) {
+	var body bytes.Buffer
+	if err := enc.Encode(&body, v); err != nil {
+		status = http.StatusInternalServerError
+		body.Reset()
+		// the error may not be encodable either, it is then answered without
+		// a body
+		if err := enc.Encode(&body, NewInternalServerError()); err != nil {
+			body.Reset()
+		}
+	}
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
+
+// negotiate picks among the codecs that can encode the responses of type Res.
+func negotiate[Res any](codecs *codec.Registry, accept string) (codec.Codec, error) {
+	t := reflect.TypeOf((*Res)(nil)).Elem()
+	if t == reflect.TypeOf(NoContent{}) {
+		return codecs.Negotiate(accept)
+	}
+	return codecs.NegotiateFor(accept, t)
+}
+
+// RespondError writes err when it is an *HTTPError and a 500 otherwise.
+func RespondError(w http.ResponseWriter, enc codec.Codec, err error) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		Respond(w, enc, httpErr.Code, httpErr)
+		return
+	}
+	Respond(w, enc, http.StatusInternalServerError, NewInternalServerError())
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, cfg handlerConfig) error {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return nil
+	}
+	body := http.MaxBytesReader(w, r.Body, cfg.maxBodyBytes)
+	var err error
+	if br, ok := v.(BodyReader); ok {
+		err = br.ReadBody(r.Header.Get("Content-Type"), body)
+	} else {
+		dec, ctErr := cfg.codecs.ForContentType(r.Header.Get("Content-Type"))
+		if ctErr != nil {
+			return NewUnsupportedMediaType()
+		}
+		if strict, ok := dec.(codec.StrictDecoder); ok {
+			err = strict.DecodeStrict(body, v)
+		} else {
+			err = dec.Decode(body, v)
+		}
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return NewRequestEntityTooLarge()
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	return NewBadRequest("invalid request body: " + err.Error())
+}
+
+func validateRequest(v any) error {
+	if reflect.Indirect(reflect.ValueOf(v)).Kind() != reflect.Struct {
+		return nil
+	}
+	if err := validate.Struct(v); err != nil {
+		return NewBadRequest(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type itemRequest struct {
+	ID    int64  `path:"id" json:"-"`
+	Name  string `json:"name" validate:"required"`
+	Limit int    `query:"limit" json:"-"`
+}
+
+type itemResponse struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Limit int    `json:"limit"`
+}
+
+func newItemRouter(fn func(ctx context.Context, req itemRequest) (*itemResponse, error)) chi.Router {
+	r := chi.NewRouter()
+	r.Put("/items/{id}", Handle(fn, WithMaxBodyBytes(64)))
+	r.Delete("/items/{id}", Handle(func(ctx context.Context, req struct {
+		ID int64 `path:"id"`
+	}) (NoContent, error) {
+		return NoContent{}, nil
+	}, WithStatus(http.StatusNoContent)))
+	return r
+}
+
+func TestHandle(t *testing.T) {
+	echo := func(ctx context.Context, req itemRequest) (*itemResponse, error) {
+		return &itemResponse{ID: req.ID, Name: req.Name, Limit: req.Limit}, nil
+	}
+	serve := func(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+	t.Run("binds body, path and query", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7?limit=3", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"id": 7, "name": "lamp", "limit": 3}`, res.Body.String())
+	})
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7", `{"name": "lamp", "colour": "red"}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Contains(t, res.Body.String(), "colour")
+	})
+	t.Run("rejects trailing data", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7", `{"name": "lamp"} {}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+	t.Run("limits the body size", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7", `{"name": "`+strings.Repeat("a", 100)+`"}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	})
+	t.Run("validates the request", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7", `{}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+	t.Run("unparsable path params are not found", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/seven", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+	t.Run("unparsable query params are bad requests", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodPut, "/items/7?limit=many", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+	t.Run("maps errors", func(t *testing.T) {
+		notFound := func(ctx context.Context, req itemRequest) (*itemResponse, error) {
+			return nil, NewNotFoundError()
+		}
+		res := serve(newItemRouter(notFound), http.MethodPut, "/items/7", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		failing := func(ctx context.Context, req itemRequest) (*itemResponse, error) {
+			return nil, errors.New("connection refused")
+		}
+		res = serve(newItemRouter(failing), http.MethodPut, "/items/7", `{"name": "lamp"}`)
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		assert.NotContains(t, res.Body.String(), "connection refused")
+	})
+	t.Run("writes no body for NoContent", func(t *testing.T) {
+		res := serve(newItemRouter(echo), http.MethodDelete, "/items/7", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Empty(t, res.Body.String())
+	})
+	t.Run("refuses a codec that can't encode the response", func(t *testing.T) {
+		called := false
+		r := newItemRouter(func(ctx context.Context, req itemRequest) (*itemResponse, error) {
+			called = true
+			return echo(ctx, req)
+		})
+		req := httptest.NewRequest(http.MethodPut, "/items/7", strings.NewReader(`{"name": "lamp"}`))
+		req.Header.Set("Accept", "application/x-protobuf")
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusNotAcceptable, res.Code)
+		assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+		assert.False(t, called)
+	})
+}
+
+func TestRespond(t *testing.T) {
+	res := httptest.NewRecorder()
+	Respond(res, codec.Protobuf{}, http.StatusOK, &itemResponse{ID: 7})
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.NotEmpty(t, res.Body.Bytes())
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+)
+
+var routableMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// AllowedMethods lists the methods the router serves for the path of r.
+func AllowedMethods(r *http.Request) []string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return nil
+	}
+	var allowed []string
+	for _, method := range routableMethods {
+		if rctx.Routes.Match(chi.NewRouteContext(), method, r.URL.Path) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// Options answers OPTIONS requests with the methods allowed on the path.
+func Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(AllowedMethods(r), ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// NotFound answers unknown routes with the standard error body.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respondNegotiated(w, r, NewNotFoundError())
+}
+
+// MethodNotAllowed answers known routes called with another method, the
+// Allow header lists the methods that are served.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(AllowedMethods(r), ", "))
+	respondNegotiated(w, r, NewMethodNotAllowed())
+}
+
+func respondNegotiated(w http.ResponseWriter, r *http.Request, err *HTTPError) {
+	enc, negotiateErr := defaultCodecs.Negotiate(r.Header.Get("Accept"))
+	if negotiateErr != nil {
+		enc = defaultCodecs.Default()
+	}
+	Respond(w, enc, err.Code, err)
+}
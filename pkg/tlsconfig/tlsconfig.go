@@ -0,0 +1,211 @@
+// Package tlsconfig builds the TLS configurations of the listener and of the
+// database clients.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+var errNoCertificates = errors.New("no certificate found in the CA bundle")
+
+// ParseVersion parses a TLS version such as 1.2, an empty version is TLS
+// 1.2.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", v)
+}
+
+// CertReloader serves the certificate of a pair of PEM files and reloads it
+// when they change, so renewed certificates are used without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	log      *zap.Logger
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func NewCertReloader(certFile, keyFile string, log *zap.Logger) (*CertReloader, error) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, log: log}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and its key again.
+func (r *CertReloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// Watch polls the files every interval and reloads the certificate when
+// one of them changes, until ctx is done. The previous certificate is kept
+// while the files don't form a valid pair, e.g. between the writes of the
+// certificate and of the key.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := r.lastModified()
+			if err != nil {
+				r.log.Error("could not stat the certificate", zap.String("path", r.certFile), zap.Error(err))
+				continue
+			}
+			r.mu.RLock()
+			changed := !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.log.Error("could not reload the certificate", zap.String("path", r.certFile), zap.Error(err))
+				continue
+			}
+			r.log.Info("reloaded the certificate", zap.String("path", r.certFile))
+		}
+	}
+}
+
+// GetCertificate is the tls.Config callback serving the current
+// certificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// lastModified is the latest modification time of the two files.
+func (r *CertReloader) lastModified() (time.Time, error) {
+	cert, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if key.ModTime().After(cert.ModTime()) {
+		return key.ModTime(), nil
+	}
+	return cert.ModTime(), nil
+}
+
+type ServerOptions struct {
+	// ClientCAFile asks the clients for a certificate signed by one of its
+	// CAs, mutual TLS.
+	ClientCAFile string
+	// RequireClientCert rejects the clients without a certificate, they are
+	// only verified when they present one otherwise.
+	RequireClientCert bool
+	// MinVersion is TLS 1.2 by default.
+	MinVersion uint16
+}
+
+// Server returns the configuration of a listener serving the certificate of
+// certs.
+func Server(certs *CertReloader, opts ServerOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     opts.MinVersion,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if opts.ClientCAFile != "" {
+		pool, err := loadCertPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if opts.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if opts.RequireClientCert {
+		return nil, errors.New("requiring client certificates needs a client CA file")
+	}
+	return cfg, nil
+}
+
+type ClientOptions struct {
+	// CAFile is the bundle of the CAs trusted to sign the server
+	// certificate, instead of the system ones.
+	CAFile string
+	// CertFile and KeyFile authenticate the client when the server asks for
+	// a certificate.
+	CertFile string
+	KeyFile  string
+	// ServerName is verified against the certificate, the host dialed by
+	// default.
+	ServerName string
+	// MinVersion is TLS 1.2 by default.
+	MinVersion uint16
+}
+
+// Client returns the configuration of a connection to a server.
+func Client(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: opts.ServerName, MinVersion: opts.MinVersion}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if opts.CAFile != "" {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: %w", path, errNoCertificates)
+	}
+	return pool, nil
+}
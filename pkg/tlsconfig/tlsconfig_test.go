@@ -0,0 +1,197 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of name signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return path
+}
+
+// handshake connects a client configured with client to a listener
+// configured with server.
+func handshake(t *testing.T, server, client *tls.Config) error {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+		// the client learns about a rejected certificate on its first read
+		_, _ = conn.Write([]byte{1})
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = ParseVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = ParseVersion("1.0")
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2, "localhost", x509.ExtKeyUsageServerAuth)
+	certFile := writeFile(t, dir, "cert.pem", certPEM)
+	keyFile := writeFile(t, dir, "key.pem", keyPEM)
+	r, err := NewCertReloader(certFile, keyFile, nil)
+	require.NoError(t, err)
+	first, _ := r.GetCertificate(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	certPEM, keyPEM = ca.issue(t, 3, "localhost", x509.ExtKeyUsageServerAuth)
+	writeFile(t, dir, "cert.pem", certPEM)
+	writeFile(t, dir, "key.pem", keyPEM)
+	// the modification times may not change within the file system
+	// resolution
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Eventually(t, func() bool {
+		cert, _ := r.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		return cert != first && err == nil && leaf.SerialNumber.Int64() == 3
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("keeps the certificate while the pair is invalid", func(t *testing.T) {
+		current, _ := r.GetCertificate(nil)
+		_, otherKey := ca.issue(t, 4, "localhost", x509.ExtKeyUsageServerAuth)
+		writeFile(t, dir, "key.pem", otherKey)
+		assert.Error(t, r.Reload())
+		cert, _ := r.GetCertificate(nil)
+		assert.Same(t, current, cert)
+	})
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	certPEM, keyPEM := ca.issue(t, 2, "localhost", x509.ExtKeyUsageServerAuth)
+	certs, err := NewCertReloader(writeFile(t, dir, "cert.pem", certPEM), writeFile(t, dir, "key.pem", keyPEM), nil)
+	require.NoError(t, err)
+	clientCert, clientKey := ca.issue(t, 3, "client", x509.ExtKeyUsageClientAuth)
+	clientCertFile := writeFile(t, dir, "client.pem", clientCert)
+	clientKeyFile := writeFile(t, dir, "client-key.pem", clientKey)
+
+	t.Run("verifies the server with the CA bundle", func(t *testing.T) {
+		server, err := Server(certs, ServerOptions{})
+		require.NoError(t, err)
+		client, err := Client(ClientOptions{CAFile: caFile, ServerName: "localhost"})
+		require.NoError(t, err)
+		assert.NoError(t, handshake(t, server, client))
+		client, err = Client(ClientOptions{ServerName: "localhost"})
+		require.NoError(t, err)
+		assert.Error(t, handshake(t, server, client))
+	})
+	t.Run("requires a client certificate", func(t *testing.T) {
+		server, err := Server(certs, ServerOptions{ClientCAFile: caFile, RequireClientCert: true})
+		require.NoError(t, err)
+		client, err := Client(ClientOptions{CAFile: caFile, ServerName: "localhost"})
+		require.NoError(t, err)
+		assert.Error(t, handshake(t, server, client))
+		client, err = Client(ClientOptions{CAFile: caFile, ServerName: "localhost", CertFile: clientCertFile, KeyFile: clientKeyFile})
+		require.NoError(t, err)
+		assert.NoError(t, handshake(t, server, client))
+	})
+	t.Run("rejects older versions", func(t *testing.T) {
+		server, err := Server(certs, ServerOptions{MinVersion: tls.VersionTLS13})
+		require.NoError(t, err)
+		client, err := Client(ClientOptions{CAFile: caFile, ServerName: "localhost"})
+		require.NoError(t, err)
+		client.MaxVersion = tls.VersionTLS12
+		assert.Error(t, handshake(t, server, client))
+	})
+	t.Run("needs a CA to require client certificates", func(t *testing.T) {
+		_, err := Server(certs, ServerOptions{RequireClientCert: true})
+		assert.Error(t, err)
+	})
+}
+
+func TestClient_InvalidBundle(t *testing.T) {
+	_, err := Client(ClientOptions{CAFile: writeFile(t, t.TempDir(), "ca.pem", []byte("not a certificate"))})
+	assert.ErrorIs(t, err, errNoCertificates)
+	_, err = Client(ClientOptions{CAFile: "missing.pem"})
+	assert.Error(t, err)
+}
@@ -1,135 +1,100 @@
 package http
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator/v10"
+	"github.com/halilylm/microservice/pkg/jsonpatch"
 	"github.com/halilylm/microservice/pkg/rest"
 	"github.com/halilylm/microservice/product"
 	"github.com/halilylm/microservice/product/usecase"
+	"io"
+	"mime"
 	"net/http"
-	"path"
-	"strconv"
 )
 
 type productHandler struct {
 	uc usecase.ProductUseCase
 }
 
-func NewProductHandler(uc usecase.ProductUseCase, r chi.Router) {
-	handler := productHandler{uc: uc}
-	r.Post("/", handler.CreateProduct)
-	r.Delete("/", handler.DeleteProduct)
-	r.Get("/", handler.GetProductBySlug)
+type productIDRequest struct {
+	ID int64 `path:"id"`
 }
 
-func (h *productHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	var product product.Product
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(rest.NewBadRequest("validation error"))
-		return
-	}
-	validate := validator.New()
-	if err := validate.Struct(&product); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(rest.NewBadRequest(err.Error()))
-		return
-	}
-	createdProduct, err := h.uc.CreateProduct(r.Context(), &product)
-	if err != nil {
-		var httpErr *rest.HTTPError
-		if errors.As(err, &httpErr) {
-			w.WriteHeader(httpErr.Code)
-			json.NewEncoder(w).Encode(httpErr)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(rest.NewInternalServerError())
-		return
-	}
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdProduct)
+type productSlugRequest struct {
+	Slug string `path:"slug"`
 }
 
-func (h *productHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
-	pid, err := strconv.Atoi(id)
-	w.Header().Set("Content-Type", "application/json")
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(rest.NewNotFoundError())
-		return
-	}
-	if err := h.uc.DeleteProduct(r.Context(), int64(pid)); err != nil {
-		var httpErr *rest.HTTPError
-		if errors.As(err, &httpErr) {
-			w.WriteHeader(httpErr.Code)
-			json.NewEncoder(w).Encode(httpErr)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(rest.NewInternalServerError())
-		return
-	}
-	w.WriteHeader(http.StatusOK)
+type updateProductRequest struct {
+	product.Product
+	ID int64 `path:"id" json:"-" xml:"-"`
 }
 
-func (h *productHandler) GetProductBySlug(w http.ResponseWriter, r *http.Request) {
-	slug := path.Base(r.URL.Path)
-	w.Header().Set("Content-Type", "application/json")
-	foundProduct, err := h.uc.GetProductBySlug(r.Context(), slug)
-	if err != nil {
-		var httpErr *rest.HTTPError
-		if errors.As(err, &httpErr) {
-			w.WriteHeader(httpErr.Code)
-			json.NewEncoder(w).Encode(httpErr)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(rest.NewInternalServerError())
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(foundProduct)
+// patchProductRequest carries a merge patch or a json patch, plain json bodies
+// are taken as merge patches.
+type patchProductRequest struct {
+	ID    int64 `path:"id"`
+	Patch jsonpatch.Patcher
 }
 
-func (h *productHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
-	var product product.Product
-	w.Header().Set("Content-Type", "application/json")
-	id := path.Base(r.URL.Path)
-	pid, err := strconv.Atoi(id)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(rest.NewNotFoundError())
-		return
+func (req *patchProductRequest) ReadBody(contentType string, body io.Reader) error {
+	mediaType := jsonpatch.MergePatchContentType
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return rest.NewUnsupportedMediaType()
+		}
 	}
-	product.ID = int64(pid)
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(rest.NewBadRequest("validation error"))
-		return
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
 	}
-	validate := validator.New()
-	if err := validate.Struct(&product); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(rest.NewBadRequest(err.Error()))
-		return
+	switch mediaType {
+	case jsonpatch.MergePatchContentType, "application/json":
+		req.Patch, err = jsonpatch.ParseMergePatch(data)
+	case jsonpatch.JSONPatchContentType:
+		req.Patch, err = jsonpatch.Decode(data)
+	default:
+		return rest.NewUnsupportedMediaType()
 	}
-	updatedProduct, err := h.uc.UpdateProduct(r.Context(), &product)
 	if err != nil {
-		var httpErr *rest.HTTPError
-		if errors.As(err, &httpErr) {
-			w.WriteHeader(httpErr.Code)
-			json.NewEncoder(w).Encode(httpErr)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(rest.NewInternalServerError())
-		return
+		return rest.NewBadRequest(err.Error())
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(updatedProduct)
+	return nil
+}
+
+func NewProductHandler(uc usecase.ProductUseCase, r chi.Router) {
+	handler := productHandler{uc: uc}
+	r.Post("/", rest.Handle(handler.CreateProduct, rest.WithStatus(http.StatusCreated)))
+	r.Options("/", rest.Options)
+	// products are read by slug and changed by id, chi tries the numeric
+	// pattern first so both can live under the same prefix
+	get := rest.Handle(handler.GetProductBySlug)
+	r.Get("/{slug}", get)
+	r.Head("/{slug}", get)
+	r.Options("/{slug}", rest.Options)
+	r.Put("/{id:[0-9]+}", rest.Handle(handler.UpdateProduct))
+	r.Patch("/{id:[0-9]+}", rest.Handle(handler.PatchProduct))
+	r.Delete("/{id:[0-9]+}", rest.Handle(handler.DeleteProduct))
+	r.Options("/{id:[0-9]+}", rest.Options)
+}
+
+func (h *productHandler) CreateProduct(ctx context.Context, req product.Product) (*product.Product, error) {
+	return h.uc.CreateProduct(ctx, &req)
+}
+
+func (h *productHandler) DeleteProduct(ctx context.Context, req productIDRequest) (rest.NoContent, error) {
+	return rest.NoContent{}, h.uc.DeleteProduct(ctx, req.ID)
+}
+
+func (h *productHandler) GetProductBySlug(ctx context.Context, req productSlugRequest) (*product.Product, error) {
+	return h.uc.GetProductBySlug(ctx, req.Slug)
+}
+
+func (h *productHandler) UpdateProduct(ctx context.Context, req updateProductRequest) (*product.Product, error) {
+	req.Product.ID = req.ID
+	return h.uc.UpdateProduct(ctx, &req.Product)
+}
+
+func (h *productHandler) PatchProduct(ctx context.Context, req patchProductRequest) (*product.Product, error) {
+	return h.uc.PatchProduct(ctx, req.ID, req.Patch)
 }
@@ -3,9 +3,14 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"github.com/go-chi/chi/v5"
+	"github.com/halilylm/microservice/pkg/codec"
+	"github.com/halilylm/microservice/pkg/jsonpatch"
 	"github.com/halilylm/microservice/pkg/maps"
 	"github.com/halilylm/microservice/pkg/rest"
 	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/usecase"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
@@ -52,6 +57,27 @@ func (m *MockProductUsecase) UpdateProduct(ctx context.Context, product *product
 	return product, nil
 }
 
+func (m *MockProductUsecase) PatchProduct(ctx context.Context, id int64, patch jsonpatch.Patcher) (*product.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.Products[id]
+	if !ok {
+		return nil, rest.NewNotFoundError()
+	}
+	doc, _ := json.Marshal(current)
+	doc, err := patch.Apply(doc)
+	if err != nil {
+		return nil, rest.NewUnprocessableEntity(err.Error())
+	}
+	var patched product.Product
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		return nil, rest.NewUnprocessableEntity(err.Error())
+	}
+	patched.ID = id
+	m.Products[id] = &patched
+	return &patched, nil
+}
+
 func (m *MockProductUsecase) DeleteProduct(ctx context.Context, id int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -72,12 +98,12 @@ func (m *MockProductUsecase) GetProductBySlug(ctx context.Context, slug string)
 
 func TestProductHandler_CreateProduct(t *testing.T) {
 	uc := NewMockProductUsecase(nil)
-	p := productHandler{uc: uc}
+	p := newTestRouter(uc)
 	t.Run("can create a product", func(t *testing.T) {
 		product := strings.NewReader(`{"name": "banana watch", "price": 50}`)
-		req := httptest.NewRequest(http.MethodPost, "/test", product)
+		req := httptest.NewRequest(http.MethodPost, "/", product)
 		res := httptest.NewRecorder()
-		p.CreateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusCreated, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 1, len(uc.Products))
@@ -85,9 +111,9 @@ func TestProductHandler_CreateProduct(t *testing.T) {
 	t.Run("name is required", func(t *testing.T) {
 		uc.refreshDatabase()
 		product := strings.NewReader(`{"price": 50}`)
-		req := httptest.NewRequest(http.MethodPost, "/test", product)
+		req := httptest.NewRequest(http.MethodPost, "/", product)
 		res := httptest.NewRecorder()
-		p.CreateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 0, len(uc.Products))
@@ -95,9 +121,9 @@ func TestProductHandler_CreateProduct(t *testing.T) {
 	t.Run("price is required", func(t *testing.T) {
 		uc.refreshDatabase()
 		testProduct := strings.NewReader(`{"name": "banana watch"}`)
-		req := httptest.NewRequest(http.MethodPost, "/test", testProduct)
+		req := httptest.NewRequest(http.MethodPost, "/", testProduct)
 		res := httptest.NewRecorder()
-		p.CreateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 0, len(uc.Products))
@@ -105,9 +131,9 @@ func TestProductHandler_CreateProduct(t *testing.T) {
 	t.Run("price should be number", func(t *testing.T) {
 		uc.refreshDatabase()
 		testProduct := strings.NewReader(`{"name": "banana watch", "price": "abc"}`)
-		req := httptest.NewRequest(http.MethodPost, "/test", testProduct)
+		req := httptest.NewRequest(http.MethodPost, "/", testProduct)
 		res := httptest.NewRecorder()
-		p.CreateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 0, len(uc.Products))
@@ -115,9 +141,9 @@ func TestProductHandler_CreateProduct(t *testing.T) {
 	t.Run("returns error for invalid json", func(t *testing.T) {
 		uc.refreshDatabase()
 		testProduct := strings.NewReader(`{"name": "banana watch", "price": "abc",}`)
-		req := httptest.NewRequest(http.MethodPost, "/test", testProduct)
+		req := httptest.NewRequest(http.MethodPost, "/", testProduct)
 		res := httptest.NewRecorder()
-		p.CreateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 0, len(uc.Products))
@@ -133,20 +159,20 @@ func TestProductHandler_DeleteProduct(t *testing.T) {
 			Price: 5,
 		},
 	})
-	p := productHandler{uc: uc}
+	p := newTestRouter(uc)
 	t.Run("deletes a product", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodDelete, "/api/v1/product/0", nil)
+		req := httptest.NewRequest(http.MethodDelete, "/0", nil)
 		res := httptest.NewRecorder()
-		p.DeleteProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 0, len(uc.Products))
 	})
 	t.Run("returns 404 when product not exists", func(t *testing.T) {
 		uc.refreshDatabase()
-		req := httptest.NewRequest(http.MethodDelete, "/api/v1/product/1", nil)
+		req := httptest.NewRequest(http.MethodDelete, "/1", nil)
 		res := httptest.NewRecorder()
-		p.DeleteProduct(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, 1, len(uc.Products))
@@ -162,18 +188,18 @@ func TestProductHandler_GetProductBySlug(t *testing.T) {
 			Price: 500,
 		},
 	})
-	p := productHandler{uc: uc}
+	p := newTestRouter(uc)
 	t.Run("gets a product", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/test/orange-book", nil)
+		req := httptest.NewRequest(http.MethodGet, "/orange-book", nil)
 		res := httptest.NewRecorder()
-		p.GetProductBySlug(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 	})
 	t.Run("returns 404 when product not exists", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/test/banana-book", nil)
+		req := httptest.NewRequest(http.MethodGet, "/banana-book", nil)
 		res := httptest.NewRecorder()
-		p.GetProductBySlug(res, req)
+		p.ServeHTTP(res, req)
 		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
 		assertContentType(t, res, "application/json")
 	})
@@ -188,12 +214,12 @@ func TestProductHandler_UpdateProduct(t *testing.T) {
 			Price: 500,
 		},
 	})
-	p := productHandler{uc: uc}
+	p := newTestRouter(uc)
 	t.Run("updates the product", func(t *testing.T) {
 		testProduct := strings.NewReader(`{"name": "banana watch", "price": 200}`)
-		req := httptest.NewRequest(http.MethodPut, "/test/0", testProduct)
+		req := httptest.NewRequest(http.MethodPut, "/0", testProduct)
 		res := httptest.NewRecorder()
-		p.UpdateProduct(res, req)
+		p.ServeHTTP(res, req)
 		var updatedProduct product.Product
 		json.NewDecoder(res.Body).Decode(&updatedProduct)
 		assertContentType(t, res, "application/json")
@@ -204,15 +230,191 @@ func TestProductHandler_UpdateProduct(t *testing.T) {
 	})
 	t.Run("returns 404 when product not exists", func(t *testing.T) {
 		testProduct := strings.NewReader(`{"name": "banana watch", "price": 200}`)
-		req := httptest.NewRequest(http.MethodPut, "/test/1", testProduct)
+		req := httptest.NewRequest(http.MethodPut, "/1", testProduct)
 		res := httptest.NewRecorder()
-		p.UpdateProduct(res, req)
+		p.ServeHTTP(res, req)
 		assertContentType(t, res, "application/json")
 		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
 		assert.Equal(t, 1, len(uc.Products))
 	})
 }
 
+func TestProductHandler_PatchProduct(t *testing.T) {
+	uc := NewMockProductUsecase(map[int64]*product.Product{
+		0: {
+			ID:    0,
+			Name:  "orange book",
+			Slug:  "orange-book",
+			Price: 500,
+		},
+	})
+	p := newTestRouter(uc)
+	t.Run("applies a merge patch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/0", strings.NewReader(`{"price": 450}`))
+		req.Header.Set("Content-Type", jsonpatch.MergePatchContentType)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assertContentType(t, res, "application/json")
+		assert.Equal(t, 450, uc.Products[0].Price)
+		assert.Equal(t, "orange book", uc.Products[0].Name)
+	})
+	t.Run("applies a json patch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/0", strings.NewReader(`[{"op":"replace","path":"/name","value":"lemon book"}]`))
+		req.Header.Set("Content-Type", jsonpatch.JSONPatchContentType)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "lemon book", uc.Products[0].Name)
+	})
+	t.Run("returns 400 for a malformed patch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/0", strings.NewReader(`[{"op":"frobnicate","path":"/name"}]`))
+		req.Header.Set("Content-Type", jsonpatch.JSONPatchContentType)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+	t.Run("returns 415 for other patch formats", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/0", strings.NewReader(`name: pear`))
+		req.Header.Set("Content-Type", "application/yaml")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Result().StatusCode)
+	})
+}
+
+func TestProductHandler_ContentNegotiation(t *testing.T) {
+	uc := NewMockProductUsecase(map[int64]*product.Product{
+		0: {
+			ID:    0,
+			Name:  "orange book",
+			Slug:  "orange-book",
+			Price: 500,
+		},
+	})
+	p := newTestRouter(uc)
+	t.Run("encodes xml", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orange-book", nil)
+		req.Header.Set("Accept", "application/xml")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assertContentType(t, res, "application/xml")
+		var found product.Product
+		assert.NoError(t, xml.NewDecoder(res.Body).Decode(&found))
+		assert.Equal(t, "orange book", found.Name)
+	})
+	t.Run("encodes protobuf and msgpack", func(t *testing.T) {
+		for _, c := range []codec.Codec{codec.Protobuf{}, codec.MessagePack{}} {
+			req := httptest.NewRequest(http.MethodGet, "/orange-book", nil)
+			req.Header.Set("Accept", c.ContentType())
+			res := httptest.NewRecorder()
+			p.ServeHTTP(res, req)
+			assertContentType(t, res, c.ContentType())
+			var found product.Product
+			assert.NoError(t, c.Decode(res.Body, &found))
+			assert.Equal(t, 500, found.Price)
+		}
+	})
+	t.Run("prefers the highest quality", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orange-book", nil)
+		req.Header.Set("Accept", "application/json;q=0.5, application/msgpack")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assertContentType(t, res, "application/msgpack")
+	})
+	t.Run("returns 406 for unsupported accept", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orange-book", nil)
+		req.Header.Set("Accept", "text/csv")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusNotAcceptable, res.Result().StatusCode)
+		assertContentType(t, res, "application/json")
+	})
+	t.Run("decodes msgpack bodies", func(t *testing.T) {
+		var body strings.Builder
+		assert.NoError(t, codec.MessagePack{}.Encode(&body, map[string]any{"name": "pear", "price": 7}))
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", "application/msgpack")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusCreated, res.Result().StatusCode)
+	})
+	t.Run("returns 415 for unsupported content type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`name=pear`))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Result().StatusCode)
+	})
+}
+
+func TestProductHandler_Routes(t *testing.T) {
+	uc := NewMockProductUsecase(map[int64]*product.Product{
+		0: {
+			ID:    0,
+			Name:  "orange book",
+			Slug:  "orange-book",
+			Price: 500,
+		},
+	})
+	p := newTestRouter(uc)
+	p.NotFound(rest.NotFound)
+	p.MethodNotAllowed(rest.MethodNotAllowed)
+	t.Run("patches the product by id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/0", strings.NewReader(`{"name": "lime book", "price": 300}`))
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "lime book", uc.Products[0].Name)
+	})
+	t.Run("answers head without a body", func(t *testing.T) {
+		uc.refreshDatabase()
+		req := httptest.NewRequest(http.MethodHead, "/orange-book", nil)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+	t.Run("lists the allowed methods", func(t *testing.T) {
+		cases := map[string]string{
+			"/":            "POST, OPTIONS",
+			"/orange-book": "GET, HEAD, OPTIONS",
+			"/5":           "GET, HEAD, PUT, PATCH, DELETE, OPTIONS",
+		}
+		for path, allow := range cases {
+			req := httptest.NewRequest(http.MethodOptions, path, nil)
+			res := httptest.NewRecorder()
+			p.ServeHTTP(res, req)
+			assert.Equal(t, http.StatusNoContent, res.Result().StatusCode)
+			assert.Equal(t, allow, res.Result().Header.Get("Allow"), path)
+		}
+	})
+	t.Run("returns 405 with the allowed methods", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/orange-book", nil)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, res.Result().StatusCode)
+		assert.Equal(t, "GET, HEAD, OPTIONS", res.Result().Header.Get("Allow"))
+		assertContentType(t, res, "application/json")
+		var body rest.HTTPError
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.Equal(t, http.StatusMethodNotAllowed, body.Code)
+	})
+	t.Run("returns 404 for unknown routes", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orange-book/reviews", nil)
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+		assertContentType(t, res, "application/json")
+	})
+}
+
+func newTestRouter(uc usecase.ProductUseCase) chi.Router {
+	r := chi.NewRouter()
+	NewProductHandler(uc, r)
+	return r
+}
+
 func assertContentType(t testing.TB, response *httptest.ResponseRecorder, want string) {
 	t.Helper()
 	if response.Result().Header.Get("content-type") != want {
@@ -3,10 +3,13 @@ package product
 import "time"
 
 type Product struct {
-	ID        int64     `json:"-"`
-	Name      string    `json:"name" validate:"required"`
-	Slug      string    `json:"slug"`
-	Price     int       `json:"price" validate:"required,number"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int64     `json:"-" xml:"-"`
+	Name      string    `json:"name" xml:"name" validate:"required"`
+	Slug      string    `json:"slug" xml:"slug"`
+	Price     int       `json:"price" xml:"price" validate:"required,number"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+	// Version is bumped by every write, the concurrent updates are detected
+	// with it.
+	Version int64 `json:"-" xml:"-"`
 }
@@ -0,0 +1,116 @@
+package product
+
+import (
+	"errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"time"
+)
+
+var errMalformedProto = errors.New("malformed protobuf product")
+
+// MarshalProto encodes the product as described in product.proto.
+func (p *Product) MarshalProto() ([]byte, error) {
+	var b []byte
+	if p.Name != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, p.Name)
+	}
+	if p.Slug != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, p.Slug)
+	}
+	if p.Price != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.Price))
+	}
+	b = appendTimestamp(b, 5, p.CreatedAt)
+	b = appendTimestamp(b, 6, p.UpdatedAt)
+	return b, nil
+}
+
+// UnmarshalProto decodes a product encoded as described in product.proto,
+// unknown fields are skipped.
+func (p *Product) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+		switch {
+		case num == 2 && typ == protowire.BytesType:
+			p.Name, n = protowire.ConsumeString(b)
+		case num == 3 && typ == protowire.BytesType:
+			p.Slug, n = protowire.ConsumeString(b)
+		case num == 4 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			p.Price = int(int64(v))
+		case (num == 5 || num == 6) && typ == protowire.BytesType:
+			var ts []byte
+			ts, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				t, err := consumeTimestamp(ts)
+				if err != nil {
+					return err
+				}
+				if num == 5 {
+					p.CreatedAt = t
+				} else {
+					p.UpdatedAt = t
+				}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// appendTimestamp appends t as a google.protobuf.Timestamp message.
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(t.Unix()))
+	if nanos := t.Nanosecond(); nanos != 0 {
+		ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(nanos))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+func consumeTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, errMalformedProto
+		}
+		b = b[n:]
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		} else {
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			switch num {
+			case 1:
+				seconds = int64(v)
+			case 2:
+				nanos = int64(v)
+			}
+		}
+		if n < 0 {
+			return time.Time{}, errMalformedProto
+		}
+		b = b[n:]
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
@@ -0,0 +1,134 @@
+// Package breaker decorates the product repositories with circuit breakers.
+package breaker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-redis/redis/v9"
+	cb "github.com/halilylm/microservice/pkg/breaker"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"time"
+)
+
+type productRepository struct {
+	next    repository.ProductRepository
+	breaker *cb.Breaker
+}
+
+// NewProductRepository fails fast with cb.ErrOpen while the database keeps
+// failing. Missing rows and conflicts are answers of a healthy database and
+// don't count as failures.
+func NewProductRepository(next repository.ProductRepository, breaker *cb.Breaker) repository.ProductRepository {
+	return &productRepository{next: next, breaker: breaker}
+}
+
+func (r *productRepository) Insert(ctx context.Context, p *product.Product) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.Insert(ctx, p)
+	})
+}
+
+func (r *productRepository) Update(ctx context.Context, p *product.Product) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.Update(ctx, p)
+	})
+}
+
+func (r *productRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.CompareAndUpdate(ctx, current, next)
+	})
+}
+
+func (r *productRepository) Delete(ctx context.Context, id int64) error {
+	_, err := callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return nil, r.next.Delete(ctx, id)
+	})
+	return err
+}
+
+func (r *productRepository) GetProductBySlug(ctx context.Context, slug string) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.GetProductBySlug(ctx, slug)
+	})
+}
+
+func (r *productRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.GetProductByID(ctx, id)
+	})
+}
+
+func (r *productRepository) GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error) {
+	var products []*product.Product
+	_, err := callProduct(ctx, r.breaker, isDatabaseAnswer, func(ctx context.Context) (_ *product.Product, err error) {
+		products, err = r.next.GetProductsByIDs(ctx, ids)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+type productCacheRepository struct {
+	next    repository.ProductCacheRepository
+	breaker *cb.Breaker
+}
+
+// NewProductCacheRepository skips the cache while it keeps failing, so slow
+// redis calls don't delay the fallback to the database. Cache misses don't
+// count as failures.
+func NewProductCacheRepository(next repository.ProductCacheRepository, breaker *cb.Breaker) repository.ProductCacheRepository {
+	return &productCacheRepository{next: next, breaker: breaker}
+}
+
+func (r *productCacheRepository) SetProduct(ctx context.Context, key string, expire time.Duration, p *product.Product) error {
+	_, err := callProduct(ctx, r.breaker, isCacheAnswer, func(ctx context.Context) (*product.Product, error) {
+		return nil, r.next.SetProduct(ctx, key, expire, p)
+	})
+	return err
+}
+
+func (r *productCacheRepository) DeleteProduct(ctx context.Context, key string) error {
+	_, err := callProduct(ctx, r.breaker, isCacheAnswer, func(ctx context.Context) (*product.Product, error) {
+		return nil, r.next.DeleteProduct(ctx, key)
+	})
+	return err
+}
+
+func (r *productCacheRepository) GetProduct(ctx context.Context, key string) (*product.Product, error) {
+	return callProduct(ctx, r.breaker, isCacheAnswer, func(ctx context.Context) (*product.Product, error) {
+		return r.next.GetProduct(ctx, key)
+	})
+}
+
+// callProduct runs fn through the breaker, errors for which isAnswer is true
+// are returned to the caller but recorded as successes.
+func callProduct(ctx context.Context, breaker *cb.Breaker, isAnswer func(error) bool, fn func(ctx context.Context) (*product.Product, error)) (*product.Product, error) {
+	var (
+		p      *product.Product
+		result error
+	)
+	err := breaker.Do(ctx, func(ctx context.Context) error {
+		p, result = fn(ctx)
+		if isAnswer(result) {
+			return nil
+		}
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, result
+}
+
+func isDatabaseAnswer(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrSlugTaken)
+}
+
+func isCacheAnswer(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
@@ -0,0 +1,87 @@
+package breaker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-redis/redis/v9"
+	cb "github.com/halilylm/microservice/pkg/breaker"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// failingRepository answers every read with err.
+type failingRepository struct {
+	repository.ProductRepository
+	err   error
+	calls int
+}
+
+func (r *failingRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	r.calls++
+	return nil, r.err
+}
+
+type failingCache struct {
+	repository.ProductCacheRepository
+	err   error
+	calls int
+}
+
+func (r *failingCache) GetProduct(ctx context.Context, key string) (*product.Product, error) {
+	r.calls++
+	return nil, r.err
+}
+
+func TestProductRepository(t *testing.T) {
+	t.Run("fails fast once the database keeps failing", func(t *testing.T) {
+		next := &failingRepository{err: errors.New("connection refused")}
+		repo := NewProductRepository(next, cb.New(cb.Options{MinRequests: 2, OpenTimeout: time.Minute}))
+		for i := 0; i < 2; i++ {
+			_, err := repo.GetProductByID(context.TODO(), 1)
+			assert.EqualError(t, err, "connection refused")
+		}
+		_, err := repo.GetProductByID(context.TODO(), 1)
+		assert.ErrorIs(t, err, cb.ErrOpen)
+		assert.Equal(t, 2, next.calls)
+	})
+	t.Run("missing rows don't open the breaker", func(t *testing.T) {
+		next := &failingRepository{err: sql.ErrNoRows}
+		breaker := cb.New(cb.Options{MinRequests: 1})
+		repo := NewProductRepository(next, breaker)
+		for i := 0; i < 3; i++ {
+			_, err := repo.GetProductByID(context.TODO(), 1)
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+		assert.Equal(t, cb.StateClosed, breaker.State())
+	})
+	t.Run("passes results through", func(t *testing.T) {
+		next := repository.NewMockProductRepository(map[int64]*product.Product{1: {ID: 1, Name: "lemon"}})
+		repo := NewProductRepository(next, cb.New(cb.Options{}))
+		found, err := repo.GetProductByID(context.TODO(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "lemon", found.Name)
+	})
+}
+
+func TestProductCacheRepository(t *testing.T) {
+	t.Run("skips the cache once it keeps failing", func(t *testing.T) {
+		next := &failingCache{err: context.DeadlineExceeded}
+		repo := NewProductCacheRepository(next, cb.New(cb.Options{MinRequests: 1, OpenTimeout: time.Minute}))
+		_, err := repo.GetProduct(context.TODO(), "lemon")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		_, err = repo.GetProduct(context.TODO(), "lemon")
+		assert.ErrorIs(t, err, cb.ErrOpen)
+		assert.Equal(t, 1, next.calls)
+	})
+	t.Run("cache misses don't open the breaker", func(t *testing.T) {
+		breaker := cb.New(cb.Options{MinRequests: 1})
+		repo := NewProductCacheRepository(&failingCache{err: redis.Nil}, breaker)
+		_, err := repo.GetProduct(context.TODO(), "lemon")
+		assert.ErrorIs(t, err, redis.Nil)
+		assert.Equal(t, cb.StateClosed, breaker.State())
+	})
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/halilylm/microservice/pkg/codec"
+	"github.com/halilylm/microservice/product"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Codec encodes the products inside the cache envelope. Every entry records
+// its codec, so changing it keeps the cached entries readable.
+type Codec byte
+
+const (
+	CodecJSON Codec = iota + 1
+	CodecMessagePack
+	CodecGob
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecJSON:
+		return "json"
+	case CodecMessagePack:
+		return "msgpack"
+	case CodecGob:
+		return "gob"
+	}
+	return "codec(" + strconv.Itoa(int(c)) + ")"
+}
+
+// An envelope is a header followed by the encoded product:
+//
+//	magic | schema version | codec | flags | crc32 of the payload (big endian)
+const (
+	envelopeMagic byte = 0xb7
+	// schemaVersion is the version of cachedProduct, bump it on every
+	// incompatible change so the old entries are read as misses.
+	schemaVersion  byte = 1
+	headerSize          = 8
+	flagCompressed byte = 1 << 0
+)
+
+var (
+	errNotEnvelope   = errors.New("not a product cache envelope")
+	errSchemaVersion = errors.New("product cache entry of another schema version")
+	errChecksum      = errors.New("product cache entry checksum mismatch")
+	errUnknownCodec  = errors.New("product cache entry of an unknown codec")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// cachedProduct is the cached form of a product, unlike product.Product its
+// id is encoded.
+type cachedProduct struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Price     int       `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// encodeEnvelope encodes p with c, the payload is compressed when it is
+// longer than compressAbove, zero disables the compression.
+func encodeEnvelope(p *product.Product, c Codec, compressAbove int) ([]byte, error) {
+	dto := cachedProduct{ID: p.ID, Name: p.Name, Slug: p.Slug, Price: p.Price, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt}
+	var payload bytes.Buffer
+	var err error
+	switch c {
+	case CodecJSON:
+		err = codec.JSON{}.Encode(&payload, &dto)
+	case CodecMessagePack:
+		err = codec.MessagePack{}.Encode(&payload, &dto)
+	case CodecGob:
+		err = gob.NewEncoder(&payload).Encode(&dto)
+	default:
+		return nil, errUnknownCodec
+	}
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	body := payload.Bytes()
+	if compressAbove > 0 && len(body) > compressAbove {
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = compressed.Bytes()
+		flags |= flagCompressed
+	}
+	b := make([]byte, headerSize, headerSize+len(body))
+	b[0], b[1], b[2], b[3] = envelopeMagic, schemaVersion, byte(c), flags
+	binary.BigEndian.PutUint32(b[4:headerSize], crc32.Checksum(body, castagnoli))
+	return append(b, body...), nil
+}
+
+// decodeEnvelope returns the product of b, or an error when b is not an
+// intact envelope of the current schema.
+func decodeEnvelope(b []byte) (*product.Product, error) {
+	if len(b) < headerSize || b[0] != envelopeMagic {
+		return nil, errNotEnvelope
+	}
+	if b[1] != schemaVersion {
+		return nil, errSchemaVersion
+	}
+	c, flags, body := Codec(b[2]), b[3], b[headerSize:]
+	if crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(b[4:headerSize]) {
+		return nil, errChecksum
+	}
+	var r io.Reader = bytes.NewReader(body)
+	if flags&flagCompressed != 0 {
+		fr := flate.NewReader(r)
+		defer fr.Close()
+		r = fr
+	}
+	var dto cachedProduct
+	var err error
+	switch c {
+	case CodecJSON:
+		err = codec.JSON{}.Decode(r, &dto)
+	case CodecMessagePack:
+		err = codec.MessagePack{}.Decode(r, &dto)
+	case CodecGob:
+		err = gob.NewDecoder(r).Decode(&dto)
+	default:
+		return nil, errUnknownCodec
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s product cache entry: %w", c, err)
+	}
+	return &product.Product{ID: dto.ID, Name: dto.Name, Slug: dto.Slug, Price: dto.Price, CreatedAt: dto.CreatedAt, UpdatedAt: dto.UpdatedAt}, nil
+}
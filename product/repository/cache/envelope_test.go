@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/product"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+var cachedLemon = product.Product{
+	ID:        7,
+	Name:      "red lemon",
+	Slug:      "red-lemon",
+	Price:     5,
+	CreatedAt: time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC),
+	UpdatedAt: time.Date(2022, 12, 2, 10, 0, 0, 0, time.UTC),
+}
+
+func assertSameProduct(t *testing.T, want, got *product.Product) {
+	t.Helper()
+	assert.Equal(t, want.ID, got.ID)
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.Slug, got.Slug)
+	assert.Equal(t, want.Price, got.Price)
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt))
+	assert.True(t, want.UpdatedAt.Equal(got.UpdatedAt))
+}
+
+func TestEnvelope(t *testing.T) {
+	for _, c := range []Codec{CodecJSON, CodecMessagePack, CodecGob} {
+		t.Run(c.String()+" keeps the id", func(t *testing.T) {
+			b, err := encodeEnvelope(&cachedLemon, c, 0)
+			assert.NoError(t, err)
+			got, err := decodeEnvelope(b)
+			assert.NoError(t, err)
+			assertSameProduct(t, &cachedLemon, got)
+		})
+	}
+	t.Run("compresses above the threshold", func(t *testing.T) {
+		long := cachedLemon
+		long.Name = strings.Repeat("lemon ", 100)
+		small, err := encodeEnvelope(&long, CodecJSON, 0)
+		assert.NoError(t, err)
+		compressed, err := encodeEnvelope(&long, CodecJSON, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, flagCompressed, compressed[3])
+		assert.Less(t, len(compressed), len(small))
+		got, err := decodeEnvelope(compressed)
+		assert.NoError(t, err)
+		assertSameProduct(t, &long, got)
+		short, err := encodeEnvelope(&cachedLemon, CodecJSON, 1024)
+		assert.NoError(t, err)
+		assert.Zero(t, short[3])
+	})
+	t.Run("rejects damaged and foreign entries", func(t *testing.T) {
+		b, err := encodeEnvelope(&cachedLemon, CodecMessagePack, 0)
+		assert.NoError(t, err)
+		corrupted := append([]byte(nil), b...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		_, err = decodeEnvelope(corrupted)
+		assert.ErrorIs(t, err, errChecksum)
+		old := append([]byte(nil), b...)
+		old[1] = schemaVersion - 1
+		_, err = decodeEnvelope(old)
+		assert.ErrorIs(t, err, errSchemaVersion)
+		legacy, _ := json.Marshal(cachedLemon)
+		_, err = decodeEnvelope(legacy)
+		assert.ErrorIs(t, err, errNotEnvelope)
+		_, err = decodeEnvelope(b[:3])
+		assert.ErrorIs(t, err, errNotEnvelope)
+	})
+}
+
+func TestProductRepository_Envelope(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Run("reads the entries of another codec", func(t *testing.T) {
+		writer := NewProductRepository(client, WithCodec(CodecGob), WithCompression(16))
+		assert.NoError(t, writer.SetProduct(context.TODO(), "red-lemon", time.Minute, &cachedLemon))
+		got, err := NewProductRepository(client).GetProduct(context.TODO(), "red-lemon")
+		assert.NoError(t, err)
+		assertSameProduct(t, &cachedLemon, got)
+	})
+	t.Run("unreadable entries are misses", func(t *testing.T) {
+		legacy, _ := json.Marshal(cachedLemon)
+		assert.NoError(t, mr.Set("legacy", string(legacy)))
+		_, err := NewProductRepository(client).GetProduct(context.TODO(), "legacy")
+		assert.ErrorIs(t, err, redis.Nil)
+	})
+}
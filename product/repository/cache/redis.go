@@ -2,23 +2,58 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/pkg/logctx"
 	"github.com/halilylm/microservice/product"
 	"github.com/halilylm/microservice/product/repository"
+	"go.uber.org/zap"
 	"time"
 )
 
 type productRepository struct {
-	client *redis.Client
+	client        redis.UniversalClient
+	codec         Codec
+	compressAbove int
+	logger        *zap.Logger
 }
 
-func NewProductRepository(client *redis.Client) repository.ProductCacheRepository {
-	return &productRepository{client: client}
+type Option func(*productRepository)
+
+// WithCodec replaces MessagePack, the default codec of the new entries.
+func WithCodec(c Codec) Option {
+	return func(r *productRepository) {
+		r.codec = c
+	}
+}
+
+// WithCompression compresses the entries whose encoded product is longer
+// than threshold bytes.
+func WithCompression(threshold int) Option {
+	return func(r *productRepository) {
+		r.compressAbove = threshold
+	}
+}
+
+// WithLogger logs the entries that can't be read at debug level.
+func WithLogger(logger *zap.Logger) Option {
+	return func(r *productRepository) {
+		r.logger = logger
+	}
+}
+
+// NewProductRepository caches the products in a versioned envelope. An entry
+// that can't be read, because it is corrupted or was written by another
+// schema version, is a miss and reported as redis.Nil.
+func NewProductRepository(client redis.UniversalClient, opts ...Option) repository.ProductCacheRepository {
+	r := &productRepository{client: client, codec: CodecMessagePack, logger: zap.NewNop()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *productRepository) SetProduct(ctx context.Context, key string, expire time.Duration, product *product.Product) error {
-	productBytes, err := json.Marshal(product)
+	productBytes, err := encodeEnvelope(product, r.codec, r.compressAbove)
 	if err != nil {
 		return err
 	}
@@ -40,9 +75,11 @@ func (r *productRepository) GetProduct(ctx context.Context, key string) (*produc
 	if err != nil {
 		return nil, err
 	}
-	var product product.Product
-	if err := json.Unmarshal(productBytes, &product); err != nil {
-		return nil, err
+	product, err := decodeEnvelope(productBytes)
+	if err != nil {
+		// the entry is replaced once the product is read from the database
+		logctx.From(ctx, r.logger).Debug("unreadable product cache entry", zap.String("key", key), zap.Error(err))
+		return nil, redis.Nil
 	}
-	return &product, nil
+	return product, nil
 }
@@ -2,7 +2,7 @@ package cache
 
 import (
 	"context"
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v9"
 	"github.com/google/uuid"
 	"github.com/halilylm/microservice/product"
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	product product.Product
+	// expiresAt is zero for entries that don't expire
+	expiresAt time.Time
+}
+
+// ProductCacheRepository expires the products like redis: an expired entry
+// is a miss as soon as its time is up, and Run frees the memory of the
+// entries that are not read again.
+type ProductCacheRepository struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+func NewProductCacheRepository() *ProductCacheRepository {
+	return &ProductCacheRepository{entries: make(map[string]cacheEntry), now: time.Now}
+}
+
+var _ repository.ProductCacheRepository = (*ProductCacheRepository)(nil)
+
+// SetProduct caches a copy of p, a zero expire keeps it until it is deleted.
+func (c *ProductCacheRepository) SetProduct(ctx context.Context, key string, expire time.Duration, p *product.Product) error {
+	entry := cacheEntry{product: *p}
+	if expire > 0 {
+		entry.expiresAt = c.now().Add(expire)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *ProductCacheRepository) DeleteProduct(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *ProductCacheRepository) GetProduct(ctx context.Context, key string) (*product.Product, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, repository.ErrCacheMiss
+	}
+	if entry.expired(c.now()) {
+		delete(c.entries, key)
+		return nil, repository.ErrCacheMiss
+	}
+	p := entry.product
+	return &p, nil
+}
+
+// Run removes the expired entries every interval until ctx is done.
+func (c *ProductCacheRepository) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *ProductCacheRepository) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
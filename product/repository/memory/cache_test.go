@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProductCacheRepository(t *testing.T) {
+	now := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	c := NewProductCacheRepository()
+	c.now = func() time.Time {
+		return now
+	}
+	p := &product.Product{ID: 1, Name: "red lemon", Slug: "red-lemon", Price: 5}
+	t.Run("returns copies", func(t *testing.T) {
+		assert.NoError(t, c.SetProduct(context.TODO(), "red-lemon", 10*time.Second, p))
+		p.Price = 100
+		got, err := c.GetProduct(context.TODO(), "red-lemon")
+		assert.NoError(t, err)
+		assert.Equal(t, 5, got.Price)
+		assert.EqualValues(t, 1, got.ID)
+	})
+	t.Run("expires entries", func(t *testing.T) {
+		assert.NoError(t, c.SetProduct(context.TODO(), "forever", 0, p))
+		now = now.Add(10 * time.Second)
+		_, err := c.GetProduct(context.TODO(), "red-lemon")
+		assert.ErrorIs(t, err, repository.ErrCacheMiss)
+		_, err = c.GetProduct(context.TODO(), "forever")
+		assert.NoError(t, err)
+	})
+	t.Run("sweeps expired entries", func(t *testing.T) {
+		assert.NoError(t, c.SetProduct(context.TODO(), "lime", time.Second, p))
+		now = now.Add(time.Second)
+		c.sweep()
+		assert.Len(t, c.entries, 1)
+	})
+	t.Run("deletes entries", func(t *testing.T) {
+		assert.NoError(t, c.DeleteProduct(context.TODO(), "forever"))
+		_, err := c.GetProduct(context.TODO(), "forever")
+		assert.ErrorIs(t, err, repository.ErrCacheMiss)
+	})
+}
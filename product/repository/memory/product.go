@@ -0,0 +1,240 @@
+// Package memory keeps the products in the process, for local development
+// and tests. Everything is copied in and out so callers can't change the
+// stored products.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ProductRepository struct {
+	mu       sync.RWMutex
+	byID     map[int64]*product.Product
+	bySlug   map[string]int64
+	nextID   int64
+	snapshot string
+	now      func() time.Time
+}
+
+type Option func(*ProductRepository)
+
+// WithSnapshot makes Load and Save read and write the products as json at
+// path.
+func WithSnapshot(path string) Option {
+	return func(r *ProductRepository) {
+		r.snapshot = path
+	}
+}
+
+func NewProductRepository(opts ...Option) *ProductRepository {
+	r := &ProductRepository{
+		byID:   make(map[int64]*product.Product),
+		bySlug: make(map[string]int64),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+var _ repository.ProductRepository = (*ProductRepository)(nil)
+
+func (r *ProductRepository) Insert(ctx context.Context, p *product.Product) (*product.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bySlug[p.Slug]; ok {
+		return nil, repository.ErrSlugTaken
+	}
+	r.nextID++
+	p.ID = r.nextID
+	p.CreatedAt = r.now().UTC()
+	p.UpdatedAt = p.CreatedAt
+	p.Version = 1
+	r.put(p)
+	return p, nil
+}
+
+func (r *ProductRepository) Update(ctx context.Context, p *product.Product) (*product.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[p.ID]
+	if !ok {
+		return nil, errors.New("no update operated")
+	}
+	p.Slug = stored.Slug
+	p.CreatedAt = stored.CreatedAt
+	p.UpdatedAt = r.now().UTC()
+	p.Version = stored.Version + 1
+	r.put(p)
+	return p, nil
+}
+
+func (r *ProductRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[current.ID]
+	if !ok || stored.Version != current.Version {
+		return nil, repository.ErrConflict
+	}
+	if current.Name == next.Name && current.Price == next.Price {
+		next.UpdatedAt = stored.UpdatedAt
+		next.Version = stored.Version
+		return next, nil
+	}
+	next.ID = stored.ID
+	next.Slug = stored.Slug
+	next.CreatedAt = stored.CreatedAt
+	next.UpdatedAt = r.now().UTC()
+	next.Version = stored.Version + 1
+	r.put(next)
+	return next, nil
+}
+
+func (r *ProductRepository) Delete(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[id]
+	if !ok {
+		return errors.New("no update operated")
+	}
+	delete(r.byID, id)
+	delete(r.bySlug, stored.Slug)
+	return nil
+}
+
+func (r *ProductRepository) GetProductBySlug(ctx context.Context, slug string) (*product.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.bySlug[slug]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return r.get(id)
+}
+
+func (r *ProductRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(id)
+}
+
+func (r *ProductRepository) GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	products := make([]*product.Product, 0, len(ids))
+	for _, id := range ids {
+		if p, err := r.get(id); err == nil {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+// get returns a copy of the product, the caller holds the lock.
+func (r *ProductRepository) get(id int64) (*product.Product, error) {
+	stored, ok := r.byID[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	p := *stored
+	return &p, nil
+}
+
+// put stores a copy of p and indexes it, the caller holds the lock.
+func (r *ProductRepository) put(p *product.Product) {
+	stored := *p
+	r.byID[p.ID] = &stored
+	r.bySlug[p.Slug] = p.ID
+}
+
+// snapshot is the json file of the products, the id of the product is not
+// part of its json.
+type snapshot struct {
+	NextID   int64             `json:"next_id"`
+	Products []snapshotProduct `json:"products"`
+}
+
+type snapshotProduct struct {
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+	product.Product
+}
+
+// Load replaces the products with the snapshot, a missing snapshot leaves
+// them empty.
+func (r *ProductRepository) Load() error {
+	if r.snapshot == "" {
+		return nil
+	}
+	b, err := os.ReadFile(r.snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID = make(map[int64]*product.Product, len(s.Products))
+	r.bySlug = make(map[string]int64, len(s.Products))
+	r.nextID = s.NextID
+	for _, sp := range s.Products {
+		p := sp.Product
+		p.ID = sp.ID
+		p.Version = sp.Version
+		r.put(&p)
+		if p.ID > r.nextID {
+			r.nextID = p.ID
+		}
+	}
+	return nil
+}
+
+// Save writes the snapshot, the previous one is replaced only once the new
+// one is complete.
+func (r *ProductRepository) Save() error {
+	if r.snapshot == "" {
+		return nil
+	}
+	r.mu.RLock()
+	s := snapshot{NextID: r.nextID, Products: make([]snapshotProduct, 0, len(r.byID))}
+	for id, p := range r.byID {
+		s.Products = append(s.Products, snapshotProduct{ID: id, Version: p.Version, Product: *p})
+	}
+	r.mu.RUnlock()
+	sort.Slice(s.Products, func(i, j int) bool {
+		return s.Products[i].ID < s.Products[j].ID
+	})
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.snapshot), filepath.Base(r.snapshot)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.snapshot)
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/halilylm/microservice/product/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestProductRepository_Contract(t *testing.T) {
+	repositorytest.TestProductRepository(t, func(t *testing.T) repository.ProductRepository {
+		return NewProductRepository()
+	})
+}
+
+func TestProductRepository_CopyOnRead(t *testing.T) {
+	r := NewProductRepository()
+	p := &product.Product{Name: "red lemon", Slug: "red-lemon", Price: 5}
+	_, err := r.Insert(context.TODO(), p)
+	assert.NoError(t, err)
+	p.Price = 100
+	read, err := r.GetProductBySlug(context.TODO(), "red-lemon")
+	assert.NoError(t, err)
+	read.Name = "changed"
+	again, err := r.GetProductByID(context.TODO(), read.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "red lemon", again.Name)
+	assert.Equal(t, 5, again.Price)
+}
+
+func TestProductRepository_Concurrency(t *testing.T) {
+	r := NewProductRepository()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// one of them gets the slug
+			if p, err := r.Insert(context.TODO(), &product.Product{Name: "lime", Slug: "lime", Price: 1}); err == nil {
+				_, _ = r.Update(context.TODO(), &product.Product{ID: p.ID, Name: "lime", Price: 2})
+			}
+			_, _ = r.GetProductBySlug(context.TODO(), "lime")
+		}()
+	}
+	wg.Wait()
+	p, err := r.GetProductBySlug(context.TODO(), "lime")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, p.ID)
+}
+
+func TestProductRepository_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	r := NewProductRepository(WithSnapshot(path))
+	assert.NoError(t, r.Load(), "a missing snapshot is empty")
+	first, _ := r.Insert(context.TODO(), &product.Product{Name: "red lemon", Slug: "red-lemon", Price: 5})
+	second, _ := r.Insert(context.TODO(), &product.Product{Name: "lime", Slug: "lime", Price: 2})
+	assert.NoError(t, r.Delete(context.TODO(), second.ID))
+	assert.NoError(t, r.Save())
+
+	loaded := NewProductRepository(WithSnapshot(path))
+	assert.NoError(t, loaded.Load())
+	got, err := loaded.GetProductBySlug(context.TODO(), "red-lemon")
+	assert.NoError(t, err)
+	assert.Equal(t, first, got)
+	// ids are not reused after a restart
+	third, err := loaded.Insert(context.TODO(), &product.Product{Name: "lime", Slug: "lime", Price: 2})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, third.ID)
+}
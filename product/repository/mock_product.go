@@ -50,6 +50,18 @@ func (mpr *MockProductRepository) Update(ctx context.Context, p *product.Product
 	return p, nil
 }
 
+func (mpr *MockProductRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	mpr.Lock()
+	defer mpr.Unlock()
+	stored, ok := mpr.products[current.ID]
+	if !ok || stored.Version != current.Version {
+		return nil, ErrConflict
+	}
+	next.Version = current.Version + 1
+	mpr.products[next.ID] = next
+	return next, nil
+}
+
 func (mpr *MockProductRepository) Delete(ctx context.Context, id int64) error {
 	mpr.Lock()
 	defer mpr.Unlock()
@@ -70,3 +82,24 @@ func (mpr *MockProductRepository) GetProductBySlug(ctx context.Context, slug str
 	}
 	return nil, sql.ErrNoRows
 }
+
+func (mpr *MockProductRepository) GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error) {
+	mpr.Lock()
+	defer mpr.Unlock()
+	products := make([]*product.Product, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := mpr.products[id]; ok {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+func (mpr *MockProductRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	mpr.Lock()
+	defer mpr.Unlock()
+	if p, ok := mpr.products[id]; ok {
+		return p, nil
+	}
+	return nil, sql.ErrNoRows
+}
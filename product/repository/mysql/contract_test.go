@@ -0,0 +1,45 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/halilylm/microservice/product/repository/repositorytest"
+	"os"
+	"testing"
+)
+
+const createTableQuery = `CREATE TABLE IF NOT EXISTS products (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	slug VARCHAR(255) NOT NULL UNIQUE,
+	price INT NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	version BIGINT NOT NULL DEFAULT 1
+)`
+
+// TestProductRepository_Contract runs against the database of MYSQL_TEST_DSN,
+// e.g. root:secret@tcp(localhost:3306)/products_test?parseTime=true
+func TestProductRepository_Contract(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_TEST_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(createTableQuery); err != nil {
+		t.Fatal(err)
+	}
+	repositorytest.TestProductRepository(t, func(t *testing.T) repository.ProductRepository {
+		if _, err := db.Exec(`TRUNCATE TABLE products`); err != nil {
+			t.Fatal(err)
+		}
+		return NewProductRepository(db)
+	})
+}
@@ -4,46 +4,97 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/logctx"
 	"github.com/halilylm/microservice/product"
 	"github.com/halilylm/microservice/product/repository"
+	"go.uber.org/zap"
+	"strings"
+	"time"
 )
 
+const mysqlErrDuplicateEntry = 1062
+
 const (
-	insertQuery    = `INSERT products SET name=?, slug=?, price=?, created_at=now(), updated_at=now()`
-	updateQuery    = `UPDATE products SET name=?, price=?, updated_at=now() WHERE id=?`
-	deleteQuery    = `DELETE FROM products WHERE id=?`
-	getBySlugQuery = `SELECT id, name, slug, price, created_at, updated_at FROM products WHERE slug=?`
+	insertQuery = `INSERT products SET name=?, slug=?, price=?, version=1, created_at=now(), updated_at=now()`
+	updateQuery = `UPDATE products SET name=?, price=?, version=version+1, updated_at=now() WHERE id=?`
+	deleteQuery = `DELETE FROM products WHERE id=?`
+	// compareAndUpdateQuery only matches the row while it still has the
+	// version the caller read
+	compareAndUpdateQuery = `UPDATE products SET name=?, price=?, version=version+1, updated_at=? WHERE id=? AND version=?`
+	getBySlugQuery        = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE slug=?`
+	getByIDQuery          = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE id=?`
+	// getByIDsQuery is completed with the placeholders of the ids
+	getByIDsQuery = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE id IN (%s)`
 )
 
 type productRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	router *database.Router
+	retry  database.RetryPolicy
+	now    func() time.Time
+}
+
+type Option func(*productRepository)
+
+// WithRetry replaces the default retry policy, database.NoRetry disables it.
+func WithRetry(policy database.RetryPolicy) Option {
+	return func(r *productRepository) {
+		r.retry = policy
+	}
 }
 
-func NewProductRepository(db *sql.DB) repository.ProductRepository {
-	return &productRepository{db: db}
+// WithRouter sends the slug lookups to the replicas of router and the writes
+// to its primary. GetProductByID always reads the primary, it is the read of
+// a read-modify-write.
+func WithRouter(router *database.Router) Option {
+	return func(r *productRepository) {
+		r.router = router
+	}
+}
+
+// NewProductRepository retries transient errors of the reads. The writes may
+// have taken effect before the connection broke, and a repeated update
+// affects no row, so they are not retried.
+func NewProductRepository(db *sql.DB, opts ...Option) repository.ProductRepository {
+	r := &productRepository{db: db, now: time.Now}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *productRepository) Insert(ctx context.Context, p *product.Product) (*product.Product, error) {
-	stmt, err := r.db.PrepareContext(ctx, insertQuery)
+	stmt, err := r.writer(ctx).PrepareContext(ctx, insertQuery)
 	if err != nil {
 		return nil, err
 	}
-	res, err := stmt.ExecContext(ctx, p.Name, p.Price)
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, p.Name, p.Slug, p.Price)
 	if err != nil {
+		// slug is the only unique key besides the id
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			logctx.From(ctx, nil).Debug("product slug taken", zap.String("slug", p.Slug))
+			return nil, repository.ErrSlugTaken
+		}
 		return nil, err
 	}
 	p.ID, err = res.LastInsertId()
 	if err != nil {
 		return nil, err
 	}
+	p.Version = 1
 	return p, nil
 }
 
 func (r *productRepository) Update(ctx context.Context, p *product.Product) (*product.Product, error) {
-	stmt, err := r.db.PrepareContext(ctx, updateQuery)
+	stmt, err := r.writer(ctx).PrepareContext(ctx, updateQuery)
 	if err != nil {
 		return nil, err
 	}
+	defer stmt.Close()
 	res, err := stmt.ExecContext(ctx, p.Name, p.Price, p.ID)
 	if err != nil {
 		return nil, err
@@ -58,11 +109,38 @@ func (r *productRepository) Update(ctx context.Context, p *product.Product) (*pr
 	return p, nil
 }
 
+func (r *productRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	// nothing to write, updated_at is left alone
+	if current.Name == next.Name && current.Price == next.Price {
+		next.UpdatedAt = current.UpdatedAt
+		next.Version = current.Version
+		return next, nil
+	}
+	// the written updated_at is returned, it would be read back the same
+	now := r.now().UTC().Truncate(time.Second)
+	res, err := r.writer(ctx).ExecContext(ctx, compareAndUpdateQuery, next.Name, next.Price, now, current.ID, current.Version)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected != 1 {
+		logctx.From(ctx, nil).Debug("product changed since it was read", zap.Int64("id", current.ID))
+		return nil, repository.ErrConflict
+	}
+	next.UpdatedAt = now
+	next.Version = current.Version + 1
+	return next, nil
+}
+
 func (r *productRepository) Delete(ctx context.Context, id int64) error {
-	stmt, err := r.db.PrepareContext(ctx, deleteQuery)
+	stmt, err := r.writer(ctx).PrepareContext(ctx, deleteQuery)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 	res, err := stmt.ExecContext(ctx, id)
 	if err != nil {
 		return err
@@ -79,8 +157,68 @@ func (r *productRepository) Delete(ctx context.Context, id int64) error {
 
 func (r *productRepository) GetProductBySlug(ctx context.Context, slug string) (*product.Product, error) {
 	var product product.Product
-	if err := r.db.QueryRowContext(ctx, getBySlugQuery, slug).Scan(&product.ID, &product.Name, &product.Slug, &product.Price, &product.CreatedAt, &product.UpdatedAt); err != nil {
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		return r.reader(ctx).QueryRowContext(ctx, getBySlugQuery, slug).Scan(&product.ID, &product.Name, &product.Slug, &product.Price, &product.CreatedAt, &product.UpdatedAt, &product.Version)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
+
+func (r *productRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	var product product.Product
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		return r.db.QueryRowContext(ctx, getByIDQuery, id).Scan(&product.ID, &product.Name, &product.Slug, &product.Price, &product.CreatedAt, &product.UpdatedAt, &product.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (r *productRepository) GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error) {
+	if len(ids) == 0 {
+		return []*product.Product{}, nil
+	}
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := strings.Replace(getByIDsQuery, "%s", strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), 1)
+	var products []*product.Product
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		rows, err := r.reader(ctx).QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		products = make([]*product.Product, 0, len(ids))
+		for rows.Next() {
+			var p product.Product
+			if err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.Price, &p.CreatedAt, &p.UpdatedAt, &p.Version); err != nil {
+				return err
+			}
+			products = append(products, &p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repository.InOrderOf(ids, products), nil
+}
+
+func (r *productRepository) reader(ctx context.Context) *sql.DB {
+	if r.router == nil {
+		return r.db
+	}
+	return r.router.Reader(ctx)
+}
+
+func (r *productRepository) writer(ctx context.Context) *sql.DB {
+	if r.router == nil {
+		return r.db
+	}
+	return r.router.Writer(ctx)
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/halilylm/microservice/pkg/database"
 	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -13,6 +16,7 @@ import (
 func TestProductRepository_Insert(t *testing.T) {
 	newProduct := product.Product{
 		Name:  "watch",
+		Slug:  "watch",
 		Price: 15,
 	}
 	db, mock := createMockDB(t)
@@ -20,11 +24,17 @@ func TestProductRepository_Insert(t *testing.T) {
 		_ = db.Close()
 	}()
 	prep := mock.ExpectPrepare(insertQuery)
-	prep.ExpectExec().WithArgs(newProduct.Name, newProduct.Price).WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs(newProduct.Name, newProduct.Slug, newProduct.Price).WillReturnResult(sqlmock.NewResult(1, 1))
 	p := NewProductRepository(db)
 	prod, err := p.Insert(context.TODO(), &newProduct)
 	assert.NoError(t, err)
 	assert.EqualValues(t, 1, prod.ID)
+	t.Run("reports a taken slug", func(t *testing.T) {
+		prep := mock.ExpectPrepare(insertQuery)
+		prep.ExpectExec().WithArgs(newProduct.Name, newProduct.Slug, newProduct.Price).WillReturnError(&mysql.MySQLError{Number: 1062})
+		_, err := p.Insert(context.TODO(), &newProduct)
+		assert.ErrorIs(t, err, repository.ErrSlugTaken)
+	})
 }
 
 func TestProductRepository_GetProductBySlug(t *testing.T) {
@@ -34,8 +44,8 @@ func TestProductRepository_GetProductBySlug(t *testing.T) {
 	}()
 	createdAt := time.Now()
 	updatedAt := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "name", "slug", "price", "created_at", "updated_at"}).
-		AddRow(1, "red lemon", "red-lemon", 5, createdAt, updatedAt)
+	rows := sqlmock.NewRows([]string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}).
+		AddRow(1, "red lemon", "red-lemon", 5, createdAt, updatedAt, 1)
 	mock.ExpectQuery(getBySlugQuery).WillReturnRows(rows)
 	p := NewProductRepository(db)
 	slug := "red-lemon"
@@ -44,6 +54,39 @@ func TestProductRepository_GetProductBySlug(t *testing.T) {
 	assert.NotNil(t, prod)
 }
 
+func TestProductRepository_GetProductByID(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	rows := sqlmock.NewRows([]string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}).
+		AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1)
+	mock.ExpectQuery(getByIDQuery).WithArgs(7).WillReturnRows(rows)
+	p := NewProductRepository(db)
+	prod, err := p.GetProductByID(context.TODO(), 7)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, prod.ID)
+}
+
+func TestProductRepository_GetProductsByIDs(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	rows := sqlmock.NewRows([]string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}).
+		AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1).
+		AddRow(3, "lime", "lime", 3, time.Now(), time.Now(), 1)
+	mock.ExpectQuery(`SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE id IN (?,?,?)`).WithArgs(3, 9, 7).WillReturnRows(rows)
+	p := NewProductRepository(db)
+	products, err := p.GetProductsByIDs(context.TODO(), []int64{3, 9, 7})
+	assert.NoError(t, err)
+	if assert.Len(t, products, 2) {
+		assert.EqualValues(t, 3, products[0].ID)
+		assert.EqualValues(t, 7, products[1].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestProductRepository_Delete(t *testing.T) {
 	db, mock := createMockDB(t)
 	defer func() {
@@ -74,6 +117,92 @@ func TestProductRepository_Update(t *testing.T) {
 	assert.NotNil(t, updateProduct)
 }
 
+func TestProductRepository_CompareAndUpdate(t *testing.T) {
+	current := product.Product{ID: 1, Name: "banana", Price: 5, Version: 3}
+	next := product.Product{ID: 1, Name: "banana", Price: 7}
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &productRepository{db: db, now: func() time.Time { return now.Add(time.Millisecond) }}
+	t.Run("updates an unchanged product", func(t *testing.T) {
+		mock.ExpectExec(compareAndUpdateQuery).WithArgs("banana", 7, now, 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		updated, err := p.CompareAndUpdate(context.TODO(), &current, &next)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, updated.Price)
+		assert.Equal(t, now, updated.UpdatedAt)
+		assert.EqualValues(t, 4, updated.Version)
+	})
+	t.Run("reports a concurrent change", func(t *testing.T) {
+		mock.ExpectExec(compareAndUpdateQuery).WithArgs("banana", 7, now, 1, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		_, err := p.CompareAndUpdate(context.TODO(), &current, &next)
+		assert.ErrorIs(t, err, repository.ErrConflict)
+	})
+	t.Run("skips writing identical values", func(t *testing.T) {
+		_, err := p.CompareAndUpdate(context.TODO(), &current, &current)
+		assert.NoError(t, err)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductRepository_Retry(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	p := NewProductRepository(db, WithRetry(database.RetryPolicy{BaseDelay: time.Millisecond}))
+	t.Run("retries reads after a deadlock", func(t *testing.T) {
+		mock.ExpectQuery(getByIDQuery).WithArgs(7).WillReturnError(&mysql.MySQLError{Number: 1213})
+		mock.ExpectQuery(getByIDQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}).
+			AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+		prod, err := p.GetProductByID(context.TODO(), 7)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 7, prod.ID)
+	})
+	t.Run("does not retry updates after a broken connection", func(t *testing.T) {
+		prep := mock.ExpectPrepare(updateQuery).WillBeClosed()
+		prep.ExpectExec().WithArgs("banana", 5, 1).WillReturnError(mysql.ErrInvalidConn)
+		_, err := p.Update(context.TODO(), &product.Product{ID: 1, Name: "banana", Price: 5})
+		assert.ErrorIs(t, err, mysql.ErrInvalidConn)
+	})
+	t.Run("does not retry deletes after a broken connection", func(t *testing.T) {
+		prep := mock.ExpectPrepare(deleteQuery)
+		prep.ExpectExec().WithArgs(1).WillReturnError(mysql.ErrInvalidConn)
+		assert.ErrorIs(t, p.Delete(context.TODO(), 1), mysql.ErrInvalidConn)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductRepository_Router(t *testing.T) {
+	primary, primaryMock := createMockDB(t)
+	replica, replicaMock := createMockDB(t)
+	defer func() {
+		_ = primary.Close()
+		_ = replica.Close()
+	}()
+	router := database.NewRouter(primary, []database.Replica{{Name: "replica", DB: replica}}, database.RouterOptions{})
+	replicaMock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}).AddRow(0))
+	router.CheckReplicas(context.TODO())
+	p := NewProductRepository(primary, WithRouter(router))
+	columns := []string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}
+	ctx := database.NewSession(context.TODO())
+	replicaMock.ExpectQuery(getBySlugQuery).WithArgs("red-lemon").WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+	_, err := p.GetProductBySlug(ctx, "red-lemon")
+	assert.NoError(t, err)
+	primaryMock.ExpectQuery(getByIDQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+	_, err = p.GetProductByID(ctx, 1)
+	assert.NoError(t, err)
+	primaryMock.ExpectPrepare(deleteQuery).ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.Delete(ctx, 1))
+	// the session wrote, its reads go to the primary now
+	primaryMock.ExpectQuery(getBySlugQuery).WithArgs("red-lemon").WillReturnError(sql.ErrNoRows)
+	_, err = p.GetProductBySlug(ctx, "red-lemon")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+}
+
 func createMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
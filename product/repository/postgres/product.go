@@ -0,0 +1,176 @@
+// Package postgres stores the products in a postgres table:
+//
+//	CREATE TABLE products (
+//		id         bigserial PRIMARY KEY,
+//		name       text NOT NULL,
+//		slug       text NOT NULL UNIQUE,
+//		price      integer NOT NULL,
+//		created_at timestamptz NOT NULL,
+//		updated_at timestamptz NOT NULL,
+//		version    bigint NOT NULL DEFAULT 1
+//	);
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const (
+	// insertQuery returns no row when the slug is taken, a unique violation
+	// would abort the surrounding transaction
+	insertQuery = `INSERT INTO products (name, slug, price, created_at, updated_at, version) VALUES ($1, $2, $3, now(), now(), 1) ON CONFLICT (slug) DO NOTHING RETURNING id, created_at, updated_at, version`
+	updateQuery = `UPDATE products SET name=$1, price=$2, version=version+1, updated_at=now() WHERE id=$3 RETURNING slug, created_at, updated_at, version`
+	deleteQuery = `DELETE FROM products WHERE id=$1`
+	// compareAndUpdateQuery only matches the row while it still has the
+	// version the caller read
+	compareAndUpdateQuery = `UPDATE products SET name=$1, price=$2, version=version+1, updated_at=now() WHERE id=$3 AND version=$4 RETURNING slug, created_at, updated_at, version`
+	getBySlugQuery        = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE slug=$1`
+	getByIDQuery          = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE id=$1`
+	getByIDsQuery         = `SELECT id, name, slug, price, created_at, updated_at, version FROM products WHERE id = ANY($1)`
+)
+
+type productRepository struct {
+	db    *sql.DB
+	retry database.RetryPolicy
+}
+
+type Option func(*productRepository)
+
+// WithRetry replaces the default retry policy, database.NoRetry disables it.
+func WithRetry(policy database.RetryPolicy) Option {
+	return func(r *productRepository) {
+		r.retry = policy
+	}
+}
+
+// NewProductRepository retries transient errors of the reads. Like on mysql
+// the writes are not retried, they may have taken effect before the
+// connection broke. The timestamps are returned in UTC.
+func NewProductRepository(db *sql.DB, opts ...Option) repository.ProductRepository {
+	r := &productRepository{db: db}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *productRepository) Insert(ctx context.Context, p *product.Product) (*product.Product, error) {
+	err := r.db.QueryRowContext(ctx, insertQuery, p.Name, p.Slug, p.Price).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		logctx.From(ctx, nil).Debug("product slug taken", zap.String("slug", p.Slug))
+		return nil, repository.ErrSlugTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	inUTC(p)
+	return p, nil
+}
+
+func (r *productRepository) Update(ctx context.Context, p *product.Product) (*product.Product, error) {
+	// a missing product is reported as sql.ErrNoRows
+	err := r.db.QueryRowContext(ctx, updateQuery, p.Name, p.Price, p.ID).Scan(&p.Slug, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	inUTC(p)
+	return p, nil
+}
+
+func (r *productRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	// nothing to write, updated_at is left alone like on mysql
+	if current.Name == next.Name && current.Price == next.Price {
+		next.UpdatedAt = current.UpdatedAt
+		next.Version = current.Version
+		return next, nil
+	}
+	err := r.db.QueryRowContext(ctx, compareAndUpdateQuery, next.Name, next.Price, current.ID, current.Version).
+		Scan(&next.Slug, &next.CreatedAt, &next.UpdatedAt, &next.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		logctx.From(ctx, nil).Debug("product changed since it was read", zap.Int64("id", current.ID))
+		return nil, repository.ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	inUTC(next)
+	return next, nil
+}
+
+func (r *productRepository) Delete(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, deleteQuery, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *productRepository) GetProductBySlug(ctx context.Context, slug string) (*product.Product, error) {
+	return r.get(ctx, getBySlugQuery, slug)
+}
+
+func (r *productRepository) GetProductByID(ctx context.Context, id int64) (*product.Product, error) {
+	return r.get(ctx, getByIDQuery, id)
+}
+
+func (r *productRepository) GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error) {
+	if len(ids) == 0 {
+		return []*product.Product{}, nil
+	}
+	var products []*product.Product
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		rows, err := r.db.QueryContext(ctx, getByIDsQuery, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		products = make([]*product.Product, 0, len(ids))
+		for rows.Next() {
+			var p product.Product
+			if err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.Price, &p.CreatedAt, &p.UpdatedAt, &p.Version); err != nil {
+				return err
+			}
+			inUTC(&p)
+			products = append(products, &p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repository.InOrderOf(ids, products), nil
+}
+
+func (r *productRepository) get(ctx context.Context, query string, arg any) (*product.Product, error) {
+	var p product.Product
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		return r.db.QueryRowContext(ctx, query, arg).Scan(&p.ID, &p.Name, &p.Slug, &p.Price, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	inUTC(&p)
+	return &p, nil
+}
+
+// inUTC drops the session time zone the driver attaches to timestamptz
+// values, so products compare equal whatever the backend.
+func inUTC(p *product.Product) {
+	p.CreatedAt = p.CreatedAt.UTC()
+	p.UpdatedAt = p.UpdatedAt.UTC()
+}
@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var columns = []string{"id", "name", "slug", "price", "created_at", "updated_at", "version"}
+
+func TestProductRepository_Insert(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	istanbul := time.FixedZone("+03", 3*60*60)
+	createdAt := time.Date(2022, 12, 1, 13, 0, 0, 0, istanbul)
+	p := NewProductRepository(db)
+	t.Run("returns the generated columns in UTC", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs("watch", "watch", 15).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).AddRow(1, createdAt, createdAt, 1))
+		prod, err := p.Insert(context.TODO(), &product.Product{Name: "watch", Slug: "watch", Price: 15})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, prod.ID)
+		assert.Equal(t, time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC), prod.CreatedAt)
+		assert.Equal(t, time.UTC, prod.UpdatedAt.Location())
+	})
+	t.Run("reports a taken slug", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs("watch", "watch", 15).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}))
+		_, err := p.Insert(context.TODO(), &product.Product{Name: "watch", Slug: "watch", Price: 15})
+		assert.ErrorIs(t, err, repository.ErrSlugTaken)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductRepository_GetProductBySlug(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	mock.ExpectQuery(getBySlugQuery).WithArgs("red-lemon").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+	p := NewProductRepository(db)
+	prod, err := p.GetProductBySlug(context.TODO(), "red-lemon")
+	assert.NoError(t, err)
+	assert.Equal(t, "red lemon", prod.Name)
+	mock.ExpectQuery(getBySlugQuery).WithArgs("missing").WillReturnRows(sqlmock.NewRows(columns))
+	_, err = p.GetProductBySlug(context.TODO(), "missing")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestProductRepository_GetProductByID(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	mock.ExpectQuery(getByIDQuery).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+	p := NewProductRepository(db)
+	prod, err := p.GetProductByID(context.TODO(), 7)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, prod.ID)
+}
+
+func TestProductRepository_GetProductsByIDs(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	mock.ExpectQuery(getByIDsQuery).WithArgs("{3,7}").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1).
+			AddRow(3, "lime", "lime", 3, time.Now(), time.Now(), 1))
+	p := NewProductRepository(db)
+	products, err := p.GetProductsByIDs(context.TODO(), []int64{3, 7})
+	assert.NoError(t, err)
+	if assert.Len(t, products, 2) {
+		assert.EqualValues(t, 3, products[0].ID)
+		assert.EqualValues(t, 7, products[1].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductRepository_Delete(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	mock.ExpectExec(deleteQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	p := NewProductRepository(db)
+	assert.NoError(t, p.Delete(context.TODO(), 1))
+	mock.ExpectExec(deleteQuery).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, p.Delete(context.TODO(), 2), sql.ErrNoRows)
+}
+
+func TestProductRepository_Update(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	now := time.Now()
+	mock.ExpectQuery(updateQuery).WithArgs("banana", 5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "created_at", "updated_at", "version"}).AddRow("banana", now, now, 2))
+	p := NewProductRepository(db)
+	updated, err := p.Update(context.TODO(), &product.Product{ID: 1, Name: "banana", Price: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, "banana", updated.Slug)
+	mock.ExpectQuery(updateQuery).WithArgs("banana", 5, 2).WillReturnRows(sqlmock.NewRows([]string{"slug", "created_at", "updated_at", "version"}))
+	_, err = p.Update(context.TODO(), &product.Product{ID: 2, Name: "banana", Price: 5})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestProductRepository_CompareAndUpdate(t *testing.T) {
+	current := product.Product{ID: 1, Name: "banana", Price: 5, Version: 3}
+	next := product.Product{ID: 1, Name: "banana", Price: 7}
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	p := NewProductRepository(db)
+	t.Run("updates an unchanged product", func(t *testing.T) {
+		mock.ExpectQuery(compareAndUpdateQuery).WithArgs("banana", 7, 1, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"slug", "created_at", "updated_at", "version"}).AddRow("banana", time.Now(), time.Now(), 1))
+		updated, err := p.CompareAndUpdate(context.TODO(), &current, &next)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, updated.Price)
+	})
+	t.Run("reports a concurrent change", func(t *testing.T) {
+		mock.ExpectQuery(compareAndUpdateQuery).WithArgs("banana", 7, 1, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"slug", "created_at", "updated_at", "version"}))
+		_, err := p.CompareAndUpdate(context.TODO(), &current, &next)
+		assert.ErrorIs(t, err, repository.ErrConflict)
+	})
+	t.Run("skips writing identical values", func(t *testing.T) {
+		_, err := p.CompareAndUpdate(context.TODO(), &current, &current)
+		assert.NoError(t, err)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProductRepository_Retry(t *testing.T) {
+	db, mock := createMockDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	p := NewProductRepository(db, WithRetry(database.RetryPolicy{BaseDelay: time.Millisecond}))
+	mock.ExpectQuery(getByIDQuery).WithArgs(7).WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectQuery(getByIDQuery).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, "red lemon", "red-lemon", 5, time.Now(), time.Now(), 1))
+	prod, err := p.GetProductByID(context.TODO(), 7)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, prod.ID)
+	// the update may have been committed
+	mock.ExpectQuery(updateQuery).WithArgs("banana", 5, 1).WillReturnError(&pq.Error{Code: "40001"})
+	_, err = p.Update(context.TODO(), &product.Product{ID: 1, Name: "banana", Price: 5})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func createMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return db, mock
+}
@@ -2,15 +2,49 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"github.com/halilylm/microservice/product"
 	"time"
 )
 
+// ErrConflict is returned by CompareAndUpdate when the product changed since
+// it was read.
+var ErrConflict = errors.New("product was modified concurrently")
+
+// ErrSlugTaken is returned by Insert when another product has the slug.
+var ErrSlugTaken = errors.New("slug is already taken")
+
+// ErrCacheMiss is returned by the in-memory cache for keys that are not
+// cached or expired, the redis cache returns redis.Nil.
+var ErrCacheMiss = errors.New("product is not cached")
+
 type ProductRepository interface {
 	Insert(ctx context.Context, p *product.Product) (*product.Product, error)
 	Update(ctx context.Context, p *product.Product) (*product.Product, error)
+	// CompareAndUpdate writes next only if the stored product still holds the
+	// values of current.
+	CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error)
 	Delete(ctx context.Context, id int64) error
 	GetProductBySlug(ctx context.Context, slug string) (*product.Product, error)
+	GetProductByID(ctx context.Context, id int64) (*product.Product, error)
+	// GetProductsByIDs reads the products of ids in one round trip and
+	// returns them in the order of ids, the missing ones are left out.
+	GetProductsByIDs(ctx context.Context, ids []int64) ([]*product.Product, error)
+}
+
+// InOrderOf orders products like ids, the ids with no product are skipped.
+func InOrderOf(ids []int64, products []*product.Product) []*product.Product {
+	byID := make(map[int64]*product.Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	ordered := make([]*product.Product, 0, len(products))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
 }
 
 type ProductCacheRepository interface {
@@ -0,0 +1,160 @@
+// Package repositorytest holds the behavior every product repository must
+// share, whatever the backend.
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestProductRepository runs the contract against the repositories returned
+// by newRepo, each one must start with no product.
+func TestProductRepository(t *testing.T, newRepo func(t *testing.T) repository.ProductRepository) {
+	ctx := context.Background()
+	insert := func(t *testing.T, repo repository.ProductRepository, name, slug string, price int) *product.Product {
+		p, err := repo.Insert(ctx, &product.Product{Name: name, Slug: slug, Price: price})
+		require.NoError(t, err)
+		require.NotZero(t, p.ID)
+		return p
+	}
+	t.Run("reads an inserted product by id and slug", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		byID, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		bySlug, err := repo.GetProductBySlug(ctx, "red-lemon")
+		require.NoError(t, err)
+		for _, got := range []*product.Product{byID, bySlug} {
+			assert.Equal(t, inserted.ID, got.ID)
+			assert.Equal(t, "red lemon", got.Name)
+			assert.Equal(t, "red-lemon", got.Slug)
+			assert.Equal(t, 5, got.Price)
+			assert.WithinDuration(t, time.Now(), got.CreatedAt, time.Minute)
+			assert.WithinDuration(t, time.Now(), got.UpdatedAt, time.Minute)
+			assert.Equal(t, time.UTC, got.CreatedAt.Location())
+		}
+	})
+	t.Run("reports missing products as no rows", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetProductBySlug(ctx, "missing")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = repo.GetProductByID(ctx, 404)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+	t.Run("reads the products of ids in their order", func(t *testing.T) {
+		repo := newRepo(t)
+		lemon := insert(t, repo, "red lemon", "red-lemon", 5)
+		lime := insert(t, repo, "lime", "lime", 3)
+		got, err := repo.GetProductsByIDs(ctx, []int64{lime.ID, lemon.ID + lime.ID + 1000, lemon.ID})
+		require.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "lime", got[0].Name)
+			assert.Equal(t, "red lemon", got[1].Name)
+			assert.Equal(t, time.UTC, got[1].CreatedAt.Location())
+		}
+		got, err = repo.GetProductsByIDs(ctx, nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+	t.Run("refuses a taken slug", func(t *testing.T) {
+		repo := newRepo(t)
+		insert(t, repo, "red lemon", "red-lemon", 5)
+		_, err := repo.Insert(ctx, &product.Product{Name: "Red Lemon", Slug: "red-lemon", Price: 7})
+		assert.ErrorIs(t, err, repository.ErrSlugTaken)
+	})
+	t.Run("updates the name and the price", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		_, err := repo.Update(ctx, &product.Product{ID: inserted.ID, Name: "yellow lemon", Price: 6})
+		require.NoError(t, err)
+		got, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "yellow lemon", got.Name)
+		assert.Equal(t, 6, got.Price)
+		// the slug is permanent
+		assert.Equal(t, "red-lemon", got.Slug)
+		_, err = repo.Update(ctx, &product.Product{ID: inserted.ID + 1000, Name: "lime", Price: 1})
+		assert.Error(t, err)
+	})
+	t.Run("updates with unchanged values", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		// the second one usually runs in the same second, so not even
+		// updated_at changes
+		for i := 0; i < 2; i++ {
+			_, err := repo.Update(ctx, &product.Product{ID: inserted.ID, Name: "red lemon", Price: 5})
+			require.NoError(t, err)
+		}
+	})
+	t.Run("compares and updates", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		current, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		next := *current
+		next.Price = 7
+		_, err = repo.CompareAndUpdate(ctx, current, &next)
+		require.NoError(t, err)
+		stale := next
+		stale.Price = 9
+		_, err = repo.CompareAndUpdate(ctx, current, &stale)
+		assert.ErrorIs(t, err, repository.ErrConflict)
+		got, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 7, got.Price)
+	})
+	t.Run("detects the changes undone in between", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "banana", "banana", 5)
+		stale, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		// renamed only by case, then changed and changed back
+		for _, change := range []product.Product{{Name: "Banana", Price: 5}, {Name: "banana", Price: 6}, {Name: "banana", Price: 5}} {
+			current, err := repo.GetProductByID(ctx, inserted.ID)
+			require.NoError(t, err)
+			next := *current
+			next.Name, next.Price = change.Name, change.Price
+			_, err = repo.CompareAndUpdate(ctx, current, &next)
+			require.NoError(t, err)
+		}
+		next := *stale
+		next.Price = 9
+		_, err = repo.CompareAndUpdate(ctx, stale, &next)
+		assert.ErrorIs(t, err, repository.ErrConflict)
+	})
+	t.Run("returns the new updated_at", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		current, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		// the request bodies carry no timestamp
+		next := product.Product{ID: current.ID, Name: "red lemon", Slug: current.Slug, Price: 7, CreatedAt: current.CreatedAt}
+		updated, err := repo.CompareAndUpdate(ctx, current, &next)
+		require.NoError(t, err)
+		stored, err := repo.GetProductByID(ctx, inserted.ID)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), updated.UpdatedAt, time.Minute)
+		assert.True(t, stored.UpdatedAt.Equal(updated.UpdatedAt), "returned %v, stored %v", updated.UpdatedAt, stored.UpdatedAt)
+		// nothing written, the stored one is returned
+		unchanged := product.Product{ID: stored.ID, Name: stored.Name, Slug: stored.Slug, Price: stored.Price, CreatedAt: stored.CreatedAt}
+		updated, err = repo.CompareAndUpdate(ctx, stored, &unchanged)
+		require.NoError(t, err)
+		assert.True(t, stored.UpdatedAt.Equal(updated.UpdatedAt))
+	})
+	t.Run("deletes a product", func(t *testing.T) {
+		repo := newRepo(t)
+		inserted := insert(t, repo, "red lemon", "red-lemon", 5)
+		require.NoError(t, repo.Delete(ctx, inserted.ID))
+		_, err := repo.GetProductByID(ctx, inserted.ID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.Error(t, repo.Delete(ctx, inserted.ID))
+		// the slug is free again
+		insert(t, repo, "red lemon", "red-lemon", 5)
+	})
+}
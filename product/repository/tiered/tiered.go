@@ -0,0 +1,377 @@
+// Package tiered keeps a small in-process cache of the products in front of
+// the redis cache, so the hottest products cost neither a round trip nor a
+// decoding.
+package tiered
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"expvar"
+	"github.com/go-redis/redis/v9"
+	"github.com/google/uuid"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Policy picks the entry evicted when the in-process cache is full.
+type Policy int
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU Policy = iota
+	// LFU evicts the least frequently used entry, the least recently used
+	// among the ones used as often.
+	LFU
+)
+
+const defaultChannel = "products:invalidate"
+
+// metrics publishes the hit ratios of every cache under /debug/vars.
+var metrics = expvar.NewMap("product_caches")
+
+type Options struct {
+	// Name publishes the stats of the cache under it.
+	Name   string
+	Policy Policy
+	// MaxEntries and MaxBytes bound the in-process cache, the size of an
+	// entry is estimated from its strings. 10000 entries by default when
+	// both are zero.
+	MaxEntries int
+	MaxBytes   int64
+	// TTL bounds how long a product stays in process, 5 seconds by default.
+	// It is the longest a replica serves a product changed elsewhere when
+	// an invalidation is lost.
+	TTL time.Duration
+	// Client publishes the changed keys on Channel so the other replicas
+	// drop them, the invalidation stays local without it.
+	Client  redis.UniversalClient
+	Channel string
+	Logger  *zap.Logger
+}
+
+type entry struct {
+	key       string
+	product   product.Product
+	size      int64
+	expiresAt time.Time
+	// bookkeeping of the eviction policies
+	elem  *list.Element
+	index int
+	freq  uint64
+	tick  uint64
+}
+
+// entryOverhead approximates the memory of an entry besides its strings.
+const entryOverhead = int64(unsafe.Sizeof(entry{}))
+
+// ProductCacheRepository serves the products from the process (L1) and
+// falls back to the next cache (L2). Writes go through to L2.
+type ProductCacheRepository struct {
+	next    repository.ProductCacheRepository
+	opts    Options
+	origin  string
+	mu      sync.Mutex
+	entries map[string]*entry
+	bytes   int64
+	evict   evictionPolicy
+	l1, l2  tierCounters
+	now     func() time.Time
+}
+
+func NewProductCacheRepository(next repository.ProductCacheRepository, opts Options) *ProductCacheRepository {
+	if opts.MaxEntries == 0 && opts.MaxBytes == 0 {
+		opts.MaxEntries = 10000
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 5 * time.Second
+	}
+	if opts.Channel == "" {
+		opts.Channel = defaultChannel
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	c := &ProductCacheRepository{
+		next:    next,
+		opts:    opts,
+		origin:  uuid.NewString(),
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+	if opts.Policy == LFU {
+		c.evict = &lfu{}
+	} else {
+		c.evict = &lru{list: list.New()}
+	}
+	if opts.Name != "" {
+		metrics.Set(opts.Name, expvar.Func(func() any {
+			return c.Stats()
+		}))
+	}
+	return c
+}
+
+var _ repository.ProductCacheRepository = (*ProductCacheRepository)(nil)
+
+func (c *ProductCacheRepository) GetProduct(ctx context.Context, key string) (*product.Product, error) {
+	if p, ok := c.get(key); ok {
+		c.l1.hits.Add(1)
+		return p, nil
+	}
+	c.l1.misses.Add(1)
+	p, err := c.next.GetProduct(ctx, key)
+	if err != nil {
+		c.l2.misses.Add(1)
+		return nil, err
+	}
+	c.l2.hits.Add(1)
+	c.put(key, p, c.opts.TTL)
+	return p, nil
+}
+
+// SetProduct writes p to L2 and keeps it in process for the TTL at most. It
+// fills the cache on reads so the other replicas are not told, the writes
+// drop the key with DeleteProduct.
+func (c *ProductCacheRepository) SetProduct(ctx context.Context, key string, expire time.Duration, p *product.Product) error {
+	if err := c.next.SetProduct(ctx, key, expire, p); err != nil {
+		return err
+	}
+	ttl := c.opts.TTL
+	if expire > 0 && expire < ttl {
+		ttl = expire
+	}
+	c.put(key, p, ttl)
+	return nil
+}
+
+func (c *ProductCacheRepository) DeleteProduct(ctx context.Context, key string) error {
+	c.invalidate(key)
+	err := c.next.DeleteProduct(ctx, key)
+	// the other replicas may hold the key whatever L2 answered
+	c.publish(ctx, key)
+	return err
+}
+
+// Run drops the keys changed by the other replicas until ctx is done. The
+// invalidations published while the subscription is broken are lost, the
+// TTL bounds how stale the products get then.
+func (c *ProductCacheRepository) Run(ctx context.Context) {
+	if c.opts.Client == nil {
+		return
+	}
+	sub := c.opts.Client.Subscribe(ctx, c.opts.Channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		c.opts.Logger.Error("could not subscribe to the cache invalidations", zap.String("channel", c.opts.Channel), zap.Error(err))
+	}
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			origin, key, found := strings.Cut(msg.Payload, " ")
+			if !found || origin == c.origin {
+				continue
+			}
+			c.invalidate(key)
+		}
+	}
+}
+
+// publish tells the other replicas that key changed, a failure only delays
+// their view of the change until the TTL.
+func (c *ProductCacheRepository) publish(ctx context.Context, key string) {
+	if c.opts.Client == nil {
+		return
+	}
+	if err := c.opts.Client.Publish(ctx, c.opts.Channel, c.origin+" "+key).Err(); err != nil {
+		c.opts.Logger.Warn("could not publish the cache invalidation", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (c *ProductCacheRepository) get(key string) (*product.Product, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expiresAt) {
+		c.remove(e)
+		return nil, false
+	}
+	c.evict.touch(e)
+	p := e.product
+	return &p, true
+}
+
+func (c *ProductCacheRepository) put(key string, p *product.Product, ttl time.Duration) {
+	size := entryOverhead + int64(len(key)+len(p.Name)+len(p.Slug))
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &entry{key: key, product: *p, size: size, expiresAt: c.now().Add(ttl)}
+	if old, ok := c.entries[key]; ok {
+		// keep the usage of the key
+		e.freq = old.freq
+		c.remove(old)
+	}
+	// make room first, a new entry is the least frequently used one
+	for len(c.entries) > 0 && ((c.opts.MaxEntries > 0 && len(c.entries) >= c.opts.MaxEntries) || (c.opts.MaxBytes > 0 && c.bytes+size > c.opts.MaxBytes)) {
+		c.remove(c.evict.victim())
+	}
+	c.entries[key] = e
+	c.bytes += size
+	c.evict.add(e)
+}
+
+func (c *ProductCacheRepository) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// remove drops e, the caller holds the lock.
+func (c *ProductCacheRepository) remove(e *entry) {
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+	c.evict.remove(e)
+}
+
+type tierCounters struct {
+	hits, misses atomic.Uint64
+}
+
+func (t *tierCounters) stats() TierStats {
+	s := TierStats{Hits: t.hits.Load(), Misses: t.misses.Load()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+type TierStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// Stats counts the lookups of each tier, L2 is only asked on the misses of
+// L1.
+type Stats struct {
+	L1      TierStats `json:"l1"`
+	L2      TierStats `json:"l2"`
+	Entries int       `json:"entries"`
+	Bytes   int64     `json:"bytes"`
+}
+
+func (c *ProductCacheRepository) Stats() Stats {
+	c.mu.Lock()
+	entries, bytes := len(c.entries), c.bytes
+	c.mu.Unlock()
+	return Stats{L1: c.l1.stats(), L2: c.l2.stats(), Entries: entries, Bytes: bytes}
+}
+
+type evictionPolicy interface {
+	add(e *entry)
+	touch(e *entry)
+	remove(e *entry)
+	victim() *entry
+}
+
+// lru keeps the entries from the most to the least recently used.
+type lru struct {
+	list *list.List
+}
+
+func (p *lru) add(e *entry) {
+	e.elem = p.list.PushFront(e)
+}
+
+func (p *lru) touch(e *entry) {
+	p.list.MoveToFront(e.elem)
+}
+
+func (p *lru) remove(e *entry) {
+	p.list.Remove(e.elem)
+}
+
+func (p *lru) victim() *entry {
+	return p.list.Back().Value.(*entry)
+}
+
+// lfu keeps the least frequently used entry on top of a heap.
+type lfu struct {
+	entries lfuHeap
+	tick    uint64
+}
+
+func (p *lfu) add(e *entry) {
+	p.tick++
+	e.freq++
+	e.tick = p.tick
+	heap.Push(&p.entries, e)
+}
+
+func (p *lfu) touch(e *entry) {
+	p.tick++
+	e.freq++
+	e.tick = p.tick
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfu) remove(e *entry) {
+	heap.Remove(&p.entries, e.index)
+}
+
+func (p *lfu) victim() *entry {
+	return p.entries[0]
+}
+
+type lfuHeap []*entry
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,160 @@
+package tiered
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/halilylm/microservice/product"
+	"github.com/halilylm/microservice/product/repository"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCache counts the reads that reach L2.
+type countingCache struct {
+	*repository.MockCacheRepository
+	reads atomic.Int32
+}
+
+func (c *countingCache) GetProduct(ctx context.Context, key string) (*product.Product, error) {
+	c.reads.Add(1)
+	return c.MockCacheRepository.GetProduct(ctx, key)
+}
+
+func newTestCache(opts Options) (*ProductCacheRepository, *countingCache, *time.Time) {
+	l2 := &countingCache{MockCacheRepository: repository.NewMockCacheRepository(nil)}
+	c := NewProductCacheRepository(l2, opts)
+	now := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time {
+		return now
+	}
+	return c, l2, &now
+}
+
+func lemon(name string) *product.Product {
+	return &product.Product{ID: 1, Name: name, Slug: name, Price: 5}
+}
+
+func TestProductCacheRepository(t *testing.T) {
+	ctx := context.Background()
+	t.Run("serves hits from the process", func(t *testing.T) {
+		c, l2, _ := newTestCache(Options{})
+		assert.NoError(t, c.SetProduct(ctx, "lemon", time.Minute, lemon("lemon")))
+		for i := 0; i < 3; i++ {
+			p, err := c.GetProduct(ctx, "lemon")
+			assert.NoError(t, err)
+			assert.Equal(t, "lemon", p.Name)
+		}
+		assert.EqualValues(t, 0, l2.reads.Load())
+		assert.Equal(t, TierStats{Hits: 3, HitRatio: 1}, c.Stats().L1)
+	})
+	t.Run("falls back to L2 after the TTL", func(t *testing.T) {
+		c, l2, now := newTestCache(Options{TTL: time.Second})
+		assert.NoError(t, c.SetProduct(ctx, "lemon", time.Minute, lemon("lemon")))
+		*now = now.Add(time.Second)
+		_, err := c.GetProduct(ctx, "lemon")
+		assert.NoError(t, err)
+		_, err = c.GetProduct(ctx, "lemon")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, l2.reads.Load())
+		_, err = c.GetProduct(ctx, "missing")
+		assert.Error(t, err)
+		s := c.Stats()
+		assert.Equal(t, TierStats{Hits: 1, Misses: 2, HitRatio: 1.0 / 3}, s.L1)
+		assert.Equal(t, TierStats{Hits: 1, Misses: 1, HitRatio: 0.5}, s.L2)
+	})
+	t.Run("returns copies", func(t *testing.T) {
+		c, _, _ := newTestCache(Options{})
+		assert.NoError(t, c.SetProduct(ctx, "lemon", time.Minute, lemon("lemon")))
+		p, _ := c.GetProduct(ctx, "lemon")
+		p.Price = 100
+		p, _ = c.GetProduct(ctx, "lemon")
+		assert.Equal(t, 5, p.Price)
+	})
+	t.Run("deletes from both tiers", func(t *testing.T) {
+		c, l2, _ := newTestCache(Options{})
+		assert.NoError(t, c.SetProduct(ctx, "lemon", time.Minute, lemon("lemon")))
+		assert.NoError(t, c.DeleteProduct(ctx, "lemon"))
+		_, err := c.GetProduct(ctx, "lemon")
+		assert.Error(t, err)
+		assert.EqualValues(t, 1, l2.reads.Load())
+	})
+}
+
+func TestProductCacheRepository_Eviction(t *testing.T) {
+	ctx := context.Background()
+	cached := func(c *ProductCacheRepository, key string) bool {
+		_, ok := c.get(key)
+		return ok
+	}
+	t.Run("evicts the least recently used entry", func(t *testing.T) {
+		c, _, _ := newTestCache(Options{MaxEntries: 2})
+		_ = c.SetProduct(ctx, "a", 0, lemon("a"))
+		_ = c.SetProduct(ctx, "b", 0, lemon("b"))
+		assert.True(t, cached(c, "a"))
+		_ = c.SetProduct(ctx, "c", 0, lemon("c"))
+		assert.True(t, cached(c, "a"))
+		assert.False(t, cached(c, "b"))
+		assert.True(t, cached(c, "c"))
+	})
+	t.Run("evicts the least frequently used entry", func(t *testing.T) {
+		c, _, _ := newTestCache(Options{MaxEntries: 2, Policy: LFU})
+		_ = c.SetProduct(ctx, "a", 0, lemon("a"))
+		_ = c.SetProduct(ctx, "b", 0, lemon("b"))
+		cached(c, "a")
+		cached(c, "a")
+		cached(c, "b")
+		_ = c.SetProduct(ctx, "c", 0, lemon("c"))
+		assert.True(t, cached(c, "a"))
+		assert.False(t, cached(c, "b"))
+		assert.True(t, cached(c, "c"))
+	})
+	t.Run("bounds the bytes", func(t *testing.T) {
+		size := entryOverhead + 3*int64(len("a"))
+		c, _, _ := newTestCache(Options{MaxBytes: 2 * size})
+		for _, key := range []string{"a", "b", "c"} {
+			_ = c.SetProduct(ctx, key, 0, lemon(key))
+		}
+		s := c.Stats()
+		assert.Equal(t, 2, s.Entries)
+		assert.Equal(t, 2*size, s.Bytes)
+		assert.False(t, cached(c, "a"))
+	})
+}
+
+func TestProductCacheRepository_Invalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// two replicas sharing L2
+	l2 := repository.NewMockCacheRepository(nil)
+	first := NewProductCacheRepository(l2, Options{Client: client, TTL: time.Hour})
+	second := NewProductCacheRepository(l2, Options{Client: client, TTL: time.Hour})
+	go first.Run(ctx)
+	go second.Run(ctx)
+	assert.Eventually(t, func() bool {
+		return len(mr.PubSubChannels("")) == 1 && mr.PubSubNumSub(defaultChannel)[defaultChannel] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, first.SetProduct(ctx, "lemon", time.Hour, lemon("lemon")))
+	p, err := second.GetProduct(ctx, "lemon")
+	assert.NoError(t, err)
+	assert.Equal(t, "lemon", p.Name)
+
+	// a fill stays local
+	assert.NoError(t, first.SetProduct(ctx, "lemon", time.Hour, lemon("renamed")))
+	assert.Never(t, func() bool {
+		_, ok := second.get("lemon")
+		return !ok
+	}, 100*time.Millisecond, 10*time.Millisecond)
+
+	assert.NoError(t, first.DeleteProduct(ctx, "lemon"))
+	assert.Eventually(t, func() bool {
+		_, ok := second.get("lemon")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,11 +1,17 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-playground/validator/v10"
 	"github.com/gosimple/slug"
+	"github.com/halilylm/microservice/pkg/jsonpatch"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/halilylm/microservice/pkg/rbac"
 	"github.com/halilylm/microservice/pkg/rest"
 	"github.com/halilylm/microservice/product"
 	"github.com/halilylm/microservice/product/repository"
@@ -13,20 +19,52 @@ import (
 	"time"
 )
 
+// maxPatchAttempts bounds how often a patch is applied again when the product
+// keeps changing underneath it.
+const maxPatchAttempts = 3
+
+var validate = validator.New()
+
 type productUC struct {
 	repo   repository.ProductRepository
 	cache  repository.ProductCacheRepository
+	authz  rbac.Authorizer
 	logger *zap.Logger
+	// onDelete is run after a product is deleted
+	onDelete []func(ctx context.Context, id int64) error
+}
+
+type Option func(*productUC)
+
+// WithOnDelete runs fn once a product is deleted, to drop what refers to it
+// in the other modules. The product stays deleted when fn fails, its error
+// is only logged.
+func WithOnDelete(fn func(ctx context.Context, id int64) error) Option {
+	return func(p *productUC) {
+		p.onDelete = append(p.onDelete, fn)
+	}
 }
 
-func NewProductUC(repo repository.ProductRepository, cache repository.ProductCacheRepository, logger *zap.Logger) ProductUseCase {
+// NewProductUC creates the product use case. A nil authorizer allows every
+// action.
+func NewProductUC(repo repository.ProductRepository, cache repository.ProductCacheRepository, authz rbac.Authorizer, logger *zap.Logger, opts ...Option) ProductUseCase {
+	if authz == nil {
+		authz = rbac.AllowAll()
+	}
 	if logger == nil {
 		logger = zap.NewNop()
 	}
-	return &productUC{repo: repo, cache: cache, logger: logger}
+	p := &productUC{repo: repo, cache: cache, authz: authz, logger: logger}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *productUC) CreateProduct(ctx context.Context, product *product.Product) (*product.Product, error) {
+	if err := p.authorize(ctx, rbac.ActionProductCreate); err != nil {
+		return nil, err
+	}
 	genSlug := slug.Make(product.Name)
 	for i := 1; ; i++ {
 		product, _ := p.repo.GetProductBySlug(ctx, genSlug)
@@ -38,23 +76,128 @@ func (p *productUC) CreateProduct(ctx context.Context, product *product.Product)
 	product.Slug = genSlug
 	createdProduct, err := p.repo.Insert(ctx, product)
 	if err != nil {
+		// another product took the slug since it was looked up
+		if errors.Is(err, repository.ErrSlugTaken) {
+			return nil, rest.NewConflict(err.Error())
+		}
 		return nil, rest.NewInternalServerError()
 	}
 	return createdProduct, nil
 }
 
 func (p *productUC) UpdateProduct(ctx context.Context, product *product.Product) (*product.Product, error) {
-	updatedProduct, err := p.repo.Update(ctx, product)
+	if err := p.authorize(ctx, rbac.ActionProductUpdate); err != nil {
+		return nil, err
+	}
+	current, err := p.repo.GetProductByID(ctx, product.ID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, rest.NewNotFoundError()
 		}
 		return nil, rest.NewInternalServerError()
 	}
+	if current.Price != product.Price {
+		if err := p.authorize(ctx, rbac.ActionProductUpdatePrice); err != nil {
+			return nil, err
+		}
+	}
+	// the price was authorized against current, it is only written while
+	// the product still holds it
+	product.Slug = current.Slug
+	product.CreatedAt = current.CreatedAt
+	updatedProduct, err := p.repo.CompareAndUpdate(ctx, current, product)
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, rest.NewConflict(err.Error())
+		}
+		return nil, rest.NewInternalServerError()
+	}
+	p.invalidate(ctx, current.Slug)
 	return updatedProduct, nil
 }
 
+// PatchProduct applies patch to the stored product and writes the result only
+// if nobody changed the product in between, otherwise the patch is applied
+// again to the fresh product.
+func (p *productUC) PatchProduct(ctx context.Context, id int64, patch jsonpatch.Patcher) (*product.Product, error) {
+	if err := p.authorize(ctx, rbac.ActionProductUpdate); err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < maxPatchAttempts; attempt++ {
+		current, err := p.repo.GetProductByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, rest.NewNotFoundError()
+			}
+			return nil, rest.NewInternalServerError()
+		}
+		patched, err := applyPatch(current, patch)
+		if err != nil {
+			return nil, err
+		}
+		if current.Price != patched.Price {
+			if err := p.authorize(ctx, rbac.ActionProductUpdatePrice); err != nil {
+				return nil, err
+			}
+		}
+		updatedProduct, err := p.repo.CompareAndUpdate(ctx, current, patched)
+		if err == nil {
+			p.invalidate(ctx, current.Slug)
+			return updatedProduct, nil
+		}
+		if !errors.Is(err, repository.ErrConflict) {
+			return nil, rest.NewInternalServerError()
+		}
+		p.log(ctx).Debug("product changed while patching it", zap.Int64("id", id), zap.Int("attempt", attempt+1))
+	}
+	return nil, rest.NewConflict(repository.ErrConflict.Error())
+}
+
+// applyPatch patches the json representation of current. Only the name and
+// the price may change, the result has to pass the product validation.
+func applyPatch(current *product.Product, patch jsonpatch.Patcher) (*product.Product, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, rest.NewInternalServerError()
+	}
+	doc, err = patch.Apply(doc)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, rest.NewConflict(err.Error())
+		}
+		return nil, rest.NewUnprocessableEntity(err.Error())
+	}
+	var patched product.Product
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return nil, rest.NewUnprocessableEntity("patched product is invalid: " + err.Error())
+	}
+	if patched.Slug != current.Slug || !patched.CreatedAt.Equal(current.CreatedAt) || !patched.UpdatedAt.Equal(current.UpdatedAt) {
+		return nil, rest.NewUnprocessableEntity("only name and price can be patched")
+	}
+	if err := validate.Struct(&patched); err != nil {
+		return nil, rest.NewUnprocessableEntity(err.Error())
+	}
+	patched.ID = current.ID
+	patched.CreatedAt = current.CreatedAt
+	patched.UpdatedAt = current.UpdatedAt
+	patched.Version = current.Version
+	return &patched, nil
+}
+
 func (p *productUC) DeleteProduct(ctx context.Context, id int64) error {
+	if err := p.authorize(ctx, rbac.ActionProductDelete); err != nil {
+		return err
+	}
+	// the slug is the cache key
+	current, err := p.repo.GetProductByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rest.NewNotFoundError()
+		}
+		return rest.NewInternalServerError()
+	}
 	if err := p.repo.Delete(ctx, id); err != nil {
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -63,13 +206,19 @@ func (p *productUC) DeleteProduct(ctx context.Context, id int64) error {
 			return rest.NewInternalServerError()
 		}
 	}
+	p.invalidate(ctx, current.Slug)
+	for _, fn := range p.onDelete {
+		if err := fn(ctx, id); err != nil {
+			p.log(ctx).Warn("could not clean up after the deleted product", zap.Int64("id", id), zap.Error(err))
+		}
+	}
 	return nil
 }
 
 func (p *productUC) GetProductBySlug(ctx context.Context, slug string) (*product.Product, error) {
 	// check if exists on the cache
 	if foundProduct, err := p.cache.GetProduct(ctx, slug); err == nil {
-		p.logger.Debug("getting product from the cache", zap.Int64("id", foundProduct.ID))
+		p.log(ctx).Debug("getting product from the cache", zap.Int64("id", foundProduct.ID))
 		return foundProduct, nil
 	}
 	// get product in sql
@@ -82,14 +231,46 @@ func (p *productUC) GetProductBySlug(ctx context.Context, slug string) (*product
 	}
 	// store it in the cache
 	if err := p.cache.SetProduct(ctx, slug, 10*time.Second, foundProduct); err != nil {
-		p.logger.Error("could not cache the product", zap.Int64("id", foundProduct.ID), zap.Error(err))
+		p.log(ctx).Error("could not cache the product", zap.Int64("id", foundProduct.ID), zap.Error(err))
 	}
 	return foundProduct, nil
 }
 
+// invalidate drops the cached product of slug after it changed, it is
+// served stale until the cache entry expires when that fails.
+func (p *productUC) invalidate(ctx context.Context, slug string) {
+	if err := p.cache.DeleteProduct(ctx, slug); err != nil {
+		p.log(ctx).Warn("could not invalidate the cached product", zap.String("slug", slug), zap.Error(err))
+	}
+}
+
+// authorize consults the policy and turns its decision into an http error.
+func (p *productUC) authorize(ctx context.Context, action rbac.Action) error {
+	err := p.authz.Authorize(ctx, action)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, rbac.ErrNoActor) {
+		return rest.NewUnauthorized(err.Error())
+	}
+	var denied *rbac.DeniedError
+	if errors.As(err, &denied) {
+		return rest.NewForbidden(denied.Error())
+	}
+	p.log(ctx).Error("could not authorize the action", zap.String("action", string(action)), zap.Error(err))
+	return rest.NewInternalServerError()
+}
+
 type ProductUseCase interface {
 	CreateProduct(ctx context.Context, product *product.Product) (*product.Product, error)
 	UpdateProduct(ctx context.Context, product *product.Product) (*product.Product, error)
+	PatchProduct(ctx context.Context, id int64, patch jsonpatch.Patcher) (*product.Product, error)
 	DeleteProduct(ctx context.Context, id int64) error
 	GetProductBySlug(ctx context.Context, slug string) (*product.Product, error)
 }
+
+// log is the logger of the request, it carries the request id and the
+// route.
+func (p *productUC) log(ctx context.Context) *zap.Logger {
+	return logctx.From(ctx, p.logger)
+}
@@ -2,19 +2,27 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"github.com/halilylm/microservice/pkg/auth"
+	"github.com/halilylm/microservice/pkg/jsonpatch"
+	"github.com/halilylm/microservice/pkg/logctx"
+	"github.com/halilylm/microservice/pkg/rbac"
 	"github.com/halilylm/microservice/pkg/rest"
 	"github.com/halilylm/microservice/product"
 	"github.com/halilylm/microservice/product/repository"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
 	"testing"
+	"time"
 )
 
 func TestProductUC_CreateProduct(t *testing.T) {
 	t.Parallel()
 	repo := repository.NewMockProductRepository(nil)
 	cache := repository.NewMockCacheRepository(nil)
-	uc := NewProductUC(repo, cache, zap.NewNop())
+	uc := NewProductUC(repo, cache, nil, zap.NewNop())
 	t.Run("creates a product", func(t *testing.T) {
 		p := product.Product{
 			ID:    1,
@@ -45,6 +53,23 @@ func TestProductUC_CreateProduct(t *testing.T) {
 		assert.Equal(t, "pear-watch-1", createdProduct2.Slug)
 		assert.Equal(t, 2, len(repo.Products()))
 	})
+	t.Run("conflict when the slug is taken concurrently", func(t *testing.T) {
+		uc := NewProductUC(slugTakenRepository{repository.NewMockProductRepository(nil)}, cache, nil, zap.NewNop())
+		_, err := uc.CreateProduct(context.TODO(), &product.Product{Name: "pear watch", Price: 500})
+		var httpErr *rest.HTTPError
+		if assert.ErrorAs(t, err, &httpErr) {
+			assert.Equal(t, http.StatusConflict, httpErr.Code)
+		}
+	})
+}
+
+// slugTakenRepository loses the race for every slug.
+type slugTakenRepository struct {
+	*repository.MockProductRepository
+}
+
+func (slugTakenRepository) Insert(ctx context.Context, p *product.Product) (*product.Product, error) {
+	return nil, repository.ErrSlugTaken
 }
 
 func TestProductUC_DeleteProduct(t *testing.T) {
@@ -58,12 +83,17 @@ func TestProductUC_DeleteProduct(t *testing.T) {
 		},
 	})
 	cache := repository.NewMockCacheRepository(nil)
-	uc := NewProductUC(repo, cache, zap.NewNop())
+	var deleted []int64
+	uc := NewProductUC(repo, cache, nil, zap.NewNop(), WithOnDelete(func(ctx context.Context, id int64) error {
+		deleted = append(deleted, id)
+		return errors.New("links are not reachable")
+	}))
 	t.Run("deletes a product", func(t *testing.T) {
 		assert.Equal(t, 1, len(repo.Products()))
 		err := uc.DeleteProduct(context.TODO(), 0)
 		assert.NoError(t, err)
 		assert.Equal(t, 0, len(repo.Products()))
+		assert.Equal(t, []int64{0}, deleted)
 	})
 	t.Run("returns error if product not exists", func(t *testing.T) {
 		repo.CleanProducts()
@@ -84,7 +114,7 @@ func TestProductUC_UpdateProduct(t *testing.T) {
 		},
 	})
 	cache := repository.NewMockCacheRepository(nil)
-	uc := NewProductUC(repo, cache, zap.NewNop())
+	uc := NewProductUC(repo, cache, nil, zap.NewNop())
 	t.Run("updates the product", func(t *testing.T) {
 		newProduct := product.Product{
 			ID:    0,
@@ -98,6 +128,111 @@ func TestProductUC_UpdateProduct(t *testing.T) {
 		assert.Equal(t, 1, len(repo.Products()))
 		assert.Equal(t, "lemon", updatedProduct.Name)
 	})
+	t.Run("invalidates the cached product", func(t *testing.T) {
+		_ = cache.SetProduct(context.TODO(), "test", time.Minute, &product.Product{Name: "lemon", Slug: "test"})
+		_, err := uc.UpdateProduct(context.TODO(), &product.Product{ID: 0, Name: "lime", Price: 25})
+		assert.NoError(t, err)
+		_, err = cache.GetProduct(context.TODO(), "test")
+		assert.Error(t, err)
+	})
+	t.Run("conflict when the product changed since it was read", func(t *testing.T) {
+		racing := &racingRepository{MockProductRepository: repository.NewMockProductRepository(map[int64]*product.Product{
+			1: {ID: 1, Name: "test", Slug: "test", Price: 15},
+		})}
+		uc := NewProductUC(racing, cache, nil, zap.NewNop())
+		_, err := uc.UpdateProduct(context.TODO(), &product.Product{ID: 1, Name: "lemon", Price: 15})
+		var httpErr *rest.HTTPError
+		if assert.ErrorAs(t, err, &httpErr) {
+			assert.Equal(t, http.StatusConflict, httpErr.Code)
+		}
+		assert.Equal(t, "raced", racing.Products()[1].Name)
+	})
+}
+
+// racingRepository changes the stored product right before the first
+// CompareAndUpdate, as a concurrent writer would.
+type racingRepository struct {
+	*repository.MockProductRepository
+	raced bool
+}
+
+func (r *racingRepository) CompareAndUpdate(ctx context.Context, current, next *product.Product) (*product.Product, error) {
+	if !r.raced {
+		r.raced = true
+		r.Products()[current.ID] = &product.Product{ID: current.ID, Name: "raced", Slug: current.Slug, Price: current.Price, Version: current.Version + 1}
+	}
+	return r.MockProductRepository.CompareAndUpdate(ctx, current, next)
+}
+
+func TestProductUC_PatchProduct(t *testing.T) {
+	t.Parallel()
+	newRepo := func() *repository.MockProductRepository {
+		return repository.NewMockProductRepository(map[int64]*product.Product{
+			1: {
+				ID:    1,
+				Name:  "test",
+				Slug:  "test",
+				Price: 15,
+			},
+		})
+	}
+	cache := repository.NewMockCacheRepository(nil)
+	assertStatus := func(t *testing.T, err error, status int) {
+		t.Helper()
+		var httpErr *rest.HTTPError
+		if assert.ErrorAs(t, err, &httpErr) {
+			assert.Equal(t, status, httpErr.Code)
+		}
+	}
+	t.Run("merges the patch into the stored product", func(t *testing.T) {
+		repo := newRepo()
+		uc := NewProductUC(repo, cache, nil, zap.NewNop())
+		patched, err := uc.PatchProduct(context.TODO(), 1, jsonpatch.MergePatch(`{"price": 20}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "test", patched.Name)
+		assert.Equal(t, 20, patched.Price)
+		assert.Equal(t, 20, repo.Products()[1].Price)
+	})
+	t.Run("applies json patch with a test operation", func(t *testing.T) {
+		repo := newRepo()
+		uc := NewProductUC(repo, cache, nil, zap.NewNop())
+		patch, _ := jsonpatch.Decode([]byte(`[{"op":"test","path":"/price","value":15},{"op":"replace","path":"/name","value":"lemon"}]`))
+		patched, err := uc.PatchProduct(context.TODO(), 1, patch)
+		assert.NoError(t, err)
+		assert.Equal(t, "lemon", patched.Name)
+		patch, _ = jsonpatch.Decode([]byte(`[{"op":"test","path":"/price","value":99},{"op":"replace","path":"/name","value":"pear"}]`))
+		_, err = uc.PatchProduct(context.TODO(), 1, patch)
+		assertStatus(t, err, http.StatusConflict)
+		assert.Equal(t, "lemon", repo.Products()[1].Name)
+	})
+	t.Run("rejects invalid results", func(t *testing.T) {
+		uc := NewProductUC(newRepo(), cache, nil, zap.NewNop())
+		for _, patch := range []string{`{"name": null}`, `{"slug": "other"}`, `{"color": "red"}`, `{"price": "cheap"}`} {
+			_, err := uc.PatchProduct(context.TODO(), 1, jsonpatch.MergePatch(patch))
+			assertStatus(t, err, http.StatusUnprocessableEntity)
+		}
+	})
+	t.Run("returns 404 for a missing product", func(t *testing.T) {
+		uc := NewProductUC(newRepo(), cache, nil, zap.NewNop())
+		_, err := uc.PatchProduct(context.TODO(), 2, jsonpatch.MergePatch(`{"price": 20}`))
+		assertStatus(t, err, http.StatusNotFound)
+	})
+	t.Run("applies the patch again after a concurrent change", func(t *testing.T) {
+		repo := &racingRepository{MockProductRepository: newRepo()}
+		uc := NewProductUC(repo, cache, nil, zap.NewNop())
+		patched, err := uc.PatchProduct(context.TODO(), 1, jsonpatch.MergePatch(`{"price": 20}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "raced", patched.Name)
+		assert.Equal(t, 20, patched.Price)
+	})
+	t.Run("editor can not patch the price", func(t *testing.T) {
+		uc := NewProductUC(newRepo(), cache, rbac.NewEnforcer(rbac.DefaultPolicy(), nil), zap.NewNop())
+		editor := auth.NewContext(context.TODO(), &auth.Principal{Subject: "ed", Roles: []string{"editor"}})
+		_, err := uc.PatchProduct(editor, 1, jsonpatch.MergePatch(`{"price": 20}`))
+		assertStatus(t, err, http.StatusForbidden)
+		_, err = uc.PatchProduct(editor, 1, jsonpatch.MergePatch(`{"name": "renamed"}`))
+		assert.NoError(t, err)
+	})
 }
 
 func TestProductUC_GetProductBySlug(t *testing.T) {
@@ -111,7 +246,7 @@ func TestProductUC_GetProductBySlug(t *testing.T) {
 		},
 	})
 	cache := repository.NewMockCacheRepository(nil)
-	uc := NewProductUC(repo, cache, zap.NewNop())
+	uc := NewProductUC(repo, cache, nil, zap.NewNop())
 	t.Run("returns the product", func(t *testing.T) {
 		product, err := uc.GetProductBySlug(context.TODO(), "test")
 		assert.NoError(t, err)
@@ -127,6 +262,16 @@ func TestProductUC_GetProductBySlug(t *testing.T) {
 		assert.NotNil(t, product)
 		assert.Equal(t, 1, len(cache.Products()))
 	})
+	t.Run("logs with the logger of the request", func(t *testing.T) {
+		core, logs := observer.New(zap.DebugLevel)
+		ctx := logctx.With(context.TODO(), zap.New(core).With(zap.String("http.request.id", "req-1")))
+		_, err := uc.GetProductBySlug(ctx, "test")
+		assert.NoError(t, err)
+		entries := logs.FilterMessage("getting product from the cache").All()
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "req-1", entries[0].ContextMap()["http.request.id"])
+		}
+	})
 	t.Run("returns error if do not exists in both", func(t *testing.T) {
 		repo.CleanProducts()
 		cache.CleanProducts()
@@ -138,3 +283,41 @@ func TestProductUC_GetProductBySlug(t *testing.T) {
 		assert.ErrorAs(t, err, &httpErr)
 	})
 }
+
+func TestProductUC_Authorization(t *testing.T) {
+	t.Parallel()
+	repo := repository.NewMockProductRepository(map[int64]*product.Product{
+		1: {
+			ID:    1,
+			Name:  "test",
+			Slug:  "test",
+			Price: 15,
+		},
+	})
+	cache := repository.NewMockCacheRepository(nil)
+	uc := NewProductUC(repo, cache, rbac.NewEnforcer(rbac.DefaultPolicy(), nil), zap.NewNop())
+	editor := auth.NewContext(context.TODO(), &auth.Principal{Subject: "ed", Roles: []string{"editor"}})
+	t.Run("anonymous actors are unauthorized", func(t *testing.T) {
+		_, err := uc.CreateProduct(context.TODO(), &product.Product{ID: 2, Name: "pear", Price: 5})
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+	})
+	t.Run("editor can rename", func(t *testing.T) {
+		_, err := uc.UpdateProduct(editor, &product.Product{ID: 1, Name: "renamed", Price: 15})
+		assert.NoError(t, err)
+	})
+	t.Run("editor can not change the price", func(t *testing.T) {
+		_, err := uc.UpdateProduct(editor, &product.Product{ID: 1, Name: "renamed", Price: 20})
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusForbidden, httpErr.Code)
+	})
+	t.Run("editor can not delete", func(t *testing.T) {
+		err := uc.DeleteProduct(editor, 1)
+		var httpErr *rest.HTTPError
+		assert.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusForbidden, httpErr.Code)
+		assert.Equal(t, 1, len(repo.Products()))
+	})
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/rest"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// redacted replaces the secrets of the configuration dump.
+const redacted = "[REDACTED]"
+
+// adminRoutes maps the admin endpoints on their own router, the profiles and
+// expvar register themselves on http.DefaultServeMux which is never served.
+func (s *Server) adminRoutes() http.Handler {
+	r := chi.NewMux()
+	r.Use(middleware.Recoverer)
+	r.NotFound(rest.NotFound)
+	r.MethodNotAllowed(rest.MethodNotAllowed)
+	r.Route("/debug", func(r chi.Router) {
+		r.Get("/pprof/cmdline", pprof.Cmdline)
+		r.Get("/pprof/profile", pprof.Profile)
+		r.Get("/pprof/symbol", pprof.Symbol)
+		r.Post("/pprof/symbol", pprof.Symbol)
+		r.Get("/pprof/trace", pprof.Trace)
+		// the index serves the named profiles too, e.g. /debug/pprof/heap
+		r.Get("/pprof/*", pprof.Index)
+		r.Get("/vars", expvar.Handler().ServeHTTP)
+		r.Get("/gc", s.gcStats)
+		r.Get("/goroutines", goroutineDump)
+	})
+	if s.logLevel != (zap.AtomicLevel{}) {
+		r.Get("/log/level", s.logLevel.ServeHTTP)
+		r.Put("/log/level", s.setLogLevel)
+	}
+	r.Get("/config", s.configDump)
+	return r
+}
+
+func (s *Server) listenAdmin(onError func(error)) error {
+	if s.admin == nil {
+		return nil
+	}
+	s.admin.Handler = s.adminRoutes()
+	l, err := net.Listen("tcp", s.admin.Addr)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("starting the admin server", zap.String("address", s.admin.Addr))
+	go func() {
+		if err := s.admin.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) && onError != nil {
+			onError(err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) shutdownAdmin(ctx context.Context) error {
+	if s.admin == nil {
+		return nil
+	}
+	return s.admin.Shutdown(ctx)
+}
+
+// setLogLevel changes the level with zap's handler and logs the change.
+func (s *Server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	from := s.logLevel.Level()
+	s.logLevel.ServeHTTP(w, r)
+	if to := s.logLevel.Level(); to != from {
+		s.logger.Warn("changed the log level", zap.Stringer("from", from), zap.Stringer("to", to))
+	}
+}
+
+type gcStats struct {
+	NumGC          int64     `json:"num_gc"`
+	LastGC         time.Time `json:"last_gc"`
+	PauseTotalMS   float64   `json:"pause_total_ms"`
+	RecentPausesMS []float64 `json:"recent_pauses_ms"`
+	HeapAlloc      uint64    `json:"heap_alloc_bytes"`
+	HeapInuse      uint64    `json:"heap_inuse_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	NextGC         uint64    `json:"next_gc_bytes"`
+	Sys            uint64    `json:"sys_bytes"`
+	Goroutines     int       `json:"goroutines"`
+	GOMAXPROCS     int       `json:"gomaxprocs"`
+}
+
+func (s *Server) gcStats(w http.ResponseWriter, r *http.Request) {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := gcStats{
+		NumGC:          gc.NumGC,
+		LastGC:         gc.LastGC,
+		PauseTotalMS:   milliseconds(gc.PauseTotal),
+		RecentPausesMS: []float64{},
+		HeapAlloc:      mem.HeapAlloc,
+		HeapInuse:      mem.HeapInuse,
+		HeapObjects:    mem.HeapObjects,
+		NextGC:         mem.NextGC,
+		Sys:            mem.Sys,
+		Goroutines:     runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+	}
+	for i, pause := range gc.Pause {
+		if i == 10 {
+			break
+		}
+		stats.RecentPausesMS = append(stats.RecentPausesMS, milliseconds(pause))
+	}
+	writeJSON(w, stats)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// goroutineDump writes the stacks of every goroutine, in the format of an
+// unrecovered panic.
+func goroutineDump(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+type configDump struct {
+	Address         string     `json:"address"`
+	AdminAddress    string     `json:"admin_address"`
+	LogLevel        string     `json:"log_level,omitempty"`
+	DrainPeriod     string     `json:"drain_period"`
+	ShutdownTimeout string     `json:"shutdown_timeout"`
+	ProductStorage  string     `json:"product_storage"`
+	MemorySnapshot  string     `json:"memory_snapshot,omitempty"`
+	TLS             tlsDump    `json:"tls"`
+	Auth            authDump   `json:"auth"`
+	Mysql           *sqlDump   `json:"mysql,omitempty"`
+	Postgres        *sqlDump   `json:"postgres,omitempty"`
+	Redis           *redisDump `json:"redis,omitempty"`
+	Replicas        []string   `json:"mysql_replicas,omitempty"`
+	Build           buildDump  `json:"build"`
+}
+
+type tlsDump struct {
+	Enabled           bool   `json:"enabled"`
+	CertFile          string `json:"cert_file,omitempty"`
+	KeyFile           string `json:"key_file,omitempty"`
+	ClientCAFile      string `json:"client_ca_file,omitempty"`
+	RequireClientCert bool   `json:"require_client_cert"`
+	MinVersion        string `json:"min_version,omitempty"`
+	Reload            string `json:"reload,omitempty"`
+}
+
+type authDump struct {
+	JWKSFile   string `json:"jwks_file,omitempty"`
+	JWKSReload string `json:"jwks_reload"`
+	Issuer     string `json:"issuer,omitempty"`
+	Audience   string `json:"audience,omitempty"`
+	Leeway     string `json:"leeway"`
+	PolicyFile string `json:"policy_file,omitempty"`
+}
+
+type sqlDump struct {
+	Address  string `json:"address"`
+	User     string `json:"user"`
+	Password string `json:"password,omitempty"`
+	Name     string `json:"name"`
+	TLS      bool   `json:"tls"`
+}
+
+type redisDump struct {
+	Mode             string   `json:"mode"`
+	Address          string   `json:"address,omitempty"`
+	Addrs            []string `json:"addrs,omitempty"`
+	MasterName       string   `json:"master_name,omitempty"`
+	Username         string   `json:"username,omitempty"`
+	Password         string   `json:"password,omitempty"`
+	SentinelPassword string   `json:"sentinel_password,omitempty"`
+	DB               int      `json:"db"`
+	ReadFromReplica  bool     `json:"read_from_replica"`
+	PoolSize         int      `json:"pool_size,omitempty"`
+	TLS              bool     `json:"tls"`
+}
+
+type buildDump struct {
+	GoVersion string `json:"go_version"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// configDump writes the configuration the server runs with, the passwords
+// are redacted.
+func (s *Server) configDump(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.config())
+}
+
+func (s *Server) config() configDump {
+	c := configDump{
+		Address:         s.address,
+		DrainPeriod:     s.drainPeriod.String(),
+		ShutdownTimeout: s.shutdownTimeout.String(),
+		ProductStorage:  s.productStorage,
+		MemorySnapshot:  s.memorySnapshot,
+		TLS: tlsDump{
+			Enabled:           s.tls.CertFile != "",
+			CertFile:          s.tls.CertFile,
+			KeyFile:           s.tls.KeyFile,
+			ClientCAFile:      s.tls.ClientCAFile,
+			RequireClientCert: s.tls.RequireClientCert,
+			Reload:            s.tls.Reload.String(),
+		},
+		Auth: authDump{
+			JWKSFile:   s.auth.JWKSFile,
+			JWKSReload: s.auth.JWKSReload.String(),
+			Issuer:     s.auth.Issuer,
+			Audience:   s.auth.Audience,
+			Leeway:     s.auth.Leeway.String(),
+			PolicyFile: s.auth.PolicyFile,
+		},
+		Build: buildDump{GoVersion: runtime.Version()},
+	}
+	if s.admin != nil {
+		c.AdminAddress = s.admin.Addr
+	}
+	if s.logLevel != (zap.AtomicLevel{}) {
+		c.LogLevel = s.logLevel.String()
+	}
+	if s.tls.MinVersion != 0 {
+		c.TLS.MinVersion = tlsVersionName(s.tls.MinVersion)
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		c.Build.Path, c.Build.Version = info.Main.Path, info.Main.Version
+	}
+	if s.productStorage == StorageMemory {
+		// no database and no redis
+		return c
+	}
+	mysql := s.mysqlOptions()
+	c.Mysql = &sqlDump{
+		Address:  net.JoinHostPort(mysql.Host, strconv.Itoa(mysql.Port)),
+		User:     mysql.User,
+		Password: redact(mysql.Password),
+		Name:     mysql.Name,
+		TLS:      mysql.TLS != nil,
+	}
+	for _, replica := range mysql.Replicas {
+		c.Replicas = append(c.Replicas, net.JoinHostPort(replica.Host, strconv.Itoa(replica.Port)))
+	}
+	if s.productStorage == StoragePostgres {
+		pg := s.postgresOptions()
+		c.Postgres = &sqlDump{
+			Address:  net.JoinHostPort(pg.Host, strconv.Itoa(pg.Port)),
+			User:     pg.User,
+			Password: redact(pg.Password),
+			Name:     pg.Name,
+			TLS:      pg.SSLMode != "" && pg.SSLMode != "disable",
+		}
+	}
+	c.Redis = &redisDump{
+		Mode:             s.redis.Mode.String(),
+		Addrs:            s.redis.Addrs,
+		MasterName:       s.redis.MasterName,
+		Username:         s.redis.Username,
+		Password:         redact(s.redis.Password),
+		SentinelPassword: redact(s.redis.SentinelPassword),
+		DB:               s.redis.DB,
+		ReadFromReplica:  s.redis.ReadFromReplica,
+		PoolSize:         s.redis.PoolSize,
+		TLS:              s.redis.TLS != nil,
+	}
+	if s.redis.Mode == database.RedisStandalone {
+		c.Redis.Address = net.JoinHostPort(s.redis.Host, strconv.Itoa(s.redis.Port))
+	}
+	return c
+}
+
+// redact hides a secret, an empty one stays empty so a missing password
+// can be told apart.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return "0x" + strconv.FormatUint(uint64(v), 16)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
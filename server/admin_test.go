@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.adminRoutes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_AdminRoutes(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	s := New(&Options{
+		LogLevel: level,
+		Redis:    database.RedisOptions{Host: "redis", Port: 6379, Password: "hunter2"},
+	})
+	t.Run("serves the profiles", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/debug/pprof/", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "goroutine")
+		rec = adminRequest(t, s, http.MethodGet, "/debug/pprof/heap?debug=1", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+	t.Run("serves expvar", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/debug/vars", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"memstats"`)
+	})
+	t.Run("serves the gc stats", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/debug/gc", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var stats gcStats
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+		assert.Positive(t, stats.Goroutines)
+		assert.Positive(t, stats.HeapAlloc)
+	})
+	t.Run("dumps the goroutines", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/debug/goroutines", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "TestServer_AdminRoutes")
+	})
+	t.Run("changes the log level", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/log/level", "")
+		assert.JSONEq(t, `{"level":"info"}`, rec.Body.String())
+		rec = adminRequest(t, s, http.MethodPut, "/log/level", `{"level":"warn"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, zapcore.WarnLevel, level.Level())
+		rec = adminRequest(t, s, http.MethodPut, "/log/level", `{"level":"loud"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, zapcore.WarnLevel, level.Level())
+	})
+	t.Run("redacts the configuration", func(t *testing.T) {
+		rec := adminRequest(t, s, http.MethodGet, "/config", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.NotContains(t, body, "hunter2")
+		assert.NotContains(t, body, `"secret"`)
+		var c configDump
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &c))
+		assert.Equal(t, redacted, c.Redis.Password)
+		assert.Empty(t, c.Redis.SentinelPassword)
+		assert.Equal(t, redacted, c.Mysql.Password)
+		assert.Equal(t, "warn", c.LogLevel)
+	})
+	t.Run("hides the log level without one", func(t *testing.T) {
+		rec := adminRequest(t, New(&Options{}), http.MethodGet, "/log/level", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestServer_ListenAdmin(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	s := New(&Options{Admin: AdminOptions{Host: "127.0.0.1", Port: port}})
+	require.NoError(t, s.listenAdmin(func(err error) { t.Error(err) }))
+	res, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) + "/debug/vars")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, s.shutdownAdmin(context.Background()))
+
+	// disabled without a port
+	s = New(&Options{})
+	assert.NoError(t, s.listenAdmin(nil))
+	assert.NoError(t, s.shutdownAdmin(context.Background()))
+}
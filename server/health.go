@@ -3,23 +3,198 @@ package server
 import (
 	"context"
 	"encoding/json"
-	"github.com/halilylm/microservice/pkg/rest"
+	"github.com/halilylm/microservice/pkg/breaker"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type pinger interface {
 	Ping(ctx context.Context) error
 }
 
-func Health(pingers ...pinger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		for _, p := range pingers {
-			if err := p.Ping(context.TODO()); err != nil {
-				restErr := rest.NewStatusBadGateway()
-				w.WriteHeader(restErr.Code)
-				json.NewEncoder(w).Encode(restErr)
-				return
-			}
+// Criticality tells how a failing dependency affects the instance.
+type Criticality string
+
+const (
+	// Critical dependencies take the instance out of rotation when down.
+	Critical Criticality = "critical"
+	// Degraded dependencies are reported but the instance keeps serving,
+	// such as a cache the requests can do without.
+	Degraded Criticality = "degraded"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+	// StatusDraining is reported by readiness while the server shuts down.
+	StatusDraining = "draining"
+)
+
+const defaultCheckTimeout = time.Second
+
+// Check is a named dependency probed by the health endpoints.
+type Check struct {
+	Name        string
+	Pinger      pinger
+	Criticality Criticality
+	// Timeout bounds a single ping, one second by default.
+	Timeout time.Duration
+	// Breaker guards the calls to the dependency, its state is reported
+	// next to the ping result.
+	Breaker *breaker.Breaker
+}
+
+type CheckResult struct {
+	Status      string      `json:"status"`
+	Criticality Criticality `json:"criticality"`
+	LatencyMS   float64     `json:"latency_ms"`
+	Error       string      `json:"error,omitempty"`
+	Breaker     string      `json:"breaker,omitempty"`
+	// the history is only kept by the HealthMonitor
+	Flaps       int        `json:"flaps,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+type HealthReport struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// HealthChecker serves the liveness, readiness and startup probes, pinging
+// the dependencies on every request.
+type HealthChecker struct {
+	checks  []Check
+	startup startupProbe
+}
+
+func NewHealthChecker(checks ...Check) *HealthChecker {
+	for i := range checks {
+		if checks[i].Timeout == 0 {
+			checks[i].Timeout = defaultCheckTimeout
+		}
+		if checks[i].Criticality == "" {
+			checks[i].Criticality = Critical
 		}
 	}
+	return &HealthChecker{checks: checks}
+}
+
+// Check pings every dependency concurrently. The report is down when a
+// critical dependency is, degraded when only degraded ones are.
+func (h *HealthChecker) Check(ctx context.Context) HealthReport {
+	results := make([]CheckResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, check := range h.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+	report := HealthReport{
+		Status:    StatusUp,
+		CheckedAt: time.Now().UTC(),
+		Checks:    make(map[string]CheckResult, len(h.checks)),
+	}
+	for i, check := range h.checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.Status == StatusUp {
+			continue
+		}
+		if check.Criticality == Critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+	start := time.Now()
+	errc := make(chan error, 1)
+	// pingers that ignore the context must not hold the probe past its
+	// timeout
+	go func() {
+		errc <- check.Pinger.Ping(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{
+		Status:      StatusUp,
+		Criticality: check.Criticality,
+		LatencyMS:   float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	if check.Breaker != nil {
+		result.Breaker = check.Breaker.State().String()
+	}
+	return result
+}
+
+// Live tells the process is running, it doesn't look at the dependencies so
+// an outage of one of them doesn't get the pods restarted.
+func (h *HealthChecker) Live(w http.ResponseWriter, r *http.Request) {
+	writeLive(w)
+}
+
+// Ready answers 503 while a critical dependency is down.
+func (h *HealthChecker) Ready(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, h.Check(r.Context()))
+}
+
+// Startup answers 503 until the critical dependencies have been reachable
+// once, later calls succeed without probing.
+func (h *HealthChecker) Startup(w http.ResponseWriter, r *http.Request) {
+	h.startup.serve(w, func() HealthReport {
+		return h.Check(r.Context())
+	})
+}
+
+// startupProbe latches once a report is not down.
+type startupProbe struct {
+	started atomic.Bool
+}
+
+func (p *startupProbe) serve(w http.ResponseWriter, report func() HealthReport) {
+	if p.started.Load() {
+		writeLive(w)
+		return
+	}
+	r := report()
+	if r.Status != StatusDown {
+		p.started.Store(true)
+	}
+	writeReport(w, r)
+}
+
+func writeLive(w http.ResponseWriter) {
+	writeReport(w, HealthReport{Status: StatusUp, CheckedAt: time.Now().UTC()})
+}
+
+func writeReport(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusDown || report.Status == StatusDraining {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
 }
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type HealthMonitorOptions struct {
+	// Interval between two probes, 10 seconds by default.
+	Interval time.Duration
+	// Jitter is the most a probe is randomly delayed by so that replicas
+	// don't hit the dependencies at once, a tenth of Interval by default.
+	Jitter time.Duration
+	Logger *zap.Logger
+}
+
+// HealthMonitor probes the dependencies in the background and serves the
+// probes from the last report, so probing the endpoints adds no load to the
+// dependencies.
+type HealthMonitor struct {
+	checker  *HealthChecker
+	opts     HealthMonitorOptions
+	startup  startupProbe
+	draining atomic.Bool
+
+	mu       sync.RWMutex
+	snapshot HealthReport
+	history  map[string]*checkHistory
+}
+
+type checkHistory struct {
+	status      string
+	flaps       int
+	lastSuccess *time.Time
+	lastFailure *time.Time
+}
+
+func NewHealthMonitor(checker *HealthChecker, opts HealthMonitorOptions) *HealthMonitor {
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Jitter == 0 {
+		opts.Jitter = opts.Interval / 10
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &HealthMonitor{
+		checker: checker,
+		opts:    opts,
+		// nothing was probed yet, the instance is not ready
+		snapshot: HealthReport{Status: StatusDown},
+		history:  make(map[string]*checkHistory),
+	}
+}
+
+// Run probes right away and then every interval until ctx is done.
+func (m *HealthMonitor) Run(ctx context.Context) {
+	for {
+		m.Probe(ctx)
+		delay := m.opts.Interval
+		if m.opts.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(m.opts.Jitter)))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Probe checks the dependencies once and updates the snapshot.
+func (m *HealthMonitor) Probe(ctx context.Context) HealthReport {
+	report := m.checker.Check(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, result := range report.Checks {
+		h, ok := m.history[name]
+		if !ok {
+			h = &checkHistory{}
+			m.history[name] = h
+		}
+		at := report.CheckedAt
+		if result.Status == StatusUp {
+			h.lastSuccess = &at
+		} else {
+			h.lastFailure = &at
+		}
+		if h.status != result.Status {
+			m.logTransition(name, h.status, result)
+			if h.status != "" {
+				h.flaps++
+			}
+			h.status = result.Status
+		}
+		result.Flaps = h.flaps
+		result.LastSuccess = h.lastSuccess
+		result.LastFailure = h.lastFailure
+		report.Checks[name] = result
+	}
+	if m.snapshot.Status != report.Status {
+		m.opts.Logger.Info("health status changed",
+			zap.String("from", m.snapshot.Status), zap.String("to", report.Status))
+	}
+	m.snapshot = report
+	return report
+}
+
+func (m *HealthMonitor) logTransition(name, from string, result CheckResult) {
+	fields := []zap.Field{
+		zap.String("dependency", name),
+		zap.String("criticality", string(result.Criticality)),
+		zap.String("from", from),
+		zap.String("to", result.Status),
+	}
+	if result.Status == StatusUp {
+		// the first successful probe is not worth a line
+		if from != "" {
+			m.opts.Logger.Info("dependency recovered", fields...)
+		}
+		return
+	}
+	m.opts.Logger.Warn("dependency is down", append(fields, zap.String("error", result.Error))...)
+}
+
+// Snapshot returns the last report.
+func (m *HealthMonitor) Snapshot() HealthReport {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	report := m.snapshot
+	report.Checks = make(map[string]CheckResult, len(m.snapshot.Checks))
+	for name, result := range m.snapshot.Checks {
+		report.Checks[name] = result
+	}
+	return report
+}
+
+func (m *HealthMonitor) Live(w http.ResponseWriter, r *http.Request) {
+	writeLive(w)
+}
+
+// Drain takes the instance out of rotation for good, readiness answers 503
+// from now on while liveness is kept.
+func (m *HealthMonitor) Drain() {
+	m.draining.Store(true)
+}
+
+func (m *HealthMonitor) Ready(w http.ResponseWriter, r *http.Request) {
+	report := m.Snapshot()
+	if m.draining.Load() {
+		report.Status = StatusDraining
+	}
+	writeReport(w, report)
+}
+
+func (m *HealthMonitor) Startup(w http.ResponseWriter, r *http.Request) {
+	m.startup.serve(w, m.Snapshot)
+}
@@ -2,36 +2,164 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"github.com/halilylm/microservice/pkg/breaker"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type dbPingMock struct {
-	err error
+	err   error
+	delay time.Duration
 }
 
 func (d *dbPingMock) Ping(ctx context.Context) error {
+	if d.delay > 0 {
+		time.Sleep(d.delay)
+	}
 	return d.err
 }
 
-func TestHealth(t *testing.T) {
+func TestHealthChecker_Ready(t *testing.T) {
 	db := dbPingMock{}
+	cache := dbPingMock{}
+	h := NewHealthChecker(
+		Check{Name: "mysql", Pinger: &db},
+		Check{Name: "redis", Pinger: &cache, Criticality: Degraded},
+	)
+	ready := func() (int, HealthReport) {
+		req := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		res := httptest.NewRecorder()
+		h.Ready(res, req)
+		var report HealthReport
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+		return res.Result().StatusCode, report
+	}
 	t.Run("returns 200", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		status, report := ready()
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, StatusUp, report.Status)
+		assert.Equal(t, StatusUp, report.Checks["mysql"].Status)
+		assert.Equal(t, Degraded, report.Checks["redis"].Criticality)
+	})
+	t.Run("stays ready when a degraded dependency is down", func(t *testing.T) {
+		cache.err = errors.New("connection refused")
+		status, report := ready()
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, StatusDegraded, report.Status)
+		assert.Equal(t, StatusDown, report.Checks["redis"].Status)
+		assert.Equal(t, "connection refused", report.Checks["redis"].Error)
+	})
+	t.Run("reports the breaker state", func(t *testing.T) {
+		b := breaker.New(breaker.Options{MinRequests: 1})
+		b.Do(context.Background(), func(ctx context.Context) error {
+			return errors.New("connection refused")
+		})
+		h := NewHealthChecker(Check{Name: "mysql", Pinger: &dbPingMock{}, Breaker: b})
+		report := h.Check(context.Background())
+		assert.Equal(t, "open", report.Checks["mysql"].Breaker)
+	})
+	t.Run("returns 503 when a critical dependency is down", func(t *testing.T) {
+		db.err = errors.New("error connecting")
+		status, report := ready()
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, StatusDown, report.Status)
+		assert.Equal(t, "error connecting", report.Checks["mysql"].Error)
+	})
+}
+
+func TestHealthChecker_Check(t *testing.T) {
+	t.Run("pings concurrently with a timeout each", func(t *testing.T) {
+		h := NewHealthChecker(
+			Check{Name: "slow", Pinger: &dbPingMock{delay: time.Second}, Timeout: 50 * time.Millisecond},
+			Check{Name: "fast", Pinger: &dbPingMock{delay: 40 * time.Millisecond}},
+		)
+		start := time.Now()
+		report := h.Check(context.Background())
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, StatusDown, report.Checks["slow"].Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+		assert.Equal(t, StatusUp, report.Checks["fast"].Status)
+		assert.GreaterOrEqual(t, report.Checks["fast"].LatencyMS, 40.0)
+	})
+}
+
+func TestHealthChecker_LiveAndStartup(t *testing.T) {
+	db := dbPingMock{err: errors.New("error connecting")}
+	h := NewHealthChecker(Check{Name: "mysql", Pinger: &db})
+	probe := func(handler http.HandlerFunc) int {
 		res := httptest.NewRecorder()
-		Health(&db)(res, req)
-		result := res.Result()
-		assert.Equal(t, http.StatusOK, result.StatusCode)
+		handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		return res.Result().StatusCode
+	}
+	assert.Equal(t, http.StatusOK, probe(h.Live))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(h.Startup))
+	db.err = nil
+	assert.Equal(t, http.StatusOK, probe(h.Startup))
+	// once started the probe no longer depends on the database
+	db.err = errors.New("error connecting")
+	assert.Equal(t, http.StatusOK, probe(h.Startup))
+	assert.Equal(t, http.StatusOK, probe(h.Live))
+}
+
+func TestHealthMonitor(t *testing.T) {
+	db := dbPingMock{}
+	cache := dbPingMock{}
+	m := NewHealthMonitor(NewHealthChecker(
+		Check{Name: "mysql", Pinger: &db},
+		Check{Name: "redis", Pinger: &cache, Criticality: Degraded},
+	), HealthMonitorOptions{Interval: time.Hour})
+	ready := func() int {
+		res := httptest.NewRecorder()
+		m.Ready(res, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		return res.Result().StatusCode
+	}
+	t.Run("is not ready before the first probe", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, ready())
 	})
-	t.Run("returns 502 when db pings return error", func(t *testing.T) {
+	t.Run("serves the cached report", func(t *testing.T) {
+		m.Probe(context.Background())
 		db.err = errors.New("error connecting")
-		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		assert.Equal(t, http.StatusOK, ready())
+		m.Probe(context.Background())
+		assert.Equal(t, http.StatusServiceUnavailable, ready())
+	})
+	t.Run("tracks flaps and the last success and failure", func(t *testing.T) {
+		db.err = nil
+		m.Probe(context.Background())
+		report := m.Snapshot()
+		mysql := report.Checks["mysql"]
+		assert.Equal(t, 2, mysql.Flaps)
+		assert.NotNil(t, mysql.LastSuccess)
+		assert.NotNil(t, mysql.LastFailure)
+		assert.Equal(t, 0, report.Checks["redis"].Flaps)
+		assert.Nil(t, report.Checks["redis"].LastFailure)
+	})
+	t.Run("is not ready while draining", func(t *testing.T) {
+		m.Probe(context.Background())
+		assert.Equal(t, http.StatusOK, ready())
+		m.Drain()
+		assert.Equal(t, http.StatusServiceUnavailable, ready())
 		res := httptest.NewRecorder()
-		Health(&db)(res, req)
-		result := res.Result()
-		assert.Equal(t, http.StatusBadGateway, result.StatusCode)
+		m.Live(res, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+	t.Run("probes until the context is done", func(t *testing.T) {
+		m := NewHealthMonitor(NewHealthChecker(Check{Name: "mysql", Pinger: &db}), HealthMonitorOptions{Interval: 10 * time.Millisecond})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			m.Run(ctx)
+			close(done)
+		}()
+		assert.Eventually(t, func() bool {
+			return m.Snapshot().Status == StatusUp
+		}, time.Second, 5*time.Millisecond)
+		cancel()
+		<-done
 	})
 }
@@ -1,53 +1,250 @@
 package server
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/halilylm/microservice/apikey"
+	apikeyhttp "github.com/halilylm/microservice/apikey/delivery/http"
+	apikeyrepository "github.com/halilylm/microservice/apikey/repository"
+	apikeycache "github.com/halilylm/microservice/apikey/repository/cache"
+	apikeymemory "github.com/halilylm/microservice/apikey/repository/memory"
+	apikeymysql "github.com/halilylm/microservice/apikey/repository/mysql"
+	apikeyusecase "github.com/halilylm/microservice/apikey/usecase"
+	categoryhttp "github.com/halilylm/microservice/category/delivery/http"
+	categoryrepository "github.com/halilylm/microservice/category/repository"
+	categorycache "github.com/halilylm/microservice/category/repository/cache"
+	categorymemory "github.com/halilylm/microservice/category/repository/memory"
+	categorymysql "github.com/halilylm/microservice/category/repository/mysql"
+	categoryusecase "github.com/halilylm/microservice/category/usecase"
 	m "github.com/halilylm/microservice/http/middleware"
-	"github.com/halilylm/microservice/pkg/database"
-	"github.com/halilylm/microservice/product/delivery/http"
+	"github.com/halilylm/microservice/pkg/breaker"
+	"github.com/halilylm/microservice/pkg/rbac"
+	"github.com/halilylm/microservice/pkg/rest"
+	producthttp "github.com/halilylm/microservice/product/delivery/http"
+	"github.com/halilylm/microservice/product/repository"
+	productbreaker "github.com/halilylm/microservice/product/repository/breaker"
 	"github.com/halilylm/microservice/product/repository/cache"
 	"github.com/halilylm/microservice/product/repository/mysql"
+	"github.com/halilylm/microservice/product/repository/postgres"
+	"github.com/halilylm/microservice/product/repository/tiered"
 	"github.com/halilylm/microservice/product/usecase"
+	"go.uber.org/zap"
+	"net/http"
 	"time"
 )
 
-func (s *Server) mapRoutes() {
+func (s *Server) mapRoutes() error {
 	s.logger.Debug("mapping the routes")
 	s.mux.Use(middleware.RequestID)
 	s.mux.Use(middleware.RealIP)
-	s.mux.Use(m.RequestLogger(s.logger))
+	s.mux.Use(m.ContextLogger(s.logger))
+	accessLog := s.accessLog
+	accessLog.Logger = s.logger
+	s.mux.Use(m.RequestLogger(accessLog))
 	s.mux.Use(middleware.Recoverer)
-	db, err := database.NewMysqlConn(database.MysqlConnOptions{
-		Host:                  "mysql",
-		Port:                  3306,
-		User:                  "root",
-		Password:              "secret",
-		Name:                  "products",
-		MaxOpenConnections:    25,
-		MaxIdleConnections:    25,
-		ConnectionMaxLifetime: 5 * time.Second,
-		ConnectionMaxIdleTime: 5 * time.Second,
-		Log:                   s.logger,
-	})
+	s.mux.Use(m.DBSession)
+	s.mux.NotFound(rest.NotFound)
+	s.mux.MethodNotAllowed(rest.MethodNotAllowed)
+	db, rdb := s.db, s.rdb
+	onBreakerChange := func(name string, from, to breaker.State) {
+		s.logger.Warn("circuit breaker changed state",
+			zap.String("breaker", name), zap.String("from", from.String()), zap.String("to", to.String()))
+	}
+	if db != nil {
+		s.goWorker(db.Router.Watch)
+	}
+	mysqlBreaker := breaker.New(breaker.Options{Name: "mysql", OnStateChange: onBreakerChange})
+	redisBreaker := breaker.New(breaker.Options{Name: "redis", OnStateChange: onBreakerChange})
+	postgresBreaker := breaker.New(breaker.Options{Name: "postgres", OnStateChange: onBreakerChange})
+	keys, err := m.NewKeySet(s.auth.JWKSFile, s.logger)
 	if err != nil {
-		s.logger.Fatal(err.Error())
+		return err
 	}
-	rdb := database.NewRedisConn(database.RedisOptions{
-		Host:     "redis",
-		Port:     6379,
-		Password: "",
-		DB:       0,
+	s.goWorker(func(ctx context.Context) {
+		keys.Watch(ctx, s.auth.JWKSReload)
 	})
+	policy := rbac.DefaultPolicy()
+	if s.auth.PolicyFile != "" {
+		if policy, err = rbac.LoadPolicy(s.auth.PolicyFile); err != nil {
+			return err
+		}
+	}
+	authz := rbac.NewEnforcer(policy, s.logger)
+	var keyRepo apikeyrepository.APIKeyRepository
+	if db != nil {
+		keyRepo = apikeymysql.NewAPIKeyRepository(db.DB)
+	} else {
+		// the keys don't outlive the process
+		keyRepo = apikeymemory.NewAPIKeyRepository()
+	}
+	var usageRepo apikeyrepository.UsageRepository
+	if rdb != nil {
+		usageRepo = apikeycache.NewUsageRepository(rdb.Client)
+	} else {
+		usageRepo = apikeymemory.NewUsageRepository()
+	}
+	kuc := apikeyusecase.NewAPIKeyUC(keyRepo, usageRepo, s.logger)
+	s.goWorker(func(ctx context.Context) {
+		s.flushAPIKeyUsage(ctx, kuc)
+	})
+	jwtAuth := m.JWTAuth(m.JWTOptions{
+		Keys:     keys,
+		Issuer:   s.auth.Issuer,
+		Audience: s.auth.Audience,
+		Leeway:   s.auth.Leeway,
+	})
+	// the categories list the products, they share the repositories
+	prepo, crepo := s.productRepositories(mysqlBreaker, postgresBreaker, redisBreaker)
+	// the products and the categories share it, deleting a product unlinks it
+	catRepo := s.categoryRepository()
 	s.mux.Route("/api", func(r chi.Router) {
 		r.Route("/v1", func(r chi.Router) {
+			r.Use(m.APIKeyAuth(kuc))
+			r.Use(jwtAuth)
+			r.Use(m.RateLimit(m.RateLimitOptions{
+				Limiter: s.limiter(),
+				Key:     m.KeyByPrincipal,
+				Default: m.Limit{Requests: 300, Period: time.Minute},
+				Routes: map[string]m.Limit{
+					"GET /api/v1/products/{slug}": {Requests: 120, Period: time.Minute},
+					"POST /api/v1/products/":      {Requests: 30, Period: time.Minute},
+				},
+				Logger: s.logger,
+			}))
+			r.Use(m.Idempotency(m.IdempotencyOptions{
+				Store:  s.idempotencyStore(),
+				TTL:    24 * time.Hour,
+				Wait:   2 * time.Second,
+				Logger: s.logger,
+			}))
 			r.Route("/products", func(r chi.Router) {
-				crepo := cache.NewProductRepository(rdb.Client)
-				prepo := mysql.NewProductRepository(db.DB)
-				puc := usecase.NewProductUC(prepo, crepo, s.logger)
-				http.NewProductHandler(puc, r)
+				r.Use(m.RequireScopes(m.ScopesByMethod{
+					http.MethodGet:     apikey.ScopeProductsRead,
+					http.MethodHead:    apikey.ScopeProductsRead,
+					http.MethodOptions: apikey.ScopeProductsRead,
+					http.MethodPost:    apikey.ScopeProductsWrite,
+					http.MethodPut:     apikey.ScopeProductsWrite,
+					http.MethodPatch:   apikey.ScopeProductsWrite,
+					http.MethodDelete:  apikey.ScopeProductsWrite,
+				}))
+				puc := usecase.NewProductUC(prepo, crepo, authz, s.logger, usecase.WithOnDelete(catRepo.DeleteProductLinks))
+				producthttp.NewProductHandler(puc, r)
+			})
+			r.Route("/categories", func(r chi.Router) {
+				r.Use(m.RequireScopes(m.ScopesByMethod{
+					http.MethodGet:     apikey.ScopeProductsRead,
+					http.MethodHead:    apikey.ScopeProductsRead,
+					http.MethodOptions: apikey.ScopeProductsRead,
+					http.MethodPost:    apikey.ScopeCategoriesWrite,
+					http.MethodPut:     apikey.ScopeCategoriesWrite,
+					http.MethodDelete:  apikey.ScopeCategoriesWrite,
+				}))
+				cuc := categoryusecase.NewCategoryUC(catRepo, s.categoryCache(), prepo, authz, s.logger)
+				categoryhttp.NewCategoryHandler(cuc, r)
+			})
+			r.Route("/admin/api-keys", func(r chi.Router) {
+				r.Use(m.RequireScope(apikey.ScopeManage))
+				apikeyhttp.NewAPIKeyHandler(kuc, r)
 			})
 		})
 	})
-	s.mux.Get("/health", Health(db, rdb))
+	var checks []Check
+	if rdb != nil {
+		// the products are served from the database when the cache is down
+		checks = append(checks, Check{Name: "redis", Pinger: rdb, Criticality: Degraded, Breaker: redisBreaker})
+	}
+	if db != nil {
+		checks = append(checks, Check{Name: "mysql", Pinger: db, Criticality: Critical, Breaker: mysqlBreaker})
+	}
+	if s.pg != nil {
+		checks = append(checks, Check{Name: "postgres", Pinger: s.pg, Criticality: Critical, Breaker: postgresBreaker})
+	}
+	health := NewHealthMonitor(NewHealthChecker(checks...), HealthMonitorOptions{Interval: 10 * time.Second, Logger: s.logger})
+	s.health = health
+	s.goWorker(health.Run)
+	s.mux.Route("/health", func(r chi.Router) {
+		r.Get("/", health.Ready)
+		r.Get("/live", health.Live)
+		r.Get("/ready", health.Ready)
+		r.Get("/startup", health.Startup)
+	})
+	return nil
+}
+
+// productRepositories returns the repositories of the configured product
+// storage, the ones over the network behind their breakers.
+func (s *Server) productRepositories(mysqlBreaker, postgresBreaker, redisBreaker *breaker.Breaker) (repository.ProductRepository, repository.ProductCacheRepository) {
+	if s.productStorage == StorageMemory {
+		s.goWorker(func(ctx context.Context) {
+			s.memoryCache.Run(ctx, time.Minute)
+		})
+		return s.memory, s.memoryCache
+	}
+	// the hottest products are served from the process, the replicas drop
+	// them as soon as one of them changes them
+	crepo := tiered.NewProductCacheRepository(
+		productbreaker.NewProductCacheRepository(cache.NewProductRepository(s.rdb.Client, cache.WithCompression(1024), cache.WithLogger(s.logger)), redisBreaker),
+		tiered.Options{Name: "products", MaxEntries: 10000, TTL: 2 * time.Second, Client: s.rdb.Client, Logger: s.logger},
+	)
+	s.goWorker(crepo.Run)
+	if s.productStorage == StoragePostgres {
+		return productbreaker.NewProductRepository(postgres.NewProductRepository(s.pg.DB), postgresBreaker), crepo
+	}
+	return productbreaker.NewProductRepository(mysql.NewProductRepository(s.db.DB, mysql.WithRouter(s.db.Router)), mysqlBreaker), crepo
+}
+
+// limiter shares the rate limits through redis, they are counted in the
+// process while redis is down or when there is none.
+func (s *Server) limiter() m.Limiter {
+	if s.rdb == nil {
+		return m.NewLocalLimiter()
+	}
+	return m.NewFallbackLimiter(m.NewRedisLimiter(s.rdb.Client, m.TokenBucket), m.NewLocalLimiter(), s.logger)
+}
+
+func (s *Server) idempotencyStore() m.IdempotencyStore {
+	if s.rdb == nil {
+		return m.NewLocalIdempotencyStore()
+	}
+	return m.NewRedisIdempotencyStore(s.rdb.Client)
+}
+
+func (s *Server) categoryCache() categoryrepository.CategoryCacheRepository {
+	if s.rdb == nil {
+		return categorymemory.NewCategoryCacheRepository()
+	}
+	return categorycache.NewCategoryRepository(s.rdb.Client)
+}
+
+// categoryRepository returns the mysql categories, or the ones of the process
+// when there is no mysql.
+func (s *Server) categoryRepository() categoryrepository.CategoryRepository {
+	if s.db == nil {
+		return categorymemory.NewCategoryRepository()
+	}
+	return categorymysql.NewCategoryRepository(s.db.DB)
+}
+
+// flushAPIKeyUsage periodically writes the api key usage buffered in redis to
+// mysql until ctx is done.
+func (s *Server) flushAPIKeyUsage(ctx context.Context, uc apikeyusecase.APIKeyUseCase) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// flush what is left with a fresh context, ctx is already done
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := uc.FlushUsage(flushCtx); err != nil {
+				s.logger.Error("could not flush the api key usage", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if err := uc.FlushUsage(ctx); err != nil {
+				s.logger.Error("could not flush the api key usage", zap.Error(err))
+			}
+		}
+	}
 }
@@ -2,26 +2,129 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	m "github.com/halilylm/microservice/http/middleware"
+	"github.com/halilylm/microservice/pkg/database"
+	"github.com/halilylm/microservice/pkg/lifecycle"
+	"github.com/halilylm/microservice/pkg/tlsconfig"
+	"github.com/halilylm/microservice/product/repository/memory"
 	"go.uber.org/zap"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type Server struct {
-	address string
-	mux     chi.Router
-	server  *http.Server
-	logger  *zap.Logger
+	address         string
+	mux             chi.Router
+	server          *http.Server
+	admin           *http.Server
+	logger          *zap.Logger
+	logLevel        zap.AtomicLevel
+	auth            AuthOptions
+	accessLog       m.RequestLoggerOptions
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+	tls             TLSOptions
+	mysqlReplicas   []database.ReplicaOptions
+	mysqlTLS        *tls.Config
+	productStorage  string
+	redis           database.RedisOptions
+	db              *database.MysqlConn
+	pg              *database.PostgresConn
+	memorySnapshot  string
+	memory          *memory.ProductRepository
+	memoryCache     *memory.ProductCacheRepository
+	rdb             *database.RedisConn
+	health          *HealthMonitor
+	workers         sync.WaitGroup
+	ctx             context.Context
+	cancel          context.CancelFunc
 }
 
 type Options struct {
 	Host   string
 	Port   int
 	Logger *zap.Logger
+	// LogLevel is the level of Logger, the admin server reads and changes
+	// it when it is set.
+	LogLevel zap.AtomicLevel
+	Auth     AuthOptions
+	// AccessLog configures the log line of every request, its logger is
+	// Logger.
+	AccessLog m.RequestLoggerOptions
+	// Admin is the internal listener of the profiles, the runtime stats,
+	// the log level and the configuration, it never shares the public
+	// router.
+	Admin AdminOptions
+	// DrainPeriod is how long the server keeps serving after readiness went
+	// down, so load balancers stop sending requests before it stops
+	// listening.
+	DrainPeriod time.Duration
+	// ShutdownTimeout bounds waiting for in-flight requests, 15 seconds by
+	// default.
+	ShutdownTimeout time.Duration
+	// TLS serves https when its certificate is set, plain http otherwise.
+	TLS TLSOptions
+	// MysqlReplicas serve the product reads that tolerate replication lag.
+	MysqlReplicas []database.ReplicaOptions
+	// MysqlTLS encrypts the mysql connections when it is not nil.
+	MysqlTLS *tls.Config
+	// ProductStorage is the database of the products, StorageMysql by
+	// default. The api keys stay in mysql, except with StorageMemory.
+	ProductStorage string
+	// Redis is the redis deployment, the standalone node redis:6379 by
+	// default.
+	Redis database.RedisOptions
+	// MemorySnapshot is the json file StorageMemory loads the products from
+	// on start and saves them to on stop, they are lost when it is empty.
+	MemorySnapshot string
+}
+
+const (
+	StorageMysql    = "mysql"
+	StoragePostgres = "postgres"
+	// StorageMemory runs with no container: the products, the api keys, the
+	// caches, the rate limits and the idempotency records are kept in the
+	// process.
+	StorageMemory = "memory"
+)
+
+// AdminOptions is the address of the admin server, it is disabled when the
+// port is 0. It serves plain http with no authentication, pprof and the log
+// level included, so it must not be published: bind it to loopback or to a
+// private network only.
+type AdminOptions struct {
+	Host string
+	Port int
+}
+
+// TLSOptions configures https. The certificate is reloaded when its files
+// change, so it can be renewed without a restart.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// Reload is how often the files are checked for changes, 30 seconds by
+	// default.
+	Reload time.Duration
+	tlsconfig.ServerOptions
+}
+
+// AuthOptions configures authentication and authorization of the api routes.
+type AuthOptions struct {
+	JWKSFile   string
+	JWKSReload time.Duration
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+	// PolicyFile is the role based access control policy, the default
+	// policy is used when it is empty.
+	PolicyFile string
 }
 
 func New(opts *Options) *Server {
@@ -38,23 +141,108 @@ func New(opts *Options) *Server {
 		WriteTimeout:      5 * time.Second,
 		IdleTimeout:       5 * time.Second,
 	}
+	if opts.Auth.JWKSReload == 0 {
+		opts.Auth.JWKSReload = 30 * time.Second
+	}
+	if opts.TLS.Reload == 0 {
+		opts.TLS.Reload = 30 * time.Second
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = 15 * time.Second
+	}
+	if opts.Redis.Mode == database.RedisStandalone && opts.Redis.Host == "" {
+		opts.Redis.Host, opts.Redis.Port = "redis", 6379
+	}
+	if opts.ProductStorage == "" {
+		opts.ProductStorage = StorageMysql
+	}
+	var admin *http.Server
+	if opts.Admin.Port != 0 {
+		admin = &http.Server{
+			Addr:              net.JoinHostPort(opts.Admin.Host, strconv.Itoa(opts.Admin.Port)),
+			ReadHeaderTimeout: 5 * time.Second,
+			// the cpu profiles and the traces last 30 seconds by default
+			WriteTimeout: 2 * time.Minute,
+			IdleTimeout:  time.Minute,
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		address: address,
-		mux:     mux,
-		server:  &srv,
-		logger:  opts.Logger,
+		address:         address,
+		mux:             mux,
+		server:          &srv,
+		admin:           admin,
+		logger:          opts.Logger,
+		logLevel:        opts.LogLevel,
+		auth:            opts.Auth,
+		accessLog:       opts.AccessLog,
+		drainPeriod:     opts.DrainPeriod,
+		shutdownTimeout: opts.ShutdownTimeout,
+		tls:             opts.TLS,
+		mysqlReplicas:   opts.MysqlReplicas,
+		mysqlTLS:        opts.MysqlTLS,
+		productStorage:  opts.ProductStorage,
+		memorySnapshot:  opts.MemorySnapshot,
+		redis:           opts.Redis,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// Components returns the parts of the server in the order they depend on
+// each other: the admin listener, the connection pools, the routes with
+// their background workers, the http listener and the readiness. They are
+// stopped in reverse, readiness goes down first and the admin listener
+// stops last so the shutdown can be profiled. onError is called when a
+// listener fails after it started.
+func (s *Server) Components(onError func(error)) []lifecycle.Component {
+	return []lifecycle.Component{
+		{
+			Name:  "admin",
+			Start: func(ctx context.Context) error { return s.listenAdmin(onError) },
+			Stop:  s.shutdownAdmin,
+		},
+		{Name: "pools", Start: s.connect, Stop: s.closePools},
+		{Name: "workers", Start: func(context.Context) error { return s.mapRoutes() }, Stop: s.stopWorkers},
+		{
+			Name:        "http",
+			Start:       func(ctx context.Context) error { return s.listen(ctx, onError) },
+			Stop:        s.server.Shutdown,
+			StopTimeout: s.shutdownTimeout,
+		},
+		{Name: "readiness", Stop: s.drain, StopTimeout: s.drainPeriod + time.Second},
 	}
 }
 
+// Start connects, maps the routes and serves until Stop is called.
 func (s *Server) Start() error {
-	s.mapRoutes()
+	if err := s.listenAdmin(nil); err != nil {
+		return err
+	}
+	if err := s.connect(s.ctx); err != nil {
+		return err
+	}
+	if err := s.mapRoutes(); err != nil {
+		return err
+	}
+	if err := s.configureTLS(); err != nil {
+		return err
+	}
 	s.logger.Info("starting the server at ", zap.String("address", s.address))
-	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var err error
+	if s.server.TLSConfig != nil {
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
+// Stop shuts the server down without draining, the lifecycle components
+// should be preferred.
 func (s *Server) Stop() error {
 	s.logger.Info("stopping the server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -62,5 +250,195 @@ func (s *Server) Stop() error {
 	if err := s.server.Shutdown(ctx); err != nil {
 		return err
 	}
+	if err := s.stopWorkers(ctx); err != nil {
+		return err
+	}
+	if err := s.closePools(ctx); err != nil {
+		return err
+	}
+	return s.shutdownAdmin(ctx)
+}
+
+func (s *Server) connect(ctx context.Context) error {
+	if s.productStorage == StorageMemory {
+		return s.connectMemory()
+	}
+	db, err := database.NewMysqlConn(s.mysqlOptions())
+	if err != nil {
+		return err
+	}
+	s.db = db
+	switch s.productStorage {
+	case StorageMysql:
+	case StoragePostgres:
+		pg, err := database.NewPostgresConn(s.postgresOptions())
+		if err != nil {
+			return err
+		}
+		s.pg = pg
+	default:
+		return fmt.Errorf("unknown product storage %q", s.productStorage)
+	}
+	s.rdb = database.NewRedisConn(s.redis)
 	return nil
 }
+
+func (s *Server) mysqlOptions() database.MysqlConnOptions {
+	return database.MysqlConnOptions{
+		Host:                  "mysql",
+		Port:                  3306,
+		User:                  "root",
+		Password:              "secret",
+		Name:                  "products",
+		MaxOpenConnections:    25,
+		MaxIdleConnections:    25,
+		ConnectionMaxLifetime: 5 * time.Second,
+		ConnectionMaxIdleTime: 5 * time.Second,
+		Replicas:              s.mysqlReplicas,
+		TLS:                   s.mysqlTLS,
+		Log:                   s.logger,
+	}
+}
+
+func (s *Server) postgresOptions() database.PostgresConnOptions {
+	return database.PostgresConnOptions{
+		Host:                  "postgres",
+		Port:                  5432,
+		User:                  "postgres",
+		Password:              "secret",
+		Name:                  "products",
+		MaxOpenConnections:    25,
+		MaxIdleConnections:    25,
+		ConnectionMaxLifetime: 5 * time.Second,
+		ConnectionMaxIdleTime: 5 * time.Second,
+	}
+}
+
+// connectMemory loads the products, nothing runs on redis in this mode.
+func (s *Server) connectMemory() error {
+	s.memory = memory.NewProductRepository(memory.WithSnapshot(s.memorySnapshot))
+	if err := s.memory.Load(); err != nil {
+		return err
+	}
+	s.memoryCache = memory.NewProductCacheRepository()
+	s.logger.Info("running with in-memory storage", zap.String("snapshot", s.memorySnapshot))
+	return nil
+}
+
+func (s *Server) closePools(ctx context.Context) error {
+	var firstErr error
+	if s.memory != nil {
+		if err := s.memory.Save(); err != nil {
+			s.logger.Error("could not save the products snapshot", zap.Error(err))
+			firstErr = err
+		}
+	}
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			s.logger.Error("could not close the mysql pool", zap.Error(err))
+			firstErr = err
+		}
+	}
+	if s.pg != nil {
+		if err := s.pg.Close(); err != nil {
+			s.logger.Error("could not close the postgres pool", zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if s.rdb != nil {
+		if err := s.rdb.Close(); err != nil {
+			s.logger.Error("could not close the redis pool", zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) listen(ctx context.Context, onError func(error)) error {
+	if err := s.configureTLS(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("starting the server at ", zap.String("address", s.address), zap.Bool("tls", s.server.TLSConfig != nil))
+	go func() {
+		serve := s.server.Serve
+		if s.server.TLSConfig != nil {
+			serve = func(l net.Listener) error {
+				// the certificate comes from TLSConfig.GetCertificate
+				return s.server.ServeTLS(l, "", "")
+			}
+		}
+		if err := serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) && onError != nil {
+			onError(err)
+		}
+	}()
+	return nil
+}
+
+// configureTLS loads the certificate and watches its files for renewals,
+// the server stays plain http without a certificate.
+func (s *Server) configureTLS() error {
+	if s.tls.CertFile == "" || s.server.TLSConfig != nil {
+		return nil
+	}
+	certs, err := tlsconfig.NewCertReloader(s.tls.CertFile, s.tls.KeyFile, s.logger)
+	if err != nil {
+		return err
+	}
+	cfg, err := tlsconfig.Server(certs, s.tls.ServerOptions)
+	if err != nil {
+		return err
+	}
+	s.server.TLSConfig = cfg
+	s.goWorker(func(ctx context.Context) {
+		certs.Watch(ctx, s.tls.Reload)
+	})
+	return nil
+}
+
+// goWorker runs fn in the background until the workers are stopped.
+func (s *Server) goWorker(fn func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn(s.ctx)
+	}()
+}
+
+// stopWorkers cancels the background workers and waits for them to return.
+func (s *Server) stopWorkers(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain takes the server out of rotation and keeps serving for the drain
+// period.
+func (s *Server) drain(ctx context.Context) error {
+	if s.health != nil {
+		s.health.Drain()
+	}
+	s.logger.Info("draining the server", zap.Duration("period", s.drainPeriod))
+	select {
+	case <-time.After(s.drainPeriod):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}